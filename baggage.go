@@ -0,0 +1,55 @@
+package structhttp
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// baggageKey is the context key for request baggage.
+type baggageKey struct{}
+
+// BaggageFromContext returns the W3C baggage attached to ctx by a
+// Handler configured with WithContextBaggage, keyed by member name.
+// Member properties are discarded. It returns nil if no baggage is
+// present.
+func BaggageFromContext(ctx context.Context) map[string]string {
+	baggage, _ := ctx.Value(baggageKey{}).(map[string]string)
+	return baggage
+}
+
+// withBaggage returns r with the members of its baggage headers
+// attached to its context.
+func withBaggage(r *http.Request) *http.Request {
+	baggage := parseBaggage(r.Header.Values("Baggage"))
+	if len(baggage) == 0 {
+		return r
+	}
+	return r.WithContext(context.WithValue(r.Context(), baggageKey{}, baggage))
+}
+
+// parseBaggage parses W3C baggage header values, skipping malformed
+// members.
+func parseBaggage(headers []string) map[string]string {
+	var baggage map[string]string
+	for _, header := range headers {
+		for _, member := range strings.Split(header, ",") {
+			member, _, _ = strings.Cut(member, ";")
+			key, value, ok := strings.Cut(member, "=")
+			key = strings.TrimSpace(key)
+			if !ok || key == "" {
+				continue
+			}
+			value, err := url.PathUnescape(strings.TrimSpace(value))
+			if err != nil {
+				continue
+			}
+			if baggage == nil {
+				baggage = make(map[string]string)
+			}
+			baggage[key] = value
+		}
+	}
+	return baggage
+}
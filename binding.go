@@ -0,0 +1,729 @@
+package structhttp
+
+import (
+	"encoding"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TypeConverterFunc is a function that parses a value of the type it
+// is registered for from a path variable, query parameter, header,
+// cookie, or form value. It returns a value of that type, or an error
+// resulting in a 400 response.
+type TypeConverterFunc func(s string) (any, error)
+
+// converters are the TypeConverterFuncs registered with
+// RegisterTypeConverter, keyed by reflect.Type.
+var converters sync.Map
+
+// RegisterTypeConverter registers fn to parse values of type t bound
+// by every Handler, such as for a library's own ID types. A converter
+// given to a Handler with WithTypeConverter takes precedence. It is
+// safe to call concurrently with requests.
+func RegisterTypeConverter(t reflect.Type, fn TypeConverterFunc) {
+	converters.Store(t, fn)
+}
+
+// converter returns the TypeConverterFunc for values of type t: the
+// one given with WithTypeConverter, or else the one registered with
+// RegisterTypeConverter.
+func (o *options) converter(t reflect.Type) (TypeConverterFunc, bool) {
+	if fn, ok := o.converters[t]; ok {
+		return fn, true
+	}
+	if fn, ok := converters.Load(t); ok {
+		return fn.(TypeConverterFunc), true
+	}
+	return nil, false
+}
+
+// TimeFormatUnix is a layout for WithTimeFormats accepting a time as
+// a number of seconds since the Unix epoch.
+const TimeFormatUnix = "unix"
+
+var (
+	timeType      = reflect.TypeOf(time.Time{})
+	durationType  = reflect.TypeOf(time.Duration(0))
+	flagValueType = reflect.TypeOf((*flag.Value)(nil)).Elem()
+)
+
+// bindHeaders sets the fields of v tagged with `header:"Name"` from
+// the request headers, parsed according to the field's type as by
+// setStrings. A slice field receives every value of the header,
+// including each element of comma-separated lists, such as "a, b". A
+// field whose tag includes the ",required" option causes an error
+// when the header is absent; otherwise missing headers leave the
+// field untouched.
+func (o *options) bindHeaders(v reflect.Value, h http.Header) error {
+	sv, ok := structTarget(v, "header")
+	if !ok {
+		return nil
+	}
+
+	st := sv.Type()
+	for i := 0; i < st.NumField(); i++ {
+		field := st.Field(i)
+		name, required, ok := parseTag(field, "header")
+		if !ok {
+			continue
+		}
+
+		values := h.Values(name)
+		if len(values) == 0 {
+			if required {
+				return fmt.Errorf("missing required header %q", name)
+			}
+			continue
+		}
+
+		if isList(sv.Field(i)) {
+			values = splitList(values)
+		}
+		if err := o.setStrings(sv.Field(i), values); err != nil {
+			return fmt.Errorf("invalid value for header %q: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// bindCookies sets the fields of v tagged with `cookie:"name"` from
+// the request cookies, parsed according to the field's type as by
+// setStrings. A field whose tag includes the ",required" option
+// causes an error when the cookie is absent; otherwise missing
+// cookies leave the field untouched.
+func (o *options) bindCookies(v reflect.Value, r *http.Request) error {
+	sv, ok := structTarget(v, "cookie")
+	if !ok {
+		return nil
+	}
+
+	cookies := r.Cookies()
+	st := sv.Type()
+	for i := 0; i < st.NumField(); i++ {
+		field := st.Field(i)
+		name, required, ok := parseTag(field, "cookie")
+		if !ok {
+			continue
+		}
+
+		var values []string
+		for _, c := range cookies {
+			if c.Name == name {
+				values = append(values, c.Value)
+			}
+		}
+		if len(values) == 0 {
+			if required {
+				return fmt.Errorf("missing required cookie %q", name)
+			}
+			continue
+		}
+
+		if err := o.setStrings(sv.Field(i), values); err != nil {
+			return fmt.Errorf("invalid value for cookie %q: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// bindQuery sets the fields of v from the query parameters. Each
+// field is bound from the parameter named by its `query` tag, or
+// else by the name in its `json` tag, or else by its field name.
+// Fields tagged `query:"-"` or `json:"-"` are skipped. Values are
+// parsed according to the field's type as by setStrings, so a slice
+// field collects a repeated parameter, and each value is split at
+// commas, so that ?id=1&id=2 and ?id=1,2 are equivalent. A slice that
+// is not a struct is bound, in the same way, from the sole query
+// parameter, if there is exactly one.
+func (o *options) bindQuery(v reflect.Value, query url.Values) error {
+	if !isStructType(v.Type()) {
+		if len(query) != 1 || !isList(v) {
+			return nil
+		}
+		for name, values := range query {
+			if err := o.setStrings(v, splitList(values)); err != nil {
+				return fmt.Errorf("invalid value for query parameter %q: %w", name, err)
+			}
+		}
+		return nil
+	}
+	return o.bindValues(v, query, "query", "query parameter")
+}
+
+// bindPath sets v from the path variables in params. A struct is
+// bound field by field as by bindValues with the `path` tag; any
+// other value is set from the sole variable, if there is exactly one.
+func (o *options) bindPath(v reflect.Value, params map[string]string) error {
+	if len(params) == 0 {
+		return nil
+	}
+	if !isStructType(v.Type()) {
+		if len(params) != 1 {
+			return nil
+		}
+		for name, value := range params {
+			if err := o.setString(v, value); err != nil {
+				return fmt.Errorf("invalid value for path parameter %q: %w", name, err)
+			}
+		}
+		return nil
+	}
+
+	values := make(url.Values, len(params))
+	for name, value := range params {
+		values.Set(name, value)
+	}
+	return o.bindValues(v, values, "path", "path parameter")
+}
+
+// bindValues sets the fields of v from values, naming fields as
+// described for bindQuery with the given tag. Names are matched
+// exactly if possible, or else case-insensitively. A required field is
+// missing only if it is absent from values and still zero, as it may
+// have been set from another source. Fields with a `file` tag are
+// left for bindFiles. The kind describes the values in error
+// messages.
+//
+// A field holding a struct is bound from the values named with its
+// name as a prefix, in dot or bracket notation, so that filter.status
+// and filter[status] both set the Status field of a Filter field. The
+// fields of an embedded struct are bound as if they were v's own.
+func (o *options) bindValues(v reflect.Value, values url.Values, tag, kind string) error {
+	return o.bindFields(v, values, tag, kind, "")
+}
+
+// bindFields binds the fields of v as described for bindValues. The
+// prefix names the struct in error messages, such as "filter." for
+// the fields of a nested filter struct.
+func (o *options) bindFields(v reflect.Value, values url.Values, tag, kind, prefix string) error {
+	sv, ok := structTarget(v, "")
+	if !ok {
+		return nil
+	}
+
+	st := sv.Type()
+	for i := 0; i < st.NumField(); i++ {
+		field := st.Field(i)
+		if _, ok := field.Tag.Lookup("file"); ok {
+			continue
+		}
+		if _, tagged := field.Tag.Lookup(tag); !tagged && field.Anonymous && o.isNested(field.Type) {
+			if field.IsExported() || field.Type.Kind() != reflect.Pointer {
+				if err := o.bindFields(sv.Field(i), values, tag, kind, prefix); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		name, required, ok := valueName(field, tag)
+		if !ok {
+			continue
+		}
+
+		if o.isNested(field.Type) {
+			if nested := nestedValues(values, name); len(nested) > 0 {
+				if err := o.bindFields(sv.Field(i), nested, tag, kind, prefix+name+"."); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		vals := lookup(values, name)
+		if len(vals) == 0 {
+			if required && sv.Field(i).IsZero() {
+				return fmt.Errorf("missing required %s %q", kind, prefix+name)
+			}
+			continue
+		}
+
+		if tag == "query" && isList(sv.Field(i)) {
+			vals = splitList(vals)
+		}
+		if err := o.setStrings(sv.Field(i), vals); err != nil {
+			return fmt.Errorf("invalid value for %s %q: %w", kind, prefix+name, err)
+		}
+	}
+	return nil
+}
+
+// applyDefaults sets the fields of v tagged `default:"value"` to their
+// default values, parsed as by setStrings, with the value split at
+// commas for a slice. It is applied before anything is decoded into
+// v, so that the defaults remain only in fields no source provides.
+// The fields of nested and embedded structs are set in turn, but
+// pointers to structs are left nil.
+func (o *options) applyDefaults(v reflect.Value) error {
+	if !o.hasDefaults(v.Type()) {
+		return nil
+	}
+	sv, _ := structTarget(v, "")
+
+	st := sv.Type()
+	for i := 0; i < st.NumField(); i++ {
+		field := st.Field(i)
+		if !field.IsExported() && !field.Anonymous {
+			continue
+		}
+		def, ok := field.Tag.Lookup("default")
+		if !ok {
+			if field.Type.Kind() == reflect.Struct {
+				if err := o.applyDefaults(sv.Field(i)); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		values := []string{def}
+		if isList(sv.Field(i)) {
+			values = splitList(values)
+		}
+		if len(values) == 0 {
+			continue
+		}
+		if err := o.setStrings(sv.Field(i), values); err != nil {
+			return fmt.Errorf("invalid default for field %s: %w", field.Name, err)
+		}
+	}
+	return nil
+}
+
+// isDefault reports whether the pointer v holds nothing but the values
+// set by applyDefaults, treating a nil pointer as one to a zero value.
+func (o *options) isDefault(v reflect.Value) bool {
+	def := reflect.New(v.Type()).Elem()
+	if err := o.applyDefaults(def); err != nil {
+		return false
+	}
+	return reflect.DeepEqual(pointee(v), pointee(def))
+}
+
+// pointee returns the value the pointer v points to, or the zero value
+// if v is nil.
+func pointee(v reflect.Value) any {
+	if v.IsNil() {
+		return reflect.Zero(v.Type().Elem()).Interface()
+	}
+	return v.Elem().Interface()
+}
+
+// checkRequired returns a MissingFieldsError naming the fields of v
+// tagged `required:"true"` that are still zero once every source has
+// been bound, or nil if there are none. The fields of nested and
+// embedded structs are checked in turn, those of a pointer to a
+// struct only if it is set.
+func (o *options) checkRequired(v reflect.Value) error {
+	if missing := o.missingFields(v, "", nil); len(missing) > 0 {
+		return &MissingFieldsError{Fields: missing}
+	}
+	return nil
+}
+
+// missingFields appends the names of the missing required fields of
+// v, qualified by prefix, to missing.
+func (o *options) missingFields(v reflect.Value, prefix string, missing []string) []string {
+	for v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return missing
+		}
+		v = v.Elem()
+	}
+	if !o.isNested(v.Type()) {
+		return missing
+	}
+
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Anonymous && o.isNested(field.Type) {
+			missing = o.missingFields(v.Field(i), prefix, missing)
+			continue
+		}
+		name, _, ok := valueName(field, "")
+		if !ok {
+			continue
+		}
+		if field.Tag.Get("required") == "true" && v.Field(i).IsZero() {
+			missing = append(missing, prefix+name)
+			continue
+		}
+		if o.isNested(field.Type) {
+			missing = o.missingFields(v.Field(i), prefix+name+".", missing)
+		}
+	}
+	return missing
+}
+
+// hasDefaults reports whether t, or a pointer to t, is a struct with
+// fields set by applyDefaults.
+func (o *options) hasDefaults(t reflect.Type) bool {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if !o.isNested(t) {
+		return false
+	}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if _, ok := field.Tag.Lookup("default"); ok && (field.IsExported() || field.Anonymous) {
+			return true
+		}
+		if field.Type.Kind() == reflect.Struct && o.hasDefaults(field.Type) {
+			return true
+		}
+	}
+	return false
+}
+
+// isNested reports whether a field of type t is bound from nested
+// values by bindValues: a struct, or pointer to one, that is not
+// parsed from a single string by setString.
+func (o *options) isNested(t reflect.Type) bool {
+	for t.Kind() == reflect.Pointer {
+		if _, ok := o.converter(t); ok {
+			return false
+		}
+		t = t.Elem()
+	}
+	return t.Kind() == reflect.Struct && !o.isScalarType(t)
+}
+
+// nestedValues returns the values named with the given prefix, in dot
+// or bracket notation, keyed by the rest of their names: "status" for
+// both "filter.status" and "filter[status]" given the prefix
+// "filter". The prefix is matched case-insensitively.
+func nestedValues(values url.Values, prefix string) url.Values {
+	var nested url.Values
+	for key, vals := range values {
+		if len(key) <= len(prefix) || !strings.EqualFold(key[:len(prefix)], prefix) {
+			continue
+		}
+		rest := key[len(prefix):]
+		switch rest[0] {
+		case '.':
+			rest = rest[1:]
+		case '[':
+			inner, after, ok := strings.Cut(rest[1:], "]")
+			if !ok {
+				continue
+			}
+			if after != "" && after[0] != '.' && after[0] != '[' {
+				continue
+			}
+			rest = inner + after
+		default:
+			continue
+		}
+		if rest == "" {
+			continue
+		}
+		if nested == nil {
+			nested = make(url.Values)
+		}
+		nested[rest] = append(nested[rest], vals...)
+	}
+	return nested
+}
+
+// lookup returns the values for name, or for a name equal to it under
+// case folding if there is no exact match.
+func lookup(values url.Values, name string) []string {
+	if vals, ok := values[name]; ok {
+		return vals
+	}
+	for key, vals := range values {
+		if strings.EqualFold(key, name) {
+			return vals
+		}
+	}
+	return nil
+}
+
+// valueName returns the name of the value bound to a field: the name
+// in its tag, or else in its `json` tag, or else its field name.
+func valueName(field reflect.StructField, tag string) (name string, required bool, ok bool) {
+	if _, tagged := field.Tag.Lookup(tag); tagged {
+		return parseTag(field, tag)
+	}
+	if !field.IsExported() {
+		return "", false, false
+	}
+
+	name, _, _ = strings.Cut(field.Tag.Get("json"), ",")
+	switch name {
+	case "-":
+		return "", false, false
+	case "":
+		return field.Name, false, true
+	default:
+		return name, false, true
+	}
+}
+
+// structTarget returns the settable struct value behind v, allocating
+// intermediate pointers as needed. It reports false if v is not a
+// struct (or pointer to one) or, if tag is not empty, has no field
+// carrying the given tag.
+func structTarget(v reflect.Value, tag string) (reflect.Value, bool) {
+	t := v.Type()
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct || (tag != "" && !hasTaggedField(t, tag)) {
+		return reflect.Value{}, false
+	}
+
+	for v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		v = v.Elem()
+	}
+	return v, true
+}
+
+func hasTaggedField(t reflect.Type, tag string) bool {
+	for i := 0; i < t.NumField(); i++ {
+		if _, _, ok := parseTag(t.Field(i), tag); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// parseTag returns the name and whether the ",required" option is
+// set for the given tag on an exported field.
+func parseTag(field reflect.StructField, tag string) (name string, required bool, ok bool) {
+	if !field.IsExported() {
+		return "", false, false
+	}
+	value, ok := field.Tag.Lookup(tag)
+	if !ok || value == "-" {
+		return "", false, false
+	}
+
+	name, opts, _ := strings.Cut(value, ",")
+	if name == "" {
+		name = field.Name
+	}
+	for opts != "" {
+		var opt string
+		opt, opts, _ = strings.Cut(opts, ",")
+		if opt == "required" {
+			required = true
+		}
+	}
+	return name, required, true
+}
+
+// setStrings parses values into v. A slice, other than a byte slice,
+// is set to all of the values, such as those of a repeated query
+// parameter, and a flag.Value, unless it implements
+// encoding.TextUnmarshaler, is Set with each value in turn, as for a
+// repeated command-line flag; anything else, including a type with a
+// TypeConverterFunc, is set from the first.
+func (o *options) setStrings(v reflect.Value, values []string) error {
+	if _, ok := o.converter(v.Type()); ok {
+		return o.setString(v, values[0])
+	}
+	if implementsFlag(v) && !implementsText(v) && v.CanAddr() {
+		for _, s := range values {
+			if err := v.Addr().Interface().(flag.Value).Set(s); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if !isList(v) {
+		return o.setString(v, values[0])
+	}
+
+	slice := reflect.MakeSlice(v.Type(), len(values), len(values))
+	for i, s := range values {
+		if err := o.setString(slice.Index(i), s); err != nil {
+			return err
+		}
+	}
+	v.Set(slice)
+	return nil
+}
+
+// isList reports whether v is set from a list of values by
+// setStrings: a slice other than a byte slice or a type implementing
+// encoding.TextUnmarshaler or flag.Value.
+func isList(v reflect.Value) bool {
+	return v.Kind() == reflect.Slice && v.Type().Elem().Kind() != reflect.Uint8 && !implementsText(v) && !implementsFlag(v)
+}
+
+// splitList splits comma-separated values into their elements,
+// trimming surrounding whitespace and dropping empty elements.
+func splitList(values []string) []string {
+	var elems []string
+	for _, value := range values {
+		for _, elem := range strings.Split(value, ",") {
+			if elem = strings.TrimSpace(elem); elem != "" {
+				elems = append(elems, elem)
+			}
+		}
+	}
+	return elems
+}
+
+// implementsText reports whether a pointer to v implements
+// encoding.TextUnmarshaler.
+func implementsText(v reflect.Value) bool {
+	return reflect.PointerTo(v.Type()).Implements(textUnmarshalerType)
+}
+
+// implementsFlag reports whether a pointer to v implements
+// flag.Value.
+func implementsFlag(v reflect.Value) bool {
+	return reflect.PointerTo(v.Type()).Implements(flagValueType)
+}
+
+// isScalarType reports whether a value of type t is parsed from a
+// single string by setString, as from a path segment.
+func (o *options) isScalarType(t reflect.Type) bool {
+	if _, ok := o.converter(t); ok {
+		return true
+	}
+	if reflect.PointerTo(t).Implements(textUnmarshalerType) || reflect.PointerTo(t).Implements(flagValueType) {
+		return true
+	}
+	switch t.Kind() {
+	case reflect.String, reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// parseTime parses s in the first of the layouts given with
+// WithTimeFormats that accepts it.
+func (o *options) parseTime(s string) (time.Time, error) {
+	for _, layout := range o.timeFormats {
+		if layout == TimeFormatUnix {
+			if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+				return time.Unix(n, 0).UTC(), nil
+			}
+			continue
+		}
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("parsing time %q: no matching format in %q", s, o.timeFormats)
+}
+
+// bindPositional returns arguments of the given types parsed from the
+// values of e's path variables in params, in order.
+func (o *options) bindPositional(e endpoint, params map[string]string, types []reflect.Type) ([]any, error) {
+	args := make([]any, len(types))
+	for i, name := range e.variables() {
+		v := reflect.New(types[i]).Elem()
+		if err := o.setString(v, params[name]); err != nil {
+			return nil, fmt.Errorf("invalid value for path parameter %q: %w", name, err)
+		}
+		args[i] = v.Interface()
+	}
+	return args, nil
+}
+
+// setString parses s into v with the TypeConverterFunc for v's type,
+// if any, or else according to v's kind, or with its UnmarshalText
+// method if it implements encoding.TextUnmarshaler, such as
+// time.Time, or its Set method if it implements flag.Value. A
+// time.Time is parsed in the layouts given with WithTimeFormats, if
+// any, and a time.Duration as by time.ParseDuration.
+func (o *options) setString(v reflect.Value, s string) error {
+	if convert, ok := o.converter(v.Type()); ok {
+		x, err := convert(s)
+		if err != nil {
+			return err
+		}
+		xv := reflect.ValueOf(x)
+		if !xv.IsValid() || !xv.Type().AssignableTo(v.Type()) {
+			return fmt.Errorf("converter for %s returned %T", v.Type(), x)
+		}
+		v.Set(xv)
+		return nil
+	}
+
+	switch {
+	case v.Type() == timeType && len(o.timeFormats) > 0:
+		t, err := o.parseTime(s)
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(t))
+		return nil
+	case v.Type() == durationType:
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+	if implementsText(v) && v.CanAddr() {
+		return v.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s))
+	}
+	if implementsFlag(v) && v.CanAddr() {
+		return v.Addr().Interface().(flag.Value).Set(s)
+	}
+	if v.Kind() == reflect.Pointer {
+		elem := reflect.New(v.Type().Elem())
+		if err := o.setString(elem.Elem(), s); err != nil {
+			return err
+		}
+		v.Set(elem)
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.Uint8 {
+			return fmt.Errorf("unsupported type %s", v.Type())
+		}
+		v.SetBytes([]byte(s))
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
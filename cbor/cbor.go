@@ -0,0 +1,63 @@
+// Package cbor provides a structhttp.Codec for CBOR (RFC 8949), backed
+// by github.com/fxamacker/cbor:
+//
+//	structhttp.Handler(svc, structhttp.WithCodecs(cbor.Codec))
+package cbor
+
+import (
+	"io"
+	"reflect"
+
+	fxcbor "github.com/fxamacker/cbor/v2"
+
+	"github.com/jfhamlin/structhttp"
+)
+
+// ContentType is the Content-Type of CBOR bodies, that of Codec.
+const ContentType = "application/cbor"
+
+// Codec is the structhttp.Codec for ContentType, encoding with
+// ResponseEncoder and decoding with RequestDecoder.
+var Codec = structhttp.NewCodec(ContentType, ResponseEncoder, RequestDecoder)
+
+var (
+	encMode = must(fxcbor.EncOptions{
+		Sort:            fxcbor.SortCoreDeterministic,
+		Time:            fxcbor.TimeRFC3339Nano,
+		TimeTag:         fxcbor.EncTagRequired,
+		BinaryMarshaler: fxcbor.BinaryMarshalerNone,
+		TextMarshaler:   fxcbor.TextMarshalerTextString,
+	}.EncMode())
+
+	decMode = must(fxcbor.DecOptions{
+		DefaultMapType:    reflect.TypeOf(map[string]any(nil)),
+		BinaryUnmarshaler: fxcbor.BinaryUnmarshalerNone,
+		TextUnmarshaler:   fxcbor.TextUnmarshalerTextString,
+	}.DecMode())
+)
+
+func must[T any](mode T, err error) T {
+	if err != nil {
+		panic(err)
+	}
+	return mode
+}
+
+// ResponseEncoder is a structhttp.ResponseEncoder that encodes results
+// as CBOR. Structs are encoded as maps keyed by the names in their
+// `cbor` tags, or else their `json` tags, or else their field names,
+// and maps with their keys sorted, as in core deterministic encoding.
+// Values implementing encoding.TextMarshaler are encoded as text
+// strings, even if they also implement encoding.BinaryMarshaler, and
+// time.Time values as RFC 3339 strings with the standard date/time
+// tag.
+func ResponseEncoder(w io.Writer, v any) error {
+	return encMode.NewEncoder(w).Encode(v)
+}
+
+// RequestDecoder is a structhttp.RequestDecoder that decodes a CBOR
+// value into v, with the mapping of ResponseEncoder. Into an any, maps
+// are decoded as map[string]any.
+func RequestDecoder(r io.Reader, v any) error {
+	return decMode.NewDecoder(r).Decode(v)
+}
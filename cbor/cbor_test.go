@@ -0,0 +1,110 @@
+package cbor
+
+import (
+	"bytes"
+	"encoding/hex"
+	"net/http/httptest"
+	"net/netip"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jfhamlin/structhttp"
+)
+
+type record struct {
+	ID      int            `json:"id"`
+	Skipped string         `json:"-"`
+	Empty   string         `json:",omitempty"`
+	Addr    netip.Addr     `json:"addr"`
+	Created time.Time      `json:"created"`
+	Labels  map[string]int `json:"labels"`
+}
+
+func TestEncoding(t *testing.T) {
+	testCases := []struct {
+		name string
+		v    any
+		want string
+	}{
+		{"nil", nil, "f6"},
+		{"uint16", 1000, "1903e8"},
+		{"negative", -1, "20"},
+		{"float64", 1.1, "fb3ff199999999999a"},
+		{"bytes", []byte{1, 2}, "420102"},
+		{"nil slice", []int(nil), "f6"},
+		{"sorted map", map[string]int{"b": 2, "a": 1}, "a2616101616202"},
+		{"struct", struct {
+			ID      int    `json:"id"`
+			Skipped string `json:"-"`
+			Empty   string `json:",omitempty"`
+		}{ID: 1, Skipped: "x"}, "a162696401"},
+		{"time", time.Unix(1363896240, 0), "c074" + hex.EncodeToString([]byte("2013-03-21T20:04:00Z"))},
+		{"text marshaler", netip.MustParseAddr("10.0.0.1"), "6831302e302e302e31"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := ResponseEncoder(&buf, tc.v); err != nil {
+				t.Fatal(err)
+			}
+			if got := hex.EncodeToString(buf.Bytes()); got != tc.want {
+				t.Errorf("expected %s, got %s", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	want := record{
+		ID:      7,
+		Addr:    netip.MustParseAddr("10.0.0.1"),
+		Created: time.Unix(1700000000, 123456789).UTC(),
+		Labels:  map[string]int{"a": 1},
+	}
+	var buf bytes.Buffer
+	if err := ResponseEncoder(&buf, want); err != nil {
+		t.Fatal(err)
+	}
+	var got record
+	if err := RequestDecoder(&buf, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %+v, got %+v", want, got)
+	}
+
+	var generic any
+	if err := RequestDecoder(bytes.NewReader([]byte{0xa1, 0x61, 0x61, 0x01}), &generic); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(generic, map[string]any{"a": uint64(1)}) {
+		t.Errorf("expected map[a:1], got %#v", generic)
+	}
+}
+
+type service struct{}
+
+func (service) Echo(r record) record { return r }
+
+func TestHandler(t *testing.T) {
+	handler := structhttp.Handler(service{}, structhttp.WithCodecs(structhttp.XMLCodec, Codec))
+
+	var body bytes.Buffer
+	if err := ResponseEncoder(&body, record{ID: 1}); err != nil {
+		t.Fatal(err)
+	}
+	want := body.String()
+
+	r := httptest.NewRequest("POST", "/Echo", &body)
+	r.Header.Set("Content-Type", ContentType)
+	r.Header.Set("Accept", "application/xml;q=0.5, application/cbor")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != 200 || w.Body.String() != want {
+		t.Errorf("expected 200 with body %x, got %d with body %x", want, w.Code, w.Body.String())
+	}
+	if got := w.Header().Get("Content-Type"); got != ContentType {
+		t.Errorf("expected Content-Type %q, got %q", ContentType, got)
+	}
+}
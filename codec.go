@@ -0,0 +1,344 @@
+package structhttp
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+type (
+	// ResponseEncoder is a function that encodes the result of a
+	// method to w in the media type it is registered for with
+	// WithResponseEncoder.
+	ResponseEncoder func(w io.Writer, v any) error
+
+	// RequestDecoder is a function that decodes a request body read
+	// from r into v, as described for WithRequestDecoder.
+	RequestDecoder func(r io.Reader, v any) error
+
+	// ResponseEncoderFunc is a function that writes the whole response
+	// for the result of a method, as described for
+	// WithResponseEncoderFunc.
+	ResponseEncoderFunc func(w http.ResponseWriter, r *http.Request, result any) error
+
+	// Codec encodes results in and decodes request bodies from a
+	// media type, for registering both at once with WithCodecs.
+	Codec interface {
+		// MediaType returns the Content-Type of the responses the
+		// Codec encodes, whose media type also selects the request
+		// bodies it decodes.
+		MediaType() string
+
+		// Encode encodes v to w, as a ResponseEncoder does.
+		Encode(w io.Writer, v any) error
+
+		// Decode decodes the body read from r into v, as a
+		// RequestDecoder does.
+		Decode(r io.Reader, v any) error
+	}
+
+	// JSONEngine is an implementation of JSON used in place of
+	// encoding/json, as described for WithJSONEngine. The APIs of
+	// jsoniter and sonic, such as
+	// jsoniter.ConfigCompatibleWithStandardLibrary and sonic.ConfigStd,
+	// satisfy it.
+	JSONEngine interface {
+		Marshal(v any) ([]byte, error)
+		Unmarshal(data []byte, v any) error
+	}
+
+	// funcCodec is a Codec made of a ResponseEncoder and a
+	// RequestDecoder, as returned by NewCodec.
+	funcCodec struct {
+		contentType string
+		encode      ResponseEncoder
+		decode      RequestDecoder
+	}
+
+	// codec is a ResponseEncoder along with the Content-Type of the
+	// responses it writes.
+	codec struct {
+		contentType string
+		// mediaType is the media type of contentType, in lower case,
+		// without parameters.
+		mediaType string
+		encode    ResponseEncoder
+	}
+)
+
+// NewCodec returns a Codec for contentType that encodes with enc and
+// decodes with dec. Either may be nil for a Codec that only decodes or
+// only encodes; it then declines with errors.ErrUnsupported, as
+// described for WithResponseEncoder and WithRequestDecoder.
+func NewCodec(contentType string, enc ResponseEncoder, dec RequestDecoder) Codec {
+	return funcCodec{contentType: contentType, encode: enc, decode: dec}
+}
+
+func (c funcCodec) MediaType() string {
+	return c.contentType
+}
+
+func (c funcCodec) Encode(w io.Writer, v any) error {
+	if c.encode == nil {
+		return errors.ErrUnsupported
+	}
+	return c.encode(w, v)
+}
+
+func (c funcCodec) Decode(r io.Reader, v any) error {
+	if c.decode == nil {
+		return errors.ErrUnsupported
+	}
+	return c.decode(r, v)
+}
+
+// XMLCodec is the Codec for application/xml, encoding with
+// XMLResponseEncoder and decoding with XMLRequestDecoder.
+var XMLCodec = NewCodec("application/xml", XMLResponseEncoder, XMLRequestDecoder)
+
+// XMLResponseEncoder is a ResponseEncoder that encodes results as XML
+// with encoding/xml, for use with WithResponseEncoder as in
+//
+//	structhttp.WithResponseEncoder("application/xml", structhttp.XMLResponseEncoder)
+func XMLResponseEncoder(w io.Writer, v any) error {
+	if err := xml.NewEncoder(w).Encode(v); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// newCodec returns a codec writing responses of the given
+// Content-Type with encode.
+func newCodec(contentType string, encode ResponseEncoder) codec {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = contentType
+	}
+	return codec{contentType: contentType, mediaType: strings.ToLower(mediaType), encode: encode}
+}
+
+// jsonCodec returns the default codec, encoding results as JSON as
+// configured with WithJSONIndent, WithJSONEscapeHTML, and
+// WithJSONTrailingNewline.
+func (o *options) jsonCodec() codec {
+	return newCodec("application/json", func(w io.Writer, v any) error {
+		if o.jsonEngine == nil && !o.jsonNoNewline {
+			enc := json.NewEncoder(w)
+			enc.SetEscapeHTML(!o.jsonRawHTML)
+			enc.SetIndent(o.jsonPrefix, o.jsonIndent)
+			return enc.Encode(v)
+		}
+		b, err := o.marshalJSON(v)
+		if err != nil {
+			return err
+		}
+		if o.jsonPrefix != "" || o.jsonIndent != "" {
+			var buf bytes.Buffer
+			if err := json.Indent(&buf, b, o.jsonPrefix, o.jsonIndent); err != nil {
+				return err
+			}
+			b = buf.Bytes()
+		}
+		if !o.jsonNoNewline {
+			b = append(b, '\n')
+		}
+		_, err = w.Write(b)
+		return err
+	})
+}
+
+// marshalJSON encodes v as JSON with the engine set with
+// WithJSONEngine, or else encoding/json, escaping HTML unless disabled
+// with WithJSONEscapeHTML.
+func (o *options) marshalJSON(v any) ([]byte, error) {
+	if o.jsonEngine != nil {
+		return o.jsonEngine.Marshal(v)
+	}
+	if !o.jsonRawHTML {
+		return json.Marshal(v)
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// defaultCodec returns the codec registered for application/json, or
+// else the built-in one.
+func (o *options) defaultCodec() codec {
+	if c, ok := o.codec("application/json"); ok {
+		return c
+	}
+	return o.jsonCodec()
+}
+
+// negotiable returns the codecs among which negotiate chooses: the
+// JSON codec, then the others registered with WithResponseEncoder,
+// then the built-in XML codec if WithXML is enabled and no other is
+// registered for application/xml.
+func (o *options) negotiable() []codec {
+	json := o.defaultCodec()
+	codecs := []codec{json}
+	for _, c := range o.codecs {
+		if c.mediaType != json.mediaType {
+			codecs = append(codecs, c)
+		}
+	}
+	if _, ok := o.codec("application/xml"); o.xml && !ok {
+		codecs = append(codecs, newCodec("application/xml", XMLResponseEncoder))
+	}
+	return codecs
+}
+
+// negotiate returns the codec for the response to r: the one whose
+// media type the Accept header of r prefers, or the JSON codec if
+// none is acceptable or there is no Accept header. Among equally
+// acceptable codecs, those whose media types the header names
+// explicitly are preferred to those matched by wildcards, then the
+// JSON codec, then the others in the order of negotiable.
+func (o *options) negotiate(r *http.Request) codec {
+	codecs := o.negotiable()
+	accept := r.Header.Values("Accept")
+	if len(codecs) == 1 || len(accept) == 0 {
+		return codecs[0]
+	}
+
+	ranges := parseAccept(accept)
+	best, bestQ, bestSpecificity := codecs[0], 0.0, 0
+	for _, c := range codecs {
+		q, specificity := acceptQuality(ranges, c.mediaType)
+		if q > bestQ || (q == bestQ && q > 0 && specificity > bestSpecificity) {
+			best, bestQ, bestSpecificity = c, q, specificity
+		}
+	}
+	return best
+}
+
+// codec returns the registered codec for the given media type.
+func (o *options) codec(mediaType string) (codec, bool) {
+	for _, c := range o.codecs {
+		if c.mediaType == mediaType {
+			return c, true
+		}
+	}
+	return codec{}, false
+}
+
+// mediaRange is a media range of an Accept header with its quality.
+type mediaRange struct {
+	mediaType string
+	q         float64
+}
+
+// parseAccept returns the media ranges listed in the values of an
+// Accept header, skipping malformed ones.
+func parseAccept(values []string) []mediaRange {
+	var ranges []mediaRange
+	for _, value := range values {
+		for _, part := range strings.Split(value, ",") {
+			mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+			if err != nil {
+				continue
+			}
+			q := 1.0
+			if s, ok := params["q"]; ok {
+				if q, err = strconv.ParseFloat(s, 64); err != nil {
+					continue
+				}
+			}
+			ranges = append(ranges, mediaRange{mediaType: mediaType, q: q})
+		}
+	}
+	return ranges
+}
+
+// acceptQuality returns the quality the most specific of ranges
+// matching mediaType gives it, or zero if none matches, along with
+// the specificity of that range: 2 for the media type itself, 1 for
+// a range such as "text/*", and 0 for "*/*".
+func acceptQuality(ranges []mediaRange, mediaType string) (float64, int) {
+	q, specificity := 0.0, -1
+	for _, mr := range ranges {
+		s := -1
+		switch {
+		case mr.mediaType == mediaType:
+			s = 2
+		case mr.mediaType == "*/*":
+			s = 0
+		case strings.HasSuffix(mr.mediaType, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(mr.mediaType, "*")):
+			s = 1
+		}
+		if s > specificity {
+			q, specificity = mr.q, s
+		}
+	}
+	return q, specificity
+}
+
+// isXML reports whether r has an XML body: one whose Content-Type is
+// application/xml, text/xml, or a +xml structured syntax type.
+func isXML(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && (mediaType == "application/xml" || mediaType == "text/xml" || strings.HasSuffix(mediaType, "+xml"))
+}
+
+// XMLRequestDecoder is a RequestDecoder that decodes XML request
+// bodies with encoding/xml.
+func XMLRequestDecoder(r io.Reader, v any) error {
+	return xml.NewDecoder(r).Decode(v)
+}
+
+// decodeXML decodes the XML request body into v.
+func decodeXML(r *http.Request, v any) error {
+	if err := XMLRequestDecoder(r.Body, v); err != nil {
+		return fmt.Errorf("failed to decode request body: %w", err)
+	}
+	return nil
+}
+
+// decoder returns the decoder registered with WithRequestDecoder for
+// the Content-Type of r.
+func (o *options) decoder(r *http.Request) (RequestDecoder, bool) {
+	if len(o.decoders) == 0 {
+		return nil, false
+	}
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, false
+	}
+	dec, ok := o.decoders[mediaType]
+	return dec, ok
+}
+
+// decodeWith decodes the body of r with dec into arg, a pointer to a
+// method argument, passing dec the argument itself if it is a pointer.
+func decodeWith(dec RequestDecoder, r *http.Request, arg reflect.Value) error {
+	v := arg.Interface()
+	if elem := arg.Elem(); elem.Kind() == reflect.Pointer {
+		if elem.IsNil() {
+			elem.Set(reflect.New(elem.Type().Elem()))
+		}
+		v = elem.Interface()
+	}
+	err := dec(r.Body, v)
+	if errors.Is(err, errors.ErrUnsupported) {
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		return NewError(http.StatusUnsupportedMediaType, fmt.Errorf("unsupported content type %q for argument of type %s", mediaType, arg.Elem().Type()))
+	}
+	if err != nil {
+		return fmt.Errorf("failed to decode request body: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package structhttp
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSOptions configures cross-origin resource sharing for Handler.
+type CORSOptions struct {
+	// AllowedOrigins lists the origins allowed to make cross-origin
+	// requests. An entry of "*" allows any origin, unless
+	// AllowCredentials is set, in which case it allows none.
+	AllowedOrigins []string
+	// AllowedHeaders lists the request headers allowed in
+	// cross-origin requests. If empty, the headers requested by a
+	// preflight request are allowed.
+	AllowedHeaders []string
+	// AllowCredentials indicates whether cross-origin requests may
+	// include credentials. Origins must then be listed explicitly, as
+	// giving every site credentialed access is never intended.
+	AllowCredentials bool
+	// MaxAge is how long the result of a preflight request may be
+	// cached. Zero omits the Access-Control-Max-Age header.
+	MaxAge time.Duration
+}
+
+// allowOrigin sets the Access-Control-Allow-Origin header for an
+// allowed origin and reports whether the origin is allowed.
+func (c *CORSOptions) allowOrigin(w http.ResponseWriter, origin string) bool {
+	if origin == "" {
+		return false
+	}
+
+	allowed, wildcard := false, false
+	for _, o := range c.AllowedOrigins {
+		if o == "*" {
+			if !c.AllowCredentials {
+				allowed, wildcard = true, true
+			}
+		} else if strings.EqualFold(o, origin) {
+			allowed, wildcard = true, false
+			break
+		}
+	}
+	if !allowed {
+		return false
+	}
+
+	h := w.Header()
+	if wildcard {
+		h.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		h.Set("Access-Control-Allow-Origin", origin)
+		h.Add("Vary", "Origin")
+	}
+	if c.AllowCredentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+	return true
+}
+
+// isPreflight reports whether r is a CORS preflight request.
+func isPreflight(r *http.Request) bool {
+	return r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// writePreflight responds to a preflight request for a route
+// accepting the given HTTP methods.
+func (c *CORSOptions) writePreflight(w http.ResponseWriter, r *http.Request, allow []string) {
+	h := w.Header()
+	h.Set("Access-Control-Allow-Methods", strings.Join(allow, ", "))
+	if len(c.AllowedHeaders) > 0 {
+		h.Set("Access-Control-Allow-Headers", strings.Join(c.AllowedHeaders, ", "))
+	} else if requested := r.Header.Get("Access-Control-Request-Headers"); requested != "" {
+		h.Set("Access-Control-Allow-Headers", requested)
+	}
+	if c.MaxAge > 0 {
+		h.Set("Access-Control-Max-Age", strconv.Itoa(int(c.MaxAge.Seconds())))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
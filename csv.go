@@ -0,0 +1,223 @@
+package structhttp
+
+import (
+	"encoding"
+	"encoding/csv"
+	"errors"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// CSVContentType is the Content-Type of CSV responses, that of
+// CSVCodec.
+const CSVContentType = "text/csv"
+
+// CSVCodec is the Codec for CSV, encoding with CSVResponseEncoder, for
+// registering with WithCodecs:
+//
+//	structhttp.WithCodecs(structhttp.CSVCodec)
+//
+// It does not decode request bodies, which are rejected with a 415
+// response.
+var CSVCodec = NewCodec(CSVContentType, CSVResponseEncoder, nil)
+
+// CSVResponseEncoder is a ResponseEncoder that encodes results that
+// are slices or arrays of flat structs, or of pointers to them, as CSV
+// with encoding/csv and the CRLF line endings of RFC 4180: a header
+// row of the fields' JSON names, as encoding/json names them, then a
+// row for each element. A struct is flat if its fields are strings,
+// numbers, bools, or values implementing encoding.TextMarshaler, such
+// as time.Time, or pointers to those, which are written as empty
+// cells if nil, as are nil elements. The encoder declines other
+// results, which are then encoded as JSON, so that it can be
+// registered for all of a service's methods.
+func CSVResponseEncoder(w io.Writer, v any) error {
+	rv := reflect.ValueOf(v)
+	for (rv.Kind() == reflect.Pointer || rv.Kind() == reflect.Interface) && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return errors.ErrUnsupported
+	}
+	fields, ok := csvFields(rv.Type().Elem())
+	if !ok {
+		return errors.ErrUnsupported
+	}
+
+	cw := csv.NewWriter(w)
+	cw.UseCRLF = true
+	record := make([]string, len(fields))
+	for i, f := range fields {
+		record[i] = f.name
+	}
+	if err := cw.Write(record); err != nil {
+		return err
+	}
+	for i := 0; i < rv.Len(); i++ {
+		row := rv.Index(i)
+		if row.Kind() == reflect.Pointer {
+			row = row.Elem()
+		}
+		for j, f := range fields {
+			record[j] = ""
+			if !row.IsValid() {
+				continue
+			}
+			fv, err := row.FieldByIndexErr(f.index)
+			if err != nil {
+				continue
+			}
+			if record[j], err = csvCell(fv); err != nil {
+				return err
+			}
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// csvFields returns the fields of t, a struct or pointer to one, to be
+// written as CSV columns, or false if t is not a flat struct.
+func csvFields(t reflect.Type) ([]jsonField, bool) {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct || t.Implements(textMarshalerType) {
+		return nil, false
+	}
+	fields := jsonFields(t)
+	for _, f := range fields {
+		ft := t.FieldByIndex(f.index).Type
+		if ft.Kind() == reflect.Pointer && !ft.Implements(textMarshalerType) {
+			ft = ft.Elem()
+		}
+		if !isCSVCell(ft) {
+			return nil, false
+		}
+	}
+	return fields, true
+}
+
+func isCSVCell(t reflect.Type) bool {
+	if t.Implements(textMarshalerType) {
+		return true
+	}
+	switch t.Kind() {
+	case reflect.Bool, reflect.String,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// csvCell formats v, a field accepted by isCSVCell, as a cell. Floats
+// are written without exponents, which spreadsheets may misread.
+func csvCell(v reflect.Value) (string, error) {
+	if v.Kind() == reflect.Pointer && v.IsNil() {
+		return "", nil
+	}
+	if v.Type().Implements(textMarshalerType) {
+		text, err := v.Interface().(encoding.TextMarshaler).MarshalText()
+		return string(text), err
+	}
+	if v.Kind() == reflect.Pointer {
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(v.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'f', -1, v.Type().Bits()), nil
+	}
+	return v.String(), nil
+}
+
+var (
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+
+	// jsonFieldCache holds the jsonFields of struct types.
+	jsonFieldCache sync.Map
+)
+
+// jsonField is a field of a struct, named as by encoding/json.
+type jsonField struct {
+	name  string
+	index []int
+}
+
+// jsonFields returns the fields of the struct type t encoded by
+// encoding/json, named and promoted from embedded structs as it does
+// them. Of fields with the same name, the least nested is kept, or
+// the first of those.
+func jsonFields(t reflect.Type) []jsonField {
+	if fields, ok := jsonFieldCache.Load(t); ok {
+		return fields.([]jsonField)
+	}
+
+	var all []jsonField
+	var collect func(t reflect.Type, index []int)
+	collect = func(t reflect.Type, index []int) {
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			tag := field.Tag.Get("json")
+			if tag == "-" {
+				continue
+			}
+			name, _, _ := strings.Cut(tag, ",")
+			fieldIndex := append(append([]int(nil), index...), i)
+			if field.Anonymous && name == "" {
+				ft := field.Type
+				if ft.Kind() == reflect.Pointer {
+					ft = ft.Elem()
+				}
+				if ft.Kind() == reflect.Struct && (field.IsExported() || field.Type.Kind() != reflect.Pointer) {
+					collect(ft, fieldIndex)
+					continue
+				}
+			}
+			if !field.IsExported() {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			all = append(all, jsonField{name: name, index: fieldIndex})
+		}
+	}
+	collect(t, nil)
+
+	sort.SliceStable(all, func(i, j int) bool { return len(all[i].index) < len(all[j].index) })
+	seen := make(map[string]bool)
+	fields := all[:0]
+	for _, f := range all {
+		if !seen[f.name] {
+			seen[f.name] = true
+			fields = append(fields, f)
+		}
+	}
+	sort.Slice(fields, func(i, j int) bool {
+		a, b := fields[i].index, fields[j].index
+		for k := 0; k < len(a) && k < len(b); k++ {
+			if a[k] != b[k] {
+				return a[k] < b[k]
+			}
+		}
+		return len(a) < len(b)
+	})
+
+	jsonFieldCache.Store(t, fields)
+	return fields
+}
@@ -0,0 +1,93 @@
+package structhttp
+
+import (
+	"bytes"
+	"errors"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+)
+
+type csvRow struct {
+	ID      int    `json:"id"`
+	Name    string `json:"name"`
+	Hidden  string `json:"-"`
+	Score   float64
+	Active  bool
+	Created time.Time
+	Addr    *netip.Addr
+	Parent  *int
+}
+
+type exportService struct{}
+
+func (exportService) Rows() []csvRow {
+	parent := 1
+	return []csvRow{
+		{ID: 1, Name: "plain", Score: 1000000, Active: true, Created: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)},
+		{ID: 2, Name: "quoted, \"name\"", Score: 0.25, Parent: &parent},
+	}
+}
+
+func (exportService) Nested() []map[string]int {
+	return []map[string]int{{"a": 1}}
+}
+
+func TestCSVEncoding(t *testing.T) {
+	addr := netip.MustParseAddr("10.0.0.1")
+	var buf bytes.Buffer
+	rows := []*csvRow{{ID: 1, Addr: &addr}, nil}
+	if err := CSVResponseEncoder(&buf, rows); err != nil {
+		t.Fatal(err)
+	}
+	want := "id,name,Score,Active,Created,Addr,Parent\r\n" +
+		"1,,0,false,0001-01-01T00:00:00Z,10.0.0.1,\r\n" +
+		",,,,,,\r\n"
+	if got := buf.String(); got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+
+	declined := []any{
+		csvRow{},
+		[]int{1},
+		[]time.Time{{}},
+		[]struct{ Tags []string }{{}},
+		nil,
+	}
+	for _, v := range declined {
+		if err := CSVResponseEncoder(&bytes.Buffer{}, v); !errors.Is(err, errors.ErrUnsupported) {
+			t.Errorf("expected %T to be declined, got %v", v, err)
+		}
+	}
+}
+
+func TestHandlerCSV(t *testing.T) {
+	handler := Handler(exportService{}, WithResponseEncoder(CSVContentType, CSVResponseEncoder))
+
+	req := httptest.NewRequest("POST", "/Rows", nil)
+	req.Header.Set("Accept", "text/csv")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	want := strings.Join([]string{
+		"id,name,Score,Active,Created,Addr,Parent",
+		"1,plain,1000000,true,2024-01-02T00:00:00Z,,",
+		`2,"quoted, ""name""",0.25,false,0001-01-01T00:00:00Z,,1`,
+		"",
+	}, "\r\n")
+	if w.Code != 200 || w.Body.String() != want {
+		t.Errorf("expected 200 with body %q, got %d with body %q", want, w.Code, w.Body.String())
+	}
+	if got := w.Header().Get("Content-Type"); got != CSVContentType {
+		t.Errorf("expected Content-Type %q, got %q", CSVContentType, got)
+	}
+
+	req = httptest.NewRequest("POST", "/Nested", nil)
+	req.Header.Set("Accept", "text/csv")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != 200 || w.Body.String() != "[{\"a\":1}]\n" || w.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("expected results that are not tables as JSON, got %d with %q (%s)", w.Code, w.Body.String(), w.Header().Get("Content-Type"))
+	}
+}
@@ -0,0 +1,68 @@
+package structhttp
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// decompressedBody is a request body decompressed as it is read. The
+// decompressor is created on the first read, so that a malformed body
+// is reported by whatever decodes it, and bodies that are never read
+// are never inspected.
+type decompressedBody struct {
+	body     io.ReadCloser
+	encoding string
+	r        io.ReadCloser
+	err      error
+}
+
+// withDecompression returns r with its body decompressed, if it has a
+// Content-Encoding of gzip or deflate, and limited to maxBytes once
+// decompressed, if positive. The Content-Encoding and Content-Length
+// headers are removed, as they describe the compressed body. Other
+// encodings are left as they are.
+func withDecompression(rw http.ResponseWriter, r *http.Request, maxBytes int64) *http.Request {
+	encoding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding")))
+	switch encoding {
+	case "gzip", "x-gzip", "deflate":
+	default:
+		return r
+	}
+	if r.Body == nil || r.Body == http.NoBody {
+		return r
+	}
+
+	r = r.Clone(r.Context())
+	r.Header.Del("Content-Encoding")
+	r.Header.Del("Content-Length")
+	r.ContentLength = -1
+	r.Body = &decompressedBody{body: r.Body, encoding: encoding}
+	if maxBytes > 0 {
+		r.Body = http.MaxBytesReader(rw, r.Body, maxBytes)
+	}
+	return r
+}
+
+func (b *decompressedBody) Read(p []byte) (int, error) {
+	if b.r == nil && b.err == nil {
+		if b.encoding == "deflate" {
+			b.r, b.err = zlib.NewReader(b.body)
+		} else {
+			b.r, b.err = gzip.NewReader(b.body)
+		}
+	}
+	if b.err != nil {
+		return 0, b.err
+	}
+	return b.r.Read(p)
+}
+
+func (b *decompressedBody) Close() error {
+	if b.r != nil {
+		b.r.Close()
+	}
+	return b.body.Close()
+}
@@ -0,0 +1,168 @@
+package structhttp
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"reflect"
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+// DynamicHandler is an http.Handler serving the methods of a struct,
+// as with Handler, to which functions can be added and from which
+// methods can be removed while it serves requests, such as to gate
+// experimental endpoints behind feature flags. Its methods are safe
+// for concurrent use.
+type DynamicHandler struct {
+	// mu serializes changes to the handler.
+	mu sync.Mutex
+	// current is the handler serving requests. It is replaced, never
+	// modified, by changes, so requests in flight are unaffected.
+	current atomic.Pointer[structHandler]
+}
+
+// unboundType is the type of the receiver given to functions added
+// with DynamicHandler.Register, which have none of their own.
+var unboundType = reflect.TypeOf(struct{}{})
+
+// NewDynamicHandler returns a DynamicHandler for the given struct,
+// which is checked as by NewHandler. A nil struct serves no methods
+// until some are registered.
+func NewDynamicHandler(s any, opts ...Option) (*DynamicHandler, error) {
+	if s == nil {
+		s = struct{}{}
+	}
+	sh := newStructHandler(s, opts...)
+	if err := sh.validate(); err != nil {
+		return nil, err
+	}
+	d := &DynamicHandler{}
+	d.current.Store(sh)
+	return d, nil
+}
+
+func (d *DynamicHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	d.current.Load().ServeHTTP(w, r)
+}
+
+// Register serves fn as a method with the given name. Its route and
+// arguments are derived from the name and fn's signature as for the
+// methods of a struct passed to Handler, and options naming methods,
+// such as WithSuccessStatus, apply to it by name. Matchers receive a
+// Method whose ReflectMethod has a placeholder receiver. Register
+// returns an error if fn is not a function with a signature Handler
+// serves, if a method with the name is already served, or if its route
+// conflicts with another method's.
+func (d *DynamicHandler) Register(name string, fn any) error {
+	fv := reflect.ValueOf(fn)
+	if fv.Kind() != reflect.Func || fv.IsNil() {
+		return fmt.Errorf("method %s: %T is not a function", name, fn)
+	}
+	if !allowedMethod(fv.Type()) {
+		return fmt.Errorf("method %s: unsupported results; want (), (error), (T), (T, error), or (T, bool, error)", name)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	sh := d.current.Load()
+	if slices.ContainsFunc(sh.methods, func(m methodInfo) bool { return m.Name == name }) {
+		return fmt.Errorf("method %s: already registered", name)
+	}
+
+	next := sh.clone()
+	if e, derived := next.derivedEndpoint(name, nil); derived {
+		next.setEndpoint(name, e)
+	}
+	n := len(next.errs)
+	info := next.newMethodInfo(unboundMethod(name, fv), reflect.Zero(unboundType), name, "")
+	if errs := next.errs[n:]; len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+	e := next.endpoint(name)
+	for _, method := range next.methods {
+		if next.routeKey(next.endpoint(method.Name)) == next.routeKey(e) {
+			return fmt.Errorf("route %s %s of method %s conflicts with method %s", e.verb, e.path, name, method.Name)
+		}
+	}
+	next.methods = append(next.methods, info)
+	next.reindex()
+	d.current.Store(next)
+	return nil
+}
+
+// Unregister stops serving the method with the given name, whether it
+// was registered or is a method of the struct, and reports whether
+// there was such a method. Requests already being served by the method
+// are unaffected.
+func (d *DynamicHandler) Unregister(name string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	sh := d.current.Load()
+	i := slices.IndexFunc(sh.methods, func(m methodInfo) bool { return m.Name == name })
+	if i < 0 {
+		return false
+	}
+
+	next := sh.clone()
+	next.methods = slices.Delete(next.methods, i, i+1)
+	delete(next.endpoints, name)
+	delete(next.consumes, name)
+	next.reindex()
+	d.current.Store(next)
+	return true
+}
+
+// clone returns a copy of sh whose methods and endpoints can be
+// changed without affecting sh.
+func (sh *structHandler) clone() *structHandler {
+	o := *sh.options
+	o.endpoints = maps.Clone(sh.endpoints)
+	o.consumes = maps.Clone(sh.consumes)
+	h := *sh
+	h.options = &o
+	h.methods = slices.Clone(sh.methods)
+	h.errs = slices.Clone(sh.errs)
+	return &h
+}
+
+// reindex restores the dispatch order and route index of sh after its
+// methods change.
+func (sh *structHandler) reindex() {
+	sh.sortMethods()
+	if sh.index != nil {
+		sh.index = sh.newRouteIndex()
+	}
+}
+
+// unboundMethod returns a method with the given name calling fn,
+// taking a receiver of unboundType.
+func unboundMethod(name string, fn reflect.Value) reflect.Method {
+	ft := fn.Type()
+	in := []reflect.Type{unboundType}
+	for i := 0; i < ft.NumIn(); i++ {
+		in = append(in, ft.In(i))
+	}
+	out := make([]reflect.Type, ft.NumOut())
+	for i := range out {
+		out[i] = ft.Out(i)
+	}
+
+	mt := reflect.FuncOf(in, out, ft.IsVariadic())
+	call := fn.Call
+	if ft.IsVariadic() {
+		call = fn.CallSlice
+	}
+	return reflect.Method{
+		Name: name,
+		Type: mt,
+		Func: reflect.MakeFunc(mt, func(args []reflect.Value) []reflect.Value {
+			return call(args[1:])
+		}),
+		Index: -1,
+	}
+}
@@ -0,0 +1,128 @@
+package structhttp
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestDynamicHandler(t *testing.T) {
+	d, err := NewDynamicHandler(userService{}, WithRESTRouting(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serve := func(method, path, body string) (int, string) {
+		w := httptest.NewRecorder()
+		d.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return w.Code, w.Body.String()
+	}
+
+	if code, _ := serve("POST", "/Search", `"go"`); code != 404 {
+		t.Errorf("expected 404 before Register, got %d", code)
+	}
+
+	search := func(query string) ([]string, error) {
+		if query == "" {
+			return nil, NewError(http.StatusBadRequest, errors.New("empty query"))
+		}
+		return []string{query}, nil
+	}
+	if err := d.Register("Search", search); err != nil {
+		t.Fatal(err)
+	}
+	if code, body := serve("POST", "/Search", `"go"`); code != 200 || body != "[\"go\"]\n" {
+		t.Errorf("expected 200 with [\"go\"], got %d with %q", code, body)
+	}
+	if code, body := serve("POST", "/Search", `""`); code != 400 || body != "{\"error\":\"empty query\"}\n" {
+		t.Errorf("expected 400 from registered function, got %d with %q", code, body)
+	}
+
+	double := func(id int) int { return 2 * id }
+	if err := d.Register("GetDouble", double); err != nil {
+		t.Fatal(err)
+	}
+	if code, body := serve("GET", "/double/4", ""); code != 200 || body != "8\n" {
+		t.Errorf("expected REST route for registered function, got %d with %q", code, body)
+	}
+
+	sum := func(nums ...int) int {
+		total := 0
+		for _, n := range nums {
+			total += n
+		}
+		return total
+	}
+	if err := d.Register("Sum", sum); err != nil {
+		t.Fatal(err)
+	}
+	if code, body := serve("POST", "/Sum", "[1,2,3]"); code != 200 || body != "6\n" {
+		t.Errorf("expected variadic function to be called, got %d with %q", code, body)
+	}
+
+	for _, tc := range []struct {
+		name string
+		fn   any
+		want string
+	}{
+		{"Search", search, "method Search: already registered"},
+		{"Pair", func() (string, string) { return "", "" }, "method Pair: unsupported results; want (), (error), (T), (T, error), or (T, bool, error)"},
+		{"Value", 42, "method Value: int is not a function"},
+		{"FetchUser", func(id int) string { return "" }, ""},
+		{"user", func(u testUser) testUser { return u }, "route POST /user of method user conflicts with method CreateUser"},
+	} {
+		err := d.Register(tc.name, tc.fn)
+		if tc.want == "" {
+			if err != nil {
+				t.Errorf("Register(%q): unexpected error %v", tc.name, err)
+			}
+			continue
+		}
+		if err == nil || err.Error() != tc.want {
+			t.Errorf("Register(%q): expected error %q, got %v", tc.name, tc.want, err)
+		}
+	}
+
+	if !d.Unregister("Search") || d.Unregister("Search") {
+		t.Error("expected Unregister to remove Search once")
+	}
+	if code, _ := serve("POST", "/Search", `"go"`); code != 404 {
+		t.Errorf("expected 404 after Unregister, got %d", code)
+	}
+	if !d.Unregister("GetUser") {
+		t.Error("expected Unregister to remove a struct method")
+	}
+	if code, _ := serve("GET", "/user/7", ""); code != 405 {
+		t.Errorf("expected 405 after Unregister, got %d", code)
+	}
+}
+
+func TestDynamicHandlerConcurrency(t *testing.T) {
+	d, err := NewDynamicHandler(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				w := httptest.NewRecorder()
+				d.ServeHTTP(w, httptest.NewRequest("POST", "/Flag", nil))
+				if w.Code != 200 && w.Code != 404 {
+					t.Errorf("unexpected status code %d", w.Code)
+				}
+			}
+		}()
+	}
+	for j := 0; j < 100; j++ {
+		_ = d.Register("Flag", func() bool { return true })
+		d.Unregister("Flag")
+	}
+	wg.Wait()
+}
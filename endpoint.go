@@ -0,0 +1,410 @@
+package structhttp
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// endpoint is the HTTP method and path pattern at which a method is
+// served by DefaultMatcherFunc. Path segments of the form {name} are
+// variables matching any single non-empty segment.
+type endpoint struct {
+	verb     string
+	path     string
+	segments []string
+}
+
+// restPrefixes map method name prefixes to the endpoints derived for
+// them by WithRESTRouting.
+var restPrefixes = []struct {
+	prefix string
+	verb   string
+	id     bool
+}{
+	{"List", http.MethodGet, false},
+	{"Get", http.MethodGet, true},
+	{"Create", http.MethodPost, false},
+	{"Update", http.MethodPut, true},
+	{"Patch", http.MethodPatch, true},
+	{"Delete", http.MethodDelete, true},
+}
+
+func newEndpoint(verb, path string) endpoint {
+	return endpoint{
+		verb:     verb,
+		path:     path,
+		segments: strings.Split(strings.TrimPrefix(path, "/"), "/"),
+	}
+}
+
+// rpcEndpoint returns the default endpoint of a method: POST to
+// /MethodName.
+func rpcEndpoint(methodName string) endpoint {
+	return newEndpoint(http.MethodPost, "/"+methodName)
+}
+
+// restEndpoint returns the endpoint derived from a method name with a
+// REST prefix, such as GET /user/{id} for GetUser, or false if the
+// name has no such prefix. The resource name is converted with
+// naming.
+func restEndpoint(methodName string, naming func(string) string) (endpoint, bool) {
+	for _, p := range restPrefixes {
+		noun, ok := strings.CutPrefix(methodName, p.prefix)
+		if !ok || noun == "" || !unicode.IsUpper([]rune(noun)[0]) {
+			continue
+		}
+		path := "/" + naming(noun)
+		if p.id {
+			path += "/{id}"
+		}
+		return newEndpoint(p.verb, path), true
+	}
+	return endpoint{}, false
+}
+
+// parseRoute parses a route tag of the form "METHOD /path" or
+// "/path", the latter for a POST route.
+func parseRoute(tag string) (endpoint, error) {
+	fields := strings.Fields(tag)
+	verb, path := http.MethodPost, ""
+	switch len(fields) {
+	case 1:
+		path = fields[0]
+	case 2:
+		verb, path = strings.ToUpper(fields[0]), fields[1]
+	default:
+		return endpoint{}, fmt.Errorf("invalid route %q", tag)
+	}
+	if !strings.HasPrefix(path, "/") {
+		return endpoint{}, fmt.Errorf("invalid route %q: path must begin with /", tag)
+	}
+	return newEndpoint(verb, path), nil
+}
+
+// methodTags returns the struct tags of the fields of t's
+// struct-typed fields, keyed by field name, which names the method the
+// tags annotate. The tags of fields of the same name are joined.
+func methodTags(t reflect.Type) map[string]reflect.StructTag {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+
+	tags := make(map[string]reflect.StructTag)
+	for i := 0; i < t.NumField(); i++ {
+		ft := t.Field(i).Type
+		if ft.Kind() != reflect.Struct {
+			continue
+		}
+		for j := 0; j < ft.NumField(); j++ {
+			field := ft.Field(j)
+			if field.Tag == "" {
+				continue
+			}
+			if tag, ok := tags[field.Name]; ok {
+				tags[field.Name] = tag + " " + field.Tag
+			} else {
+				tags[field.Name] = field.Tag
+			}
+		}
+	}
+	return tags
+}
+
+// taggedEndpoints returns the endpoints declared by `route` tags in
+// tags, keyed by method name, along with any invalid tags.
+func taggedEndpoints(tags map[string]reflect.StructTag) (map[string]endpoint, []error) {
+	endpoints := make(map[string]endpoint)
+	var errs []error
+	for _, name := range sortedKeys(tags) {
+		route, ok := tags[name].Lookup("route")
+		if !ok {
+			continue
+		}
+		e, err := parseRoute(route)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("route tag for %s: %w", name, err))
+			continue
+		}
+		endpoints[name] = e
+	}
+	return endpoints, errs
+}
+
+// sortedKeys returns the keys of m in order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// matchPath reports whether path matches the endpoint's pattern,
+// returning the values of its variables. A final {name...} variable
+// matches the remainder of the path after the slash preceding it, so
+// that /files/{p...} matches /files/ but not /files, as with
+// http.ServeMux. Unless strict, a path without a leading slash is
+// matched as if it had one. If fold, the literal segments of the
+// pattern are matched case-insensitively.
+func (e endpoint) matchPath(path string, strict, fold bool) (map[string]string, bool) {
+	if !strings.HasPrefix(path, "/") {
+		if strict {
+			return nil, false
+		}
+		path = "/" + path
+	}
+	if path == e.path || (fold && strings.EqualFold(path, e.path)) {
+		return nil, true
+	}
+
+	segments := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	var params map[string]string
+	for i, seg := range e.segments {
+		name, rest, ok := variable(seg)
+		if rest && i == len(e.segments)-1 && i < len(segments) {
+			if params == nil {
+				params = make(map[string]string)
+			}
+			params[name] = strings.Join(segments[i:], "/")
+			return params, true
+		}
+		if i >= len(segments) {
+			return nil, false
+		}
+		if !ok {
+			if seg != segments[i] && !(fold && strings.EqualFold(seg, segments[i])) {
+				return nil, false
+			}
+			continue
+		}
+		if segments[i] == "" {
+			return nil, false
+		}
+		if params == nil {
+			params = make(map[string]string)
+		}
+		params[name] = segments[i]
+	}
+	if len(segments) != len(e.segments) {
+		return nil, false
+	}
+	return params, true
+}
+
+// shape returns the endpoint's path with the names of its variables
+// removed, so that patterns matching the same paths are equal.
+func (e endpoint) shape() string {
+	segments := make([]string, len(e.segments))
+	for i, seg := range e.segments {
+		_, rest, ok := variable(seg)
+		switch {
+		case !ok:
+			segments[i] = seg
+		case rest:
+			segments[i] = "{...}"
+		default:
+			segments[i] = "{}"
+		}
+	}
+	return "/" + strings.Join(segments, "/")
+}
+
+// compareSpecificity orders endpoints from most to least specific:
+// longer paths first and, at the first segment where they differ,
+// literal segments before variables and variables before a final
+// {name...} variable. It returns 0 for endpoints of equal
+// specificity.
+func compareSpecificity(a, b endpoint) int {
+	if len(a.segments) != len(b.segments) {
+		return len(b.segments) - len(a.segments)
+	}
+	for i := range a.segments {
+		if c := segmentRank(a.segments[i]) - segmentRank(b.segments[i]); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// segmentRank ranks a pattern segment for compareSpecificity.
+func segmentRank(seg string) int {
+	_, rest, ok := variable(seg)
+	switch {
+	case !ok:
+		return 0
+	case rest:
+		return 2
+	default:
+		return 1
+	}
+}
+
+// variables returns the names of the endpoint's path variables in
+// order.
+func (e endpoint) variables() []string {
+	var names []string
+	for _, seg := range e.segments {
+		if name, _, ok := variable(seg); ok {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// positional reports whether DefaultMatcherFunc binds arguments of
+// the given types from the path variables of e in order: each is a
+// scalar, and there is a variable for each.
+func (o *options) positional(e endpoint, args []reflect.Type) bool {
+	if len(e.variables()) != len(args) {
+		return false
+	}
+	for _, t := range args {
+		if !o.isScalarType(t) {
+			return false
+		}
+	}
+	return true
+}
+
+// positionalEndpoint returns the endpoint of a method served with
+// its arguments in the path, as described for WithPositionalArgs, or
+// false if the method is not. The method has the given name, before
+// any qualifier, and is otherwise served at e.
+func (o *options) positionalEndpoint(methodName string, e endpoint, desc Method, tag reflect.StructTag) (endpoint, bool) {
+	if !o.positionalArgs || len(desc.Args) == 0 || len(e.variables()) > 0 {
+		return endpoint{}, false
+	}
+	if _, ok := tag.Lookup("route"); ok {
+		return endpoint{}, false
+	}
+	if _, ok := restEndpoint(methodName, o.resourceNaming()); ok && o.restRouting {
+		return endpoint{}, false
+	}
+	for _, t := range desc.Args {
+		if !o.isScalarType(t) {
+			return endpoint{}, false
+		}
+	}
+
+	path := e.path
+	for i := range desc.Args {
+		name := fmt.Sprintf("arg%d", i)
+		if desc.ArgNames != nil {
+			name = desc.ArgNames[i]
+		}
+		path += "/{" + name + "}"
+	}
+	return newEndpoint(http.MethodGet, path), true
+}
+
+// pathValues returns the values of the endpoint's variables in a
+// request routed by a router using the endpoint's pattern, as read
+// with pathParam.
+func (e endpoint) pathValues(r *http.Request, pathParam PathParamFunc) map[string]string {
+	var params map[string]string
+	for _, seg := range e.segments {
+		if name, _, ok := variable(seg); ok {
+			if params == nil {
+				params = make(map[string]string)
+			}
+			params[name] = pathParam(r, name)
+		}
+	}
+	return params
+}
+
+// pathParamsKey is the context key for the path variables of the
+// matched method.
+type pathParamsKey struct{}
+
+// PathParams returns the values of the path variables of the method
+// matched by a Handler, keyed by variable name, from the context of
+// the request. It is available to methods, context functions, and
+// pre-invoke hooks. It returns nil if the matched route has no
+// variables or ctx is not a request's.
+func PathParams(ctx context.Context) map[string]string {
+	params, _ := ctx.Value(pathParamsKey{}).(map[string]string)
+	return params
+}
+
+// withPathParams returns r with the path variables of the endpoint of
+// the named method attached to its context, if the endpoint matches
+// it.
+func (o *options) withPathParams(r *http.Request, methodName string) *http.Request {
+	e := o.endpoint(methodName)
+	var params map[string]string
+	if o.pathParam != nil {
+		params = e.pathValues(r, o.pathParam)
+	} else {
+		params, _ = e.matchPath(r.URL.Path, o.strictPaths, o.foldPaths)
+	}
+	if len(params) == 0 {
+		return r
+	}
+	return r.WithContext(context.WithValue(r.Context(), pathParamsKey{}, params))
+}
+
+// variable returns the name of a {name} or {name...} pattern segment
+// and whether it is of the latter form, matching the rest of a path.
+func variable(seg string) (name string, rest, ok bool) {
+	if len(seg) < 3 || seg[0] != '{' || seg[len(seg)-1] != '}' {
+		return "", false, false
+	}
+	name, rest = strings.CutSuffix(seg[1:len(seg)-1], "...")
+	return name, rest, true
+}
+
+// splitWords splits a CamelCase name into its words, keeping runs of
+// capitals such as "HTTP" in "HTTPServer" together.
+func splitWords(name string) []string {
+	runes := []rune(name)
+	var words []string
+	start := 0
+	for i := 1; i < len(runes); i++ {
+		prev, cur := runes[i-1], runes[i]
+		next := rune(0)
+		if i+1 < len(runes) {
+			next = runes[i+1]
+		}
+		if unicode.IsUpper(cur) && (!unicode.IsUpper(prev) || unicode.IsLower(next)) {
+			words = append(words, string(runes[start:i]))
+			start = i
+		}
+	}
+	return append(words, string(runes[start:]))
+}
+
+// KebabCase converts a CamelCase method name to lower case words
+// separated by hyphens, such as "get-user-profile" for
+// "GetUserProfile". It is a naming strategy for WithNamingStrategy.
+func KebabCase(name string) string {
+	return strings.ToLower(strings.Join(splitWords(name), "-"))
+}
+
+// SnakeCase converts a CamelCase method name to lower case words
+// separated by underscores, such as "get_user_profile" for
+// "GetUserProfile". It is a naming strategy for WithNamingStrategy.
+func SnakeCase(name string) string {
+	return strings.ToLower(strings.Join(splitWords(name), "_"))
+}
+
+// LowerCamelCase converts a CamelCase method name to camel case with
+// a lower case first word, such as "getUserProfile" for
+// "GetUserProfile" or "httpServer" for "HTTPServer". It is a naming
+// strategy for WithNamingStrategy.
+func LowerCamelCase(name string) string {
+	words := splitWords(name)
+	if len(words) > 0 {
+		words[0] = strings.ToLower(words[0])
+	}
+	return strings.Join(words, "")
+}
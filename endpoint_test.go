@@ -0,0 +1,17 @@
+package structhttp
+
+import "testing"
+
+func TestNamingStrategies(t *testing.T) {
+	for name, want := range map[string][3]string{
+		"User":           {"user", "user", "user"},
+		"GetUserProfile": {"get-user-profile", "get_user_profile", "getUserProfile"},
+		"HTTPServer":     {"http-server", "http_server", "httpServer"},
+		"UserID":         {"user-id", "user_id", "userID"},
+	} {
+		got := [3]string{KebabCase(name), SnakeCase(name), LowerCamelCase(name)}
+		if got != want {
+			t.Errorf("naming strategies for %q = %q, want %q", name, got, want)
+		}
+	}
+}
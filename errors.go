@@ -1,5 +1,13 @@
 package structhttp
 
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
 type (
 	// HTTPStatusCoder is an interface for errors that can return an
 	// HTTP status code.
@@ -7,11 +15,37 @@ type (
 		HTTPStatusCode() int
 	}
 
+	// ProblemTyper is an interface for errors that provide the "type"
+	// URI of an RFC 7807 problem details response.
+	ProblemTyper interface {
+		ProblemType() string
+	}
+
+	// ProblemInstancer is an interface for errors that provide the
+	// "instance" URI of an RFC 7807 problem details response.
+	ProblemInstancer interface {
+		ProblemInstance() string
+	}
+
+	// ErrorEncoder is a function that writes an error response with
+	// the given status code.
+	ErrorEncoder func(w http.ResponseWriter, r *http.Request, err error, statusCode int)
+
 	// Error is an error that can return an HTTP status code.
 	Error struct {
 		StatusCode int
 		Err        error
 	}
+
+	// MissingFieldsError is the error for a request that provides no
+	// value for argument fields tagged `required:"true"`. Its status
+	// code is 400, and JSONErrorEncoder and ProblemJSONEncoder list the
+	// fields in a "missing" member.
+	MissingFieldsError struct {
+		// Fields are the names of the missing fields, as in JSON,
+		// with those of nested structs qualified, such as "page.size".
+		Fields []string
+	}
 )
 
 // NewError returns a new Error with the given status code and wrapped
@@ -34,3 +68,75 @@ func (e *Error) HTTPStatusCode() int {
 func (e *Error) Unwrap() error {
 	return e.Err
 }
+
+func (e *MissingFieldsError) Error() string {
+	if len(e.Fields) == 1 {
+		return fmt.Sprintf("missing required field %q", e.Fields[0])
+	}
+	return "missing required fields: " + strings.Join(e.Fields, ", ")
+}
+
+func (e *MissingFieldsError) HTTPStatusCode() int {
+	return http.StatusBadRequest
+}
+
+// JSONErrorEncoder is the default ErrorEncoder. It writes the error
+// as a JSON object with an "error" property holding the error
+// message, and a "missing" property listing the fields of a
+// MissingFieldsError.
+func JSONErrorEncoder(w http.ResponseWriter, r *http.Request, err error, statusCode int) {
+	body := map[string]interface{}{
+		"error": err.Error(),
+	}
+	var missing *MissingFieldsError
+	if errors.As(err, &missing) {
+		body["missing"] = missing.Fields
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(statusCode)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+// ProblemJSONEncoder is an ErrorEncoder that writes RFC 7807 problem
+// details as application/problem+json. The "detail" member is the
+// error message and "title" is the standard text for the status code.
+// The "type" member is "about:blank" unless the error implements
+// ProblemTyper, and the "instance" member is the request path unless
+// the error implements ProblemInstancer. The fields of a
+// MissingFieldsError are listed in a "missing" extension member.
+func ProblemJSONEncoder(w http.ResponseWriter, r *http.Request, err error, statusCode int) {
+	problem := struct {
+		Type     string   `json:"type"`
+		Title    string   `json:"title"`
+		Status   int      `json:"status"`
+		Detail   string   `json:"detail"`
+		Instance string   `json:"instance,omitempty"`
+		Missing  []string `json:"missing,omitempty"`
+	}{
+		Type:     "about:blank",
+		Title:    http.StatusText(statusCode),
+		Status:   statusCode,
+		Detail:   err.Error(),
+		Instance: r.URL.Path,
+	}
+
+	var typer ProblemTyper
+	if errors.As(err, &typer) {
+		problem.Type = typer.ProblemType()
+	}
+	var instancer ProblemInstancer
+	if errors.As(err, &instancer) {
+		problem.Instance = instancer.ProblemInstance()
+	}
+	var missing *MissingFieldsError
+	if errors.As(err, &missing) {
+		problem.Missing = missing.Fields
+	}
+
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(statusCode)
+	_ = json.NewEncoder(w).Encode(problem)
+}
@@ -1,10 +1,21 @@
 package structhttp
 
 import (
+	"encoding/json"
 	"errors"
+	"net/http/httptest"
+	"reflect"
+	"strings"
 	"testing"
 )
 
+type typedError struct{}
+
+func (typedError) Error() string           { return "out of credit" }
+func (typedError) HTTPStatusCode() int     { return 403 }
+func (typedError) ProblemType() string     { return "https://example.com/probs/out-of-credit" }
+func (typedError) ProblemInstance() string { return "/account/12345/msgs/abc" }
+
 func TestError(t *testing.T) {
 	wrapped := errors.New("test error")
 	err := NewError(500, wrapped)
@@ -13,3 +24,71 @@ func TestError(t *testing.T) {
 		t.Errorf("expected error to wrap %v", wrapped)
 	}
 }
+
+func TestProblemJSONEncoder(t *testing.T) {
+	testCases := []struct {
+		name     string
+		err      error
+		expected map[string]any
+	}{
+		{
+			name: "error",
+			err:  NewError(400, errors.New("invalid request")),
+			expected: map[string]any{
+				"type":     "about:blank",
+				"title":    "Bad Request",
+				"status":   float64(400),
+				"detail":   "invalid request",
+				"instance": "/Inputs",
+			},
+		},
+		{
+			name: "typed error",
+			err:  typedError{},
+			expected: map[string]any{
+				"type":     "https://example.com/probs/out-of-credit",
+				"title":    "Forbidden",
+				"status":   float64(403),
+				"detail":   "out of credit",
+				"instance": "/account/12345/msgs/abc",
+			},
+		},
+		{
+			name: "missing fields",
+			err:  &MissingFieldsError{Fields: []string{"name", "owner.id"}},
+			expected: map[string]any{
+				"type":     "about:blank",
+				"title":    "Bad Request",
+				"status":   float64(400),
+				"detail":   "missing required fields: name, owner.id",
+				"instance": "/Inputs",
+				"missing":  []any{"name", "owner.id"},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			handler := Handler(&app{err: tc.err}, WithErrorEncoder(ProblemJSONEncoder))
+
+			req := httptest.NewRequest("POST", "/Inputs", strings.NewReader("{}"))
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if w.Code != int(tc.expected["status"].(float64)) {
+				t.Errorf("expected status code %v, got %d", tc.expected["status"], w.Code)
+			}
+			if ct := w.Header().Get("Content-Type"); ct != "application/problem+json" {
+				t.Errorf("expected content type application/problem+json, got %q", ct)
+			}
+			var problem map[string]any
+			if err := json.Unmarshal(w.Body.Bytes(), &problem); err != nil {
+				t.Fatalf("expected problem response, got %q", w.Body.String())
+			}
+			if !reflect.DeepEqual(problem, tc.expected) {
+				t.Errorf("expected problem %v, got %v", tc.expected, problem)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package structhttp
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// isEventStream reports whether v is a channel that can be received
+// from, and so can be written as a server-sent event stream.
+func isEventStream(v reflect.Value) bool {
+	return v.Kind() == reflect.Chan && v.Type().ChanDir()&reflect.RecvDir != 0
+}
+
+// drain receives from ch in the background until it is closed, so that
+// a method still sending on it after its response has ended does not
+// block forever.
+func drain(ch reflect.Value) {
+	go func() {
+		for {
+			if _, ok := ch.Recv(); !ok {
+				return
+			}
+		}
+	}()
+}
+
+// writeEvents writes each value received from ch as a server-sent
+// event, flushing after each one, until ch is closed or the request
+// context is done, after which ch is drained. Strings are written
+// verbatim, as one data line for each of their lines, however they
+// end; other values are encoded as JSON with marshal.
+func writeEvents(w http.ResponseWriter, r *http.Request, ch reflect.Value, marshal func(any) ([]byte, error)) error {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	rc := http.NewResponseController(w)
+	_ = rc.Flush()
+	if ch.IsNil() {
+		return nil
+	}
+
+	closed := false
+	defer func() {
+		if !closed {
+			drain(ch)
+		}
+	}()
+	cases := []reflect.SelectCase{
+		{Dir: reflect.SelectRecv, Chan: ch},
+		{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(r.Context().Done())},
+	}
+	for {
+		chosen, v, ok := reflect.Select(cases)
+		if chosen == 0 && !ok {
+			closed = true
+		}
+		if chosen == 1 || !ok {
+			return nil
+		}
+
+		data, isString := v.Interface().(string)
+		if !isString {
+			b, err := marshal(v.Interface())
+			if err != nil {
+				return fmt.Errorf("failed to encode event: %w", err)
+			}
+			data = string(b)
+		}
+
+		// A line ends at CR, LF, or CRLF in an event stream, so each
+		// must start a new data line rather than another field.
+		data = strings.ReplaceAll(data, "\r\n", "\n")
+		data = strings.ReplaceAll(data, "\r", "\n")
+		var sb strings.Builder
+		for _, line := range strings.Split(data, "\n") {
+			sb.WriteString("data: ")
+			sb.WriteString(line)
+			sb.WriteString("\n")
+		}
+		sb.WriteString("\n")
+		if _, err := w.Write([]byte(sb.String())); err != nil {
+			return nil
+		}
+		_ = rc.Flush()
+	}
+}
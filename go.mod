@@ -1,3 +1,16 @@
 module github.com/jfhamlin/structhttp
 
-go 1.21
+go 1.22
+
+require (
+	github.com/fxamacker/cbor/v2 v2.9.0
+	github.com/vmihailenco/msgpack/v5 v5.4.1
+	google.golang.org/protobuf v1.36.7
+	sigs.k8s.io/yaml v1.6.0
+)
+
+require (
+	github.com/vmihailenco/tagparser/v2 v2.0.0 // indirect
+	github.com/x448/float16 v0.8.4 // indirect
+	go.yaml.in/yaml/v2 v2.4.2 // indirect
+)
@@ -0,0 +1,53 @@
+package structhttp
+
+import (
+	"encoding/gob"
+	"errors"
+	"io"
+	"reflect"
+)
+
+// GobContentType is the Content-Type of encoding/gob bodies, that of
+// GobCodec.
+const GobContentType = "application/x-gob"
+
+// GobCodec is the Codec for encoding/gob, encoding with
+// GobResponseEncoder, for registering between Go services that share
+// types with WithCodecs:
+//
+//	structhttp.WithCodecs(structhttp.GobCodec)
+//
+// GobCodec only encodes: gob request bodies are declined with a 415
+// response. A handler that serves only trusted callers may opt into
+// decoding them as well, as described for GobRequestDecoder.
+var GobCodec = NewCodec(GobContentType, GobResponseEncoder, nil)
+
+// GobResponseEncoder is a ResponseEncoder that encodes results with
+// encoding/gob, as a stream of their own, so that values such as
+// time.Time are sent exactly and structs without their field names'
+// overhead beyond the stream's type definitions. Values held in
+// interfaces must be registered with gob.Register, as for any gob
+// stream. As gob cannot send nil, the encoder declines nil results,
+// which are then encoded as JSON.
+func GobResponseEncoder(w io.Writer, v any) error {
+	if rv := reflect.ValueOf(v); !rv.IsValid() || rv.Kind() == reflect.Pointer && rv.IsNil() {
+		return errors.ErrUnsupported
+	}
+	return gob.NewEncoder(w).Encode(v)
+}
+
+// GobRequestDecoder is a RequestDecoder that decodes a value encoded
+// as by GobResponseEncoder into v, a pointer to a type compatible with
+// the one encoded, as by the rules of encoding/gob.
+//
+// encoding/gob is not hardened against adversarial input: a crafted
+// stream can make the decoder allocate far more memory than its size,
+// or spend excessive time on type definitions. Register
+// GobRequestDecoder only for handlers whose callers are trusted, such
+// as internal services, and bound the body with WithMaxBodyBytes:
+//
+//	structhttp.WithRequestDecoder(structhttp.GobContentType, structhttp.GobRequestDecoder),
+//	structhttp.WithMaxBodyBytes(1<<20),
+func GobRequestDecoder(r io.Reader, v any) error {
+	return gob.NewDecoder(r).Decode(v)
+}
@@ -0,0 +1,104 @@
+package structhttp
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type gobRecord struct {
+	Name    string
+	Created time.Time
+	Scores  map[string]float64
+}
+
+func TestGobRoundTrip(t *testing.T) {
+	want := gobRecord{
+		Name:    "x",
+		Created: time.Date(2024, 1, 2, 3, 4, 5, 6, time.FixedZone("", -5*60*60)),
+		Scores:  map[string]float64{"a": 0.1},
+	}
+	var buf bytes.Buffer
+	if err := GobResponseEncoder(&buf, &want); err != nil {
+		t.Fatal(err)
+	}
+	var got gobRecord
+	if err := GobRequestDecoder(&buf, &got); err != nil {
+		t.Fatal(err)
+	}
+	if _, offset := got.Created.Zone(); got.Name != want.Name || !got.Created.Equal(want.Created) || offset != -5*60*60 || got.Scores["a"] != 0.1 {
+		t.Errorf("expected %+v, got %+v", want, got)
+	}
+
+	if err := GobRequestDecoder(&bytes.Buffer{}, &got); err == nil || err.Error() != "EOF" {
+		t.Errorf("expected EOF decoding an empty body, got %v", err)
+	}
+}
+
+func TestHandlerGob(t *testing.T) {
+	handler := Handler(&app{},
+		WithRequestDecoder(GobContentType, GobRequestDecoder),
+		WithResponseEncoder(GobContentType, GobResponseEncoder),
+	)
+
+	var body bytes.Buffer
+	if err := GobResponseEncoder(&body, testArgs{ID: 1, Name: "x"}); err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest("POST", "/Inputs", &body)
+	req.Header.Set("Content-Type", GobContentType)
+	req.Header.Set("Accept", GobContentType)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != 200 || w.Header().Get("Content-Type") != GobContentType {
+		t.Fatalf("expected 200 with Content-Type %q, got %d with %q", GobContentType, w.Code, w.Header().Get("Content-Type"))
+	}
+	var got testArgs
+	if err := GobRequestDecoder(w.Body, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got != (testArgs{ID: 1, Name: "x"}) {
+		t.Errorf("expected the arguments echoed, got %+v", got)
+	}
+
+	// A nil result cannot be sent as gob, so it is sent as JSON.
+	req = httptest.NewRequest("POST", "/Inputs", nil)
+	req.Header.Set("Content-Type", GobContentType)
+	req.Header.Set("Accept", GobContentType)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != 200 || w.Body.String() != "null\n" || w.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("expected 200 with JSON null, got %d with %q (%s)", w.Code, w.Body.String(), w.Header().Get("Content-Type"))
+	}
+}
+
+func TestHandlerGobCodecEncodeOnly(t *testing.T) {
+	handler := Handler(&app{}, WithCodecs(GobCodec))
+
+	var body bytes.Buffer
+	if err := GobResponseEncoder(&body, testArgs{ID: 1, Name: "x"}); err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest("POST", "/Inputs", &body)
+	req.Header.Set("Content-Type", GobContentType)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != 415 {
+		t.Errorf("expected 415 for a gob request body, got %d with %q", w.Code, w.Body.String())
+	}
+
+	req = httptest.NewRequest("POST", "/Inputs", strings.NewReader(`{"id":1,"name":"x"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", GobContentType)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	var got testArgs
+	if err := GobRequestDecoder(w.Body, &got); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != 200 || got != (testArgs{ID: 1, Name: "x"}) {
+		t.Errorf("expected 200 with the arguments echoed as gob, got %d with %+v", w.Code, got)
+	}
+}
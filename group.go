@@ -0,0 +1,76 @@
+package structhttp
+
+import (
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// Service is a struct served by HandlerGroup with options of its own,
+// which are applied after the options shared by the group.
+type Service struct {
+	Struct  any
+	Options []Option
+}
+
+// groupHandler routes requests by path prefix to the handlers of a
+// HandlerGroup.
+type groupHandler struct {
+	prefixes []string
+	handlers map[string]http.Handler
+	fallback http.Handler
+}
+
+// HandlerGroup returns an http.Handler serving several structs, each
+// under a path prefix such as "/users". A request is served by the
+// struct with the longest prefix matching its path, with the prefix
+// removed, so a method Create of the struct mounted at "/users" is
+// served at "/users/Create". Each struct is served as by Handler with
+// opts; to give a struct options of its own, pass it as a Service.
+// Requests matching no prefix are answered as unmatched requests are
+// by Handler with opts.
+func HandlerGroup(services map[string]any, opts ...Option) http.Handler {
+	g := &groupHandler{
+		handlers: make(map[string]http.Handler, len(services)),
+		fallback: Handler(struct{}{}, opts...),
+	}
+	for prefix, s := range services {
+		prefix = "/" + strings.Trim(prefix, "/")
+		if svc, ok := s.(Service); ok {
+			g.handlers[prefix] = Handler(svc.Struct, append(opts[:len(opts):len(opts)], svc.Options...)...)
+		} else {
+			g.handlers[prefix] = Handler(s, opts...)
+		}
+		g.prefixes = append(g.prefixes, prefix)
+	}
+	sort.Slice(g.prefixes, func(i, j int) bool {
+		if len(g.prefixes[i]) != len(g.prefixes[j]) {
+			return len(g.prefixes[i]) > len(g.prefixes[j])
+		}
+		return g.prefixes[i] < g.prefixes[j]
+	})
+	return g
+}
+
+func (g *groupHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	for _, prefix := range g.prefixes {
+		rest, ok := strings.CutPrefix(r.URL.Path, prefix)
+		if !ok || (rest != "" && !strings.HasPrefix(rest, "/") && prefix != "/") {
+			continue
+		}
+		if prefix == "/" {
+			rest = r.URL.Path
+		}
+
+		r2 := new(http.Request)
+		*r2 = *r
+		r2.URL = new(url.URL)
+		*r2.URL = *r.URL
+		r2.URL.Path = rest
+		r2.URL.RawPath = ""
+		g.handlers[prefix].ServeHTTP(w, r2)
+		return
+	}
+	g.fallback.ServeHTTP(w, r)
+}
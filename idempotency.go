@@ -0,0 +1,180 @@
+package structhttp
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// maxIdempotentResponseBytes is the size of the largest response body
+// stored for replay; larger responses are not stored.
+const maxIdempotentResponseBytes = 1 << 20
+
+// IdempotencyScopeFunc returns the caller on whose behalf r is made,
+// such as an authenticated user or API client ID, to which its
+// Idempotency-Key is scoped, so that one caller's key never replays
+// another's response. An empty scope exempts r from deduplication.
+type IdempotencyScopeFunc func(r *http.Request) string
+
+// IdempotencyStore stores responses to requests carrying an
+// Idempotency-Key header so that retries can be answered without
+// calling the method again. Implementations must be safe for
+// concurrent use. A store shared across processes deduplicates
+// retries that arrive after a response is stored, but does not
+// serialize concurrent duplicates, which are locked only within each
+// process.
+type IdempotencyStore interface {
+	// Get returns the response stored under key, or false if there
+	// is none or it has expired.
+	Get(ctx context.Context, key string) (*StoredResponse, bool, error)
+
+	// Set stores resp under key for the duration of ttl.
+	Set(ctx context.Context, key string, resp *StoredResponse, ttl time.Duration) error
+}
+
+// StoredResponse is a response recorded by a Handler configured with
+// WithIdempotency.
+type StoredResponse struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+// MemoryIdempotencyStore is an IdempotencyStore that keeps responses
+// in memory. Expired responses are removed when they are next looked
+// up.
+type MemoryIdempotencyStore struct {
+	mu      sync.Mutex
+	entries map[string]memoryEntry
+}
+
+type memoryEntry struct {
+	resp    *StoredResponse
+	expires time.Time
+}
+
+// NewMemoryIdempotencyStore returns an empty MemoryIdempotencyStore.
+func NewMemoryIdempotencyStore() *MemoryIdempotencyStore {
+	return &MemoryIdempotencyStore{entries: make(map[string]memoryEntry)}
+}
+
+func (s *MemoryIdempotencyStore) Get(ctx context.Context, key string) (*StoredResponse, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[key]
+	if !ok {
+		return nil, false, nil
+	}
+	if time.Now().After(entry.expires) {
+		delete(s.entries, key)
+		return nil, false, nil
+	}
+	return entry.resp, true, nil
+}
+
+func (s *MemoryIdempotencyStore) Set(ctx context.Context, key string, resp *StoredResponse, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.entries[key] = memoryEntry{resp: resp, expires: time.Now().Add(ttl)}
+	return nil
+}
+
+// idempotency deduplicates POST requests by their Idempotency-Key
+// header, serializing concurrent requests with the same key.
+type idempotency struct {
+	store IdempotencyStore
+	ttl   time.Duration
+	scope IdempotencyScopeFunc
+
+	mu    sync.Mutex
+	locks map[string]*keyLock
+}
+
+type keyLock struct {
+	sync.Mutex
+	refs int
+}
+
+// key returns the store key for a request to a method, or "" if the
+// request is not subject to deduplication. The scope is quoted so that
+// no two pairs of scope and key share a store key.
+func (id *idempotency) key(r *http.Request, method *methodInfo) string {
+	if id == nil || id.scope == nil || r.Method != http.MethodPost {
+		return ""
+	}
+	key := r.Header.Get("Idempotency-Key")
+	if key == "" {
+		return ""
+	}
+	scope := id.scope(r)
+	if scope == "" {
+		return ""
+	}
+	return method.Name + ":" + strconv.Quote(scope) + ":" + key
+}
+
+// lock acquires the lock for key, returning a function that releases
+// it.
+func (id *idempotency) lock(key string) func() {
+	id.mu.Lock()
+	l, ok := id.locks[key]
+	if !ok {
+		l = &keyLock{}
+		id.locks[key] = l
+	}
+	l.refs++
+	id.mu.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		id.mu.Lock()
+		if l.refs--; l.refs == 0 {
+			delete(id.locks, key)
+		}
+		id.mu.Unlock()
+	}
+}
+
+// replay writes the response stored under key, if any, reporting
+// whether it did.
+func (sh *structHandler) replay(w http.ResponseWriter, r *http.Request, key string) bool {
+	resp, ok, err := sh.idempotency.store.Get(r.Context(), key)
+	if err != nil {
+		sh.logger.ErrorContext(r.Context(), "failed to load idempotent response", "path", r.URL.Path, "error", err)
+		return false
+	}
+	if !ok {
+		return false
+	}
+
+	for k, v := range resp.Header {
+		w.Header()[k] = v
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(resp.StatusCode)
+	_, _ = w.Write(resp.Body)
+	return true
+}
+
+// remember stores the response recorded by rw under key. Server errors
+// are not stored, so that retries call the method again, and neither
+// are bodies too large to have been recorded.
+func (sh *structHandler) remember(rw *responseWriter, r *http.Request, key string) {
+	if rw.status == 0 || rw.status >= http.StatusInternalServerError || rw.recorded == nil {
+		return
+	}
+	resp := &StoredResponse{
+		StatusCode: rw.status,
+		Header:     rw.Header().Clone(),
+		Body:       bytes.Clone(rw.recorded.Bytes()),
+	}
+	if err := sh.idempotency.store.Set(r.Context(), key, resp, sh.idempotency.ttl); err != nil {
+		sh.logger.ErrorContext(r.Context(), "failed to store idempotent response", "path", r.URL.Path, "error", err)
+	}
+}
@@ -0,0 +1,45 @@
+// Package msgpack provides a structhttp.Codec for MessagePack, a
+// compact binary form of JSON's data model, backed by
+// github.com/vmihailenco/msgpack:
+//
+//	structhttp.Handler(svc, structhttp.WithCodecs(msgpack.Codec))
+package msgpack
+
+import (
+	"io"
+
+	vmsgpack "github.com/vmihailenco/msgpack/v5"
+
+	"github.com/jfhamlin/structhttp"
+)
+
+// ContentType is the Content-Type of MessagePack bodies, that of
+// Codec.
+const ContentType = "application/msgpack"
+
+// Codec is the structhttp.Codec for ContentType, encoding with
+// ResponseEncoder and decoding with RequestDecoder.
+var Codec = structhttp.NewCodec(ContentType, ResponseEncoder, RequestDecoder)
+
+// ResponseEncoder is a structhttp.ResponseEncoder that encodes results
+// as MessagePack. Structs are encoded as maps keyed by the names in
+// their `json` tags, or else their field names, honoring `json:"-"`
+// and omitempty, integers in their smallest form, and time.Time
+// values with the timestamp extension type.
+func ResponseEncoder(w io.Writer, v any) error {
+	enc := vmsgpack.NewEncoder(w)
+	enc.SetCustomStructTag("json")
+	enc.UseCompactInts(true)
+	return enc.Encode(v)
+}
+
+// RequestDecoder is a structhttp.RequestDecoder that decodes a
+// MessagePack value into v, with the mapping of ResponseEncoder. Into
+// an any, maps are decoded as map[string]any, arrays as []any,
+// integers as int64, or uint64 if too large, and floats as float64.
+func RequestDecoder(r io.Reader, v any) error {
+	dec := vmsgpack.NewDecoder(r)
+	dec.SetCustomStructTag("json")
+	dec.UseLooseInterfaceDecoding(true)
+	return dec.Decode(v)
+}
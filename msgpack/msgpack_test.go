@@ -0,0 +1,183 @@
+package msgpack
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jfhamlin/structhttp"
+)
+
+type record struct {
+	ID      int               `json:"id"`
+	Skipped string            `json:"-"`
+	Empty   string            `json:",omitempty"`
+	Name    string            `json:"name"`
+	Created time.Time         `json:"created"`
+	Tags    []string          `json:"tags"`
+	Labels  map[string]string `json:"labels"`
+	Score   float64           `json:"score"`
+}
+
+func TestEncoding(t *testing.T) {
+	testCases := []struct {
+		name string
+		v    any
+		want string
+	}{
+		{"nil", nil, "c0"},
+		{"positive fixint", 1, "01"},
+		{"negative fixint", -1, "ff"},
+		{"int8", -33, "d0df"},
+		{"uint8", 200, "ccc8"},
+		{"float64", 1.5, "cb3ff8000000000000"},
+		{"fixstr", "a", "a161"},
+		{"str8", strings.Repeat("x", 32), "d920" + strings.Repeat("78", 32)},
+		{"binary", []byte{1}, "c40101"},
+		{"map", map[string]int{"a": 1}, "81a16101"},
+		{"struct", struct {
+			ID      int    `json:"id"`
+			Skipped string `json:"-"`
+			Empty   string `json:",omitempty"`
+		}{ID: 1, Skipped: "x"}, "81a2696401"},
+		{"timestamp", time.Unix(1, 0), "d6ff00000001"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := ResponseEncoder(&buf, tc.v); err != nil {
+				t.Fatal(err)
+			}
+			if got := hex.EncodeToString(buf.Bytes()); got != tc.want {
+				t.Errorf("expected %s, got %s", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	want := testRecord()
+	var buf bytes.Buffer
+	if err := ResponseEncoder(&buf, want); err != nil {
+		t.Fatal(err)
+	}
+	var got record
+	if err := RequestDecoder(&buf, &got); err != nil {
+		t.Fatal(err)
+	}
+	got.Created = got.Created.UTC()
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %+v, got %+v", want, got)
+	}
+
+	buf.Reset()
+	if err := ResponseEncoder(&buf, map[string]any{"n": 1, "big": uint64(1 << 63), "f": 1.5, "list": []int{1}}); err != nil {
+		t.Fatal(err)
+	}
+	var generic any
+	if err := RequestDecoder(&buf, &generic); err != nil {
+		t.Fatal(err)
+	}
+	wantGeneric := map[string]any{"n": int64(1), "big": uint64(1 << 63), "f": 1.5, "list": []any{int64(1)}}
+	if !reflect.DeepEqual(generic, wantGeneric) {
+		t.Errorf("expected %#v, got %#v", wantGeneric, generic)
+	}
+}
+
+type service struct{}
+
+func (service) Echo(r record) record { return r }
+
+func TestHandler(t *testing.T) {
+	handler := structhttp.Handler(service{}, structhttp.WithCodecs(Codec))
+
+	var body bytes.Buffer
+	if err := ResponseEncoder(&body, testRecord()); err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest("POST", "/Echo", &body)
+	r.Header.Set("Content-Type", ContentType)
+	r.Header.Set("Accept", ContentType)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if got := w.Header().Get("Content-Type"); w.Code != 200 || got != ContentType {
+		t.Fatalf("expected 200 with Content-Type %q, got %d with %q", ContentType, w.Code, got)
+	}
+	var got record
+	if err := RequestDecoder(w.Body, &got); err != nil {
+		t.Fatal(err)
+	}
+	got.Created = got.Created.UTC()
+	if want := testRecord(); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %+v, got %+v", want, got)
+	}
+}
+
+func testRecord() record {
+	return record{
+		ID:      7,
+		Name:    "widget",
+		Created: time.Unix(1700000000, 123456789).UTC(),
+		Tags:    []string{"a", "b", "c"},
+		Labels:  map[string]string{"env": "prod", "team": "core"},
+		Score:   0.75,
+	}
+}
+
+// BenchmarkEncode and BenchmarkDecode compare the codec with
+// encoding/json, which Handler uses by default, on a typical result.
+func BenchmarkEncode(b *testing.B) {
+	v := testRecord()
+	b.Run("msgpack", func(b *testing.B) {
+		var buf bytes.Buffer
+		for i := 0; i < b.N; i++ {
+			buf.Reset()
+			if err := ResponseEncoder(&buf, v); err != nil {
+				b.Fatal(err)
+			}
+		}
+		b.ReportMetric(float64(buf.Len()), "bytes/op")
+	})
+	b.Run("json", func(b *testing.B) {
+		var buf bytes.Buffer
+		for i := 0; i < b.N; i++ {
+			buf.Reset()
+			if err := json.NewEncoder(&buf).Encode(v); err != nil {
+				b.Fatal(err)
+			}
+		}
+		b.ReportMetric(float64(buf.Len()), "bytes/op")
+	})
+}
+
+func BenchmarkDecode(b *testing.B) {
+	var packed, plain bytes.Buffer
+	if err := ResponseEncoder(&packed, testRecord()); err != nil {
+		b.Fatal(err)
+	}
+	if err := json.NewEncoder(&plain).Encode(testRecord()); err != nil {
+		b.Fatal(err)
+	}
+	b.Run("msgpack", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			var v record
+			if err := RequestDecoder(bytes.NewReader(packed.Bytes()), &v); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("json", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			var v record
+			if err := json.NewDecoder(bytes.NewReader(plain.Bytes())).Decode(&v); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,330 @@
+package structhttp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"reflect"
+	"sort"
+)
+
+// defaultMaxMultipartParts is the number of parts of a multipart form
+// read by readMultipart without WithMaxMultipartParts, the default
+// limit of the mime/multipart package.
+const defaultMaxMultipartParts = 1000
+
+// errFileTooLarge reports an uploaded file larger than the limit set
+// with WithMaxFileBytes.
+var errFileTooLarge = errors.New("file too large")
+
+// maxMultipartMemory is the number of bytes of a multipart form's
+// files held in memory; the remainder is stored in temporary files.
+const maxMultipartMemory = 32 << 20
+
+var (
+	fileType        = reflect.TypeOf((*multipart.File)(nil)).Elem()
+	fileHeaderType  = reflect.TypeOf((*multipart.FileHeader)(nil))
+	fileHeadersType = reflect.TypeOf([]*multipart.FileHeader(nil))
+	readerType      = reflect.TypeOf((*io.Reader)(nil)).Elem()
+	readCloserType  = reflect.TypeOf((*io.ReadCloser)(nil)).Elem()
+	bytesType       = reflect.TypeOf([]byte(nil))
+)
+
+// isMultipart reports whether r has a multipart/form-data body.
+func isMultipart(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == "multipart/form-data"
+}
+
+// isForm reports whether r has an application/x-www-form-urlencoded
+// body.
+func isForm(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == "application/x-www-form-urlencoded"
+}
+
+// decodeForm parses the URL-encoded form of r and binds its values to
+// the fields of arg, a pointer to a method argument, as by bindValues
+// with the `form` tag. Such a form carries no files, so a required
+// `file` field is reported missing.
+func (o *options) decodeForm(r *http.Request, arg reflect.Value) error {
+	if err := r.ParseForm(); err != nil {
+		return fmt.Errorf("failed to parse form: %w", err)
+	}
+	if err := o.bindValues(arg.Elem(), r.PostForm, "form", "form field"); err != nil {
+		return err
+	}
+	return bindFiles(arg.Elem(), nil)
+}
+
+// decodeMultipart parses the multipart form of r into arg, a pointer
+// to a method argument. An argument of a file type, as accepted by
+// setFile, receives the first uploaded file. For a struct argument,
+// fields tagged `file:"name"` receive the files uploaded under that
+// name, and other fields are bound from the form values as by
+// bindValues with the `form` tag. The form is read as described for
+// readMultipart if limits are set with WithMaxMultipartParts or
+// WithMaxFileBytes.
+func (o *options) decodeMultipart(r *http.Request, arg reflect.Value) error {
+	if o.maxParts > 0 || o.maxFileBytes > 0 {
+		form, err := o.readMultipart(r)
+		if err != nil {
+			return err
+		}
+		r.MultipartForm = form
+	} else if err := r.ParseMultipartForm(maxMultipartMemory); err != nil {
+		return fmt.Errorf("failed to parse multipart form: %w", err)
+	}
+	form := r.MultipartForm
+
+	target := arg.Elem()
+	if isFileType(target.Type()) {
+		fh := firstFile(form)
+		if fh == nil {
+			return errors.New("missing file in multipart form")
+		}
+		return setFile(target, fh)
+	}
+
+	if err := o.bindValues(target, form.Value, "form", "form field"); err != nil {
+		return err
+	}
+	return bindFiles(target, form.File)
+}
+
+// readMultipart reads the multipart form of r part by part, as
+// r.ParseMultipartForm would read it whole, failing as soon as the
+// form exceeds the limits set with WithMaxMultipartParts or
+// WithMaxFileBytes, before the rest of the body is read or stored.
+func (o *options) readMultipart(r *http.Request) (*multipart.Form, error) {
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse multipart form: %w", err)
+	}
+
+	maxParts := o.maxParts
+	if maxParts <= 0 {
+		maxParts = defaultMaxMultipartParts
+	}
+	form := &multipart.Form{Value: make(map[string][]string), File: make(map[string][]*multipart.FileHeader)}
+	memory := int64(maxMultipartMemory)
+	for n := 0; ; n++ {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			return form, nil
+		}
+		if err == nil && n >= maxParts {
+			err = NewError(http.StatusBadRequest, fmt.Errorf("too many parts in multipart form: limit is %d", maxParts))
+		}
+		var part *multipart.Form
+		if err == nil {
+			part, err = o.readPart(p, memory)
+		}
+		if err != nil {
+			_ = form.RemoveAll()
+			return nil, err
+		}
+
+		for name, values := range part.Value {
+			form.Value[name] = append(form.Value[name], values...)
+			for _, v := range values {
+				memory -= int64(len(v))
+			}
+		}
+		for name, fhs := range part.File {
+			form.File[name] = append(form.File[name], fhs...)
+			for _, fh := range fhs {
+				memory -= fh.Size
+			}
+		}
+		memory = max(memory, 0)
+	}
+}
+
+// readPart reads p as a form of its own, holding up to memory bytes of
+// it in memory and storing the rest in a temporary file, as
+// multipart.Reader.ReadForm does. A file larger than the limit set
+// with WithMaxFileBytes is an error as soon as the limit is passed.
+func (o *options) readPart(p *multipart.Part, memory int64) (*multipart.Form, error) {
+	limit := int64(-1)
+	if o.maxFileBytes > 0 && p.FileName() != "" {
+		limit = o.maxFileBytes
+	}
+
+	// Copy the part through a pipe as a one-part form, which ReadForm
+	// reads while the part is still being read from the request.
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	copied := make(chan error, 1)
+	go func() {
+		w, err := mw.CreatePart(p.Header)
+		if err == nil {
+			var src io.Reader = p
+			if limit >= 0 {
+				src = io.LimitReader(p, limit+1)
+			}
+			var n int64
+			n, err = io.Copy(w, src)
+			if err == nil && limit >= 0 && n > limit {
+				err = errFileTooLarge
+			}
+		}
+		if err == nil {
+			err = mw.Close()
+		}
+		_ = pw.CloseWithError(err)
+		copied <- err
+	}()
+
+	form, err := multipart.NewReader(pr, mw.Boundary()).ReadForm(memory)
+	_ = pr.CloseWithError(io.ErrClosedPipe)
+	if copyErr := <-copied; copyErr != nil && (err == nil || errors.Is(copyErr, errFileTooLarge)) {
+		err = copyErr
+	}
+	if err != nil {
+		if form != nil {
+			_ = form.RemoveAll()
+		}
+		if errors.Is(err, errFileTooLarge) {
+			return nil, NewError(http.StatusRequestEntityTooLarge, fmt.Errorf("file %q is too large: limit is %d bytes", p.FileName(), limit))
+		}
+		return nil, fmt.Errorf("failed to parse multipart form: %w", err)
+	}
+	return form, nil
+}
+
+// isFileType reports whether setFile can set a value of type t.
+func isFileType(t reflect.Type) bool {
+	switch t {
+	case fileHeaderType, fileType, readerType, bytesType:
+		return true
+	}
+	return false
+}
+
+// setFile sets v to the uploaded file fh: the *multipart.FileHeader
+// itself, the opened file for a multipart.File or io.Reader, or the
+// file's contents for a []byte.
+func setFile(v reflect.Value, fh *multipart.FileHeader) error {
+	if v.Type() == fileHeaderType {
+		v.Set(reflect.ValueOf(fh))
+		return nil
+	}
+
+	f, err := fh.Open()
+	if err != nil {
+		return fmt.Errorf("failed to open uploaded file: %w", err)
+	}
+	if v.Type() != bytesType {
+		v.Set(reflect.ValueOf(f))
+		return nil
+	}
+	defer f.Close()
+	b, err := io.ReadAll(f)
+	if err != nil {
+		return fmt.Errorf("failed to read uploaded file: %w", err)
+	}
+	v.SetBytes(b)
+	return nil
+}
+
+// bindFiles sets the fields of v tagged with `file:"name"` to the
+// files uploaded under that name. A field of type
+// []*multipart.FileHeader receives all of them, and a field of a type
+// accepted by setFile the first.
+func bindFiles(v reflect.Value, files map[string][]*multipart.FileHeader) error {
+	sv, ok := structTarget(v, "file")
+	if !ok {
+		return nil
+	}
+
+	st := sv.Type()
+	for i := 0; i < st.NumField(); i++ {
+		field := st.Field(i)
+		name, required, ok := parseTag(field, "file")
+		if !ok {
+			continue
+		}
+
+		fhs := files[name]
+		if len(fhs) == 0 {
+			if required {
+				return fmt.Errorf("missing required file %q", name)
+			}
+			continue
+		}
+
+		switch {
+		case field.Type == fileHeadersType:
+			sv.Field(i).Set(reflect.ValueOf(fhs))
+		case isFileType(field.Type):
+			if err := setFile(sv.Field(i), fhs[0]); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unsupported type %s for file %q", field.Type, name)
+		}
+	}
+	return nil
+}
+
+// firstFile returns the first uploaded file of form, ordered by field
+// name, or nil if there is none.
+func firstFile(form *multipart.Form) *multipart.FileHeader {
+	names := make([]string, 0, len(form.File))
+	for name := range form.File {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if fhs := form.File[name]; len(fhs) > 0 {
+			return fhs[0]
+		}
+	}
+	return nil
+}
+
+// isBodyReader reports whether an argument of type t receives the
+// request body unread, as described for DefaultMatcherFunc.
+func isBodyReader(t reflect.Type) bool {
+	return t == readerType || t == readCloserType
+}
+
+// closeFiles closes the uploaded files opened for a call of a method
+// of type mt with args: the arguments and `file`-tagged fields of
+// struct arguments of type multipart.File or io.Reader.
+func closeFiles(mt reflect.Type, args []reflect.Value) {
+	for i, arg := range args {
+		if !arg.IsValid() {
+			continue
+		}
+		if t := mt.In(i); t == fileType || t == readerType {
+			closeFile(reflect.ValueOf(arg.Interface()))
+		}
+		if v := reflect.Indirect(arg); v.IsValid() && v.Kind() == reflect.Struct {
+			for i := 0; i < v.NumField(); i++ {
+				if _, ok := v.Type().Field(i).Tag.Lookup("file"); ok {
+					closeFile(v.Field(i))
+				}
+			}
+		}
+	}
+}
+
+// closeFile closes v if it holds an opened uploaded file.
+func closeFile(v reflect.Value) {
+	if !v.IsValid() || !v.CanInterface() {
+		return
+	}
+	if v.Kind() == reflect.Interface || v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return
+		}
+	}
+	if f, ok := v.Interface().(multipart.File); ok {
+		_ = f.Close()
+	}
+}
@@ -0,0 +1,45 @@
+package structhttp
+
+import "net/http"
+
+type (
+	// MultiStatus is a method result for bulk operations that reports
+	// the outcome of each item individually. Handler writes it as JSON
+	// with status 207 Multi-Status.
+	MultiStatus struct {
+		Items []ItemStatus `json:"items"`
+	}
+
+	// ItemStatus is the outcome of a single item in a MultiStatus.
+	ItemStatus struct {
+		Status int    `json:"status"`
+		Result any    `json:"result,omitempty"`
+		Error  string `json:"error,omitempty"`
+	}
+)
+
+// Add records a successful item with the given status code and
+// result.
+func (m *MultiStatus) Add(status int, result any) {
+	m.Items = append(m.Items, ItemStatus{Status: status, Result: result})
+}
+
+// AddError records a failed item. The status code is resolved from
+// err as for errors returned by methods.
+func (m *MultiStatus) AddError(err error) {
+	m.Items = append(m.Items, ItemStatus{Status: statusCode(err), Error: err.Error()})
+}
+
+// multiStatusCode returns http.StatusMultiStatus if v is a
+// MultiStatus, or http.StatusOK otherwise.
+func multiStatusCode(v any) int {
+	switch v := v.(type) {
+	case MultiStatus:
+		return http.StatusMultiStatus
+	case *MultiStatus:
+		if v != nil {
+			return http.StatusMultiStatus
+		}
+	}
+	return http.StatusOK
+}
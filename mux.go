@@ -0,0 +1,81 @@
+package structhttp
+
+import (
+	"net/http"
+)
+
+type (
+	// RegisterFunc registers a handler for requests with the given
+	// HTTP method and path pattern on a router.
+	RegisterFunc func(method, pattern string, h http.Handler)
+
+	// PathParamFunc returns the value of the named path variable of a
+	// request routed by a router.
+	PathParamFunc func(r *http.Request, name string) string
+)
+
+// RegisterRoutes registers the methods of s on mux, each under a
+// pattern such as "POST /Create" or "GET /user/{id}" formed from its
+// route, so that the methods can share mux with other handlers. Routes
+// are derived as by Handler, honoring options such as WithRESTRouting
+// and route tags, and path variables are bound to method arguments as
+// with Handler. As with any ServeMux pattern, a GET route also serves
+// HEAD requests, without a response body.
+//
+// Problems found while building the routes are logged as by Handler.
+// RegisterRoutes panics if a pattern conflicts with one already
+// registered on mux.
+func RegisterRoutes(mux *http.ServeMux, s any, opts ...Option) {
+	sh := newStructHandler(s, opts...)
+	sh.autoHead = true
+	sh.mount(func(method, pattern string, h http.Handler) {
+		mux.Handle(method+" "+pattern, h)
+	}, (*http.Request).PathValue)
+}
+
+// Mount registers the methods of s on a third-party router, such as
+// chi or gorilla/mux, whose patterns use the {name} syntax for path
+// variables. Each method's route is passed to register, and the values
+// of path variables are read from routed requests with pathParam and
+// bound to method arguments as with Handler. For example, with chi:
+//
+//	structhttp.Mount(r.Method, chi.URLParam, svc, structhttp.WithRESTRouting(true))
+//
+// and with gorilla/mux:
+//
+//	structhttp.Mount(func(method, pattern string, h http.Handler) {
+//		r.Handle(pattern, h).Methods(method)
+//	}, func(req *http.Request, name string) string {
+//		return mux.Vars(req)[name]
+//	}, svc, structhttp.WithRESTRouting(true))
+//
+// Routes ending in a {name...} variable use http.ServeMux syntax and
+// must be translated by register for routers that spell it otherwise.
+// Problems found while building the routes are logged as by Handler.
+func Mount(register RegisterFunc, pathParam PathParamFunc, s any, opts ...Option) {
+	newStructHandler(s, opts...).mount(register, pathParam)
+}
+
+// mount registers a handler for each method with register, reading
+// path variables with pathParam.
+func (sh *structHandler) mount(register RegisterFunc, pathParam PathParamFunc) {
+	if err := sh.validate(); err != nil {
+		sh.logger.Warn("methods have invalid routes or arguments", "error", err)
+	}
+	sh.pathParam = pathParam
+
+	for i := range sh.methods {
+		method := &sh.methods[i]
+		e := sh.endpoint(method.Name)
+		register(e.verb, e.path, sh.methodHandler(method))
+	}
+}
+
+// methodHandler returns a copy of sh that serves only the given
+// method.
+func (sh *structHandler) methodHandler(method *methodInfo) http.Handler {
+	h := *sh
+	h.methods = []methodInfo{*method}
+	h.index = nil
+	return &h
+}
@@ -0,0 +1,121 @@
+package structhttp
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+)
+
+// NDJSONContentType is the Content-Type of newline-delimited JSON
+// streams, written as described for WithNDJSON.
+const NDJSONContentType = "application/x-ndjson"
+
+// ndjsonFlushLines is the number of lines of a slice written as NDJSON
+// between flushes.
+const ndjsonFlushLines = 1000
+
+// ndjsonStream returns v, or the value v holds if it is an interface,
+// and whether it is a receive-capable channel or a slice or array
+// other than a byte slice, and so can be written as NDJSON.
+func ndjsonStream(v reflect.Value) (reflect.Value, bool) {
+	for v.Kind() == reflect.Interface && !v.IsNil() {
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array:
+		return v, v.Type().Elem().Kind() != reflect.Uint8
+	}
+	return v, isEventStream(v)
+}
+
+// acceptsNDJSON reports whether the Accept header of r names
+// application/x-ndjson and prefers it at least as much as the type of
+// the codec the response would otherwise be encoded with.
+func (o *options) acceptsNDJSON(r *http.Request) bool {
+	accept := r.Header.Values("Accept")
+	if len(accept) == 0 {
+		return false
+	}
+	ranges := parseAccept(accept)
+	q, specificity := acceptQuality(ranges, NDJSONContentType)
+	if q == 0 || specificity < 2 {
+		return false
+	}
+	other, _ := acceptQuality(ranges, o.negotiate(r).mediaType)
+	return q >= other
+}
+
+// writeNDJSON writes each element of v, a slice, array, or channel, as
+// a line of JSON with the status code code, until the elements run
+// out, the request context is done, or the next line would take the
+// response past maxBytes, if positive. Elements are encoded with
+// marshal. Lines of values received from a channel are flushed one by
+// one, and the channel is drained if the stream ends before it is
+// closed; lines of a slice or array are flushed every
+// ndjsonFlushLines lines.
+func writeNDJSON(w http.ResponseWriter, r *http.Request, v reflect.Value, code int, maxBytes int64, marshal func(any) ([]byte, error)) error {
+	w.Header().Set("Content-Type", NDJSONContentType)
+	w.Header().Add("Vary", "Accept")
+	w.WriteHeader(code)
+
+	rc := http.NewResponseController(w)
+	_ = rc.Flush()
+
+	var written int64
+	// writeLine writes x as a line, reporting whether to continue.
+	writeLine := func(x reflect.Value) (bool, error) {
+		line, err := marshal(x.Interface())
+		if err != nil {
+			return false, fmt.Errorf("failed to encode line: %w", err)
+		}
+		line = append(line, '\n')
+		if maxBytes > 0 && written+int64(len(line)) > maxBytes {
+			return false, fmt.Errorf("response truncated at limit of %d bytes", maxBytes)
+		}
+		written += int64(len(line))
+		_, err = w.Write(line)
+		return err == nil, nil
+	}
+
+	if v.Kind() == reflect.Chan {
+		if v.IsNil() {
+			return nil
+		}
+		closed := false
+		defer func() {
+			if !closed {
+				drain(v)
+			}
+		}()
+		cases := []reflect.SelectCase{
+			{Dir: reflect.SelectRecv, Chan: v},
+			{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(r.Context().Done())},
+		}
+		for {
+			chosen, x, ok := reflect.Select(cases)
+			if chosen == 0 && !ok {
+				closed = true
+			}
+			if chosen == 1 || !ok {
+				return nil
+			}
+			if more, err := writeLine(x); !more {
+				return err
+			}
+			_ = rc.Flush()
+		}
+	}
+
+	for i := 0; i < v.Len(); i++ {
+		if more, err := writeLine(v.Index(i)); !more {
+			return err
+		}
+		if (i+1)%ndjsonFlushLines == 0 {
+			if r.Context().Err() != nil {
+				return nil
+			}
+			_ = rc.Flush()
+		}
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package structhttp
+
+import (
+	"io"
+	"net/http"
+	"time"
+)
+
+// Observation describes a request served by a Handler.
+type Observation struct {
+	// Method is the name of the method that served the request, or
+	// empty if no method matched.
+	Method string
+
+	// StatusCode is the status code of the response.
+	StatusCode int
+
+	// RequestBytes is the number of bytes read from the request body.
+	RequestBytes int64
+
+	// ResponseBytes is the number of bytes written to the response
+	// body.
+	ResponseBytes int64
+
+	// Duration is the time taken to serve the request.
+	Duration time.Duration
+}
+
+// ObserverFunc is called by a Handler after each request is served.
+type ObserverFunc func(r *http.Request, o Observation)
+
+// countingReader counts the bytes read from a request body.
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.ReadCloser.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
@@ -1,47 +1,1532 @@
 package structhttp
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
+	"mime"
 	"net/http"
 	"reflect"
+	"strings"
+	"time"
 )
 
 type (
 	options struct {
-		matcher MatcherFunc
+		matchers         []Matcher
+		providers        map[reflect.Type]InjectorFunc
+		discoverMethods  bool
+		probeMatchers    bool
+		logger           *slog.Logger
+		baggage          bool
+		sse              bool
+		ndjson           bool
+		successStatus    map[string]int
+		bodyKeys         map[string]string
+		strictJSON       bool
+		requestTypes     []string
+		consumes         map[string][]string
+		contentTypes     map[string]string
+		contextFuncs     []ContextFunc
+		maxQueryParams   int
+		maxParts         int
+		maxFileBytes     int64
+		autoHead         bool
+		skipHead         bool
+		errorEncoder     ErrorEncoder
+		strictPaths      bool
+		absentStatus     int
+		emptyObject      bool
+		cors             *CORSOptions
+		maxBodyBytes     int64
+		decompress       bool
+		maxInflateBytes  int64
+		stringAsText     bool
+		stringerAsText   bool
+		maxRespBytes     int64
+		observer         ObserverFunc
+		notFoundHandler  http.Handler
+		fallback         http.Handler
+		encodeNotFound   bool
+		idempotency      *idempotency
+		jsonPrefix       string
+		jsonIndent       string
+		jsonEngine       JSONEngine
+		jsonRawHTML      bool
+		jsonNoNewline    bool
+		codecs           []codec
+		encodeFunc       ResponseEncoderFunc
+		decoders         map[string]RequestDecoder
+		xml              bool
+		preInvokes       []PreInvokeFunc
+		baseCtx          context.Context
+		restRouting      bool
+		positionalArgs   bool
+		endpoints        map[string]endpoint
+		pathParam        PathParamFunc
+		naming           func(string) string
+		include          map[string]bool
+		exclude          map[string]bool
+		strictSignatures bool
+		priorities       map[string]int
+		paramNames       map[string][]string
+		trailingSlash    TrailingSlashPolicy
+		foldPaths        bool
+		timeFormats      []string
+		converters       map[reflect.Type]TypeConverterFunc
 	}
 
 	// Option is an option for Handler.
 	Option func(*options)
+
+	// optionsKey is the context key under which Handler stores its
+	// options for use by DefaultMatcherFunc.
+	optionsKey struct{}
+
+	// discardHandler is a slog.Handler that drops all records.
+	discardHandler struct{}
+
+	// ArgProviderFunc is a function that supplies the value of a
+	// method argument from the incoming request.
+	ArgProviderFunc func(r *http.Request) (any, error)
+
+	// InjectorFunc is a function that supplies the value of an
+	// injectable method argument from the incoming request.
+	InjectorFunc func(r *http.Request) (reflect.Value, error)
+
+	// ContextFunc is a function that derives the context passed to a
+	// method from the request context.
+	ContextFunc func(ctx context.Context, r *http.Request) context.Context
+
+	// PreInvokeFunc is a function called before a method is invoked
+	// with the method's name and arguments. A non-nil error prevents
+	// the call.
+	PreInvokeFunc func(ctx context.Context, r *http.Request, methodName string, args []any) error
 )
 
 // WithMatcherFunc returns an Option that sets the MatcherFunc for
 // Handler.
 func WithMatcherFunc(m MatcherFunc) Option {
 	return func(o *options) {
-		o.matcher = m
+		o.matchers = []Matcher{m}
+	}
+}
+
+// WithMatcher returns an Option that sets the Matcher for Handler,
+// replacing the default. Matching proceeds as described for
+// WithMatcherFuncs.
+func WithMatcher(m Matcher) Option {
+	return func(o *options) {
+		o.matchers = []Matcher{m}
+	}
+}
+
+// WithMatcherFuncs returns an Option that sets several MatcherFuncs
+// for Handler, replacing the default. For each request, the matchers
+// are tried in order, each against every method, until one matches
+// or returns an error. An error stops the chain and is written as the
+// response. For example,
+//
+//	WithMatcherFuncs(restMatcher, DefaultMatcherFunc)
+//
+// serves RESTful routes alongside the default POST /MethodName routes.
+// When the only matchers are DefaultMatcherFunc and QueryMatcherFunc,
+// Handler looks up the methods whose routes match the request path
+// in an index rather than trying every method.
+func WithMatcherFuncs(ms ...MatcherFunc) Option {
+	return func(o *options) {
+		o.matchers = make([]Matcher, len(ms))
+		for i, m := range ms {
+			o.matchers[i] = m
+		}
+	}
+}
+
+// WithMatchers returns an Option that sets a chain of MatcherFuncs
+// ending with DefaultMatcherFunc. Each matcher may decline a request
+// by not matching it, passing it on to the next, so that a matcher
+// need only handle the requests it recognizes. For example,
+//
+//	WithMatchers(legacyMatcher, restMatcher)
+//
+// tries legacyMatcher, then restMatcher, then the default POST
+// /MethodName routes. Matching otherwise proceeds as described for
+// WithMatcherFuncs.
+func WithMatchers(ms ...MatcherFunc) Option {
+	return func(o *options) {
+		o.matchers = make([]Matcher, 0, len(ms)+1)
+		for _, m := range ms {
+			o.matchers = append(o.matchers, m)
+		}
+		o.matchers = append(o.matchers, MatcherFunc(DefaultMatcherFunc))
+	}
+}
+
+// WithMethods returns an Option that exposes only the named methods,
+// leaving the struct's other methods unrouted. Naming a method the
+// struct does not have is reported as by Handler. Multiple uses of
+// WithMethods combine.
+func WithMethods(names ...string) Option {
+	return func(o *options) {
+		if o.include == nil {
+			o.include = make(map[string]bool)
+		}
+		for _, name := range names {
+			o.include[name] = true
+		}
+	}
+}
+
+// WithExcludeMethods returns an Option that leaves the named methods
+// unrouted, such as helper methods with otherwise routable
+// signatures. It takes precedence over WithMethods.
+func WithExcludeMethods(names ...string) Option {
+	return func(o *options) {
+		if o.exclude == nil {
+			o.exclude = make(map[string]bool)
+		}
+		for _, name := range names {
+			o.exclude[name] = true
+		}
+	}
+}
+
+// WithStrictSignatures returns an Option that controls whether
+// methods that can never be served are reported as problems, as
+// described for Handler, rather than silently left out. Such methods
+// have results other than those described for Handler, or, when the
+// only matchers are DefaultMatcherFunc and QueryMatcherFunc, take more
+// than one argument besides injected ones. With NewHandler, they cause
+// an error.
+func WithStrictSignatures(enabled bool) Option {
+	return func(o *options) {
+		o.strictSignatures = enabled
+	}
+}
+
+// WithNamingStrategy returns an Option that sets the function
+// converting method names to the paths at which they are served, such
+// as KebabCase, SnakeCase, or LowerCamelCase. With KebabCase,
+// GetUserProfile is served at POST /get-user-profile rather than
+// /GetUserProfile. With WithRESTRouting, the strategy converts the
+// resource names in derived paths instead, which are otherwise in
+// kebab case. Routes declared with route tags are not affected.
+func WithNamingStrategy(fn func(methodName string) string) Option {
+	return func(o *options) {
+		o.naming = fn
+	}
+}
+
+// WithRESTRouting returns an Option that controls whether methods
+// named with a REST prefix are served at resource paths derived from
+// their names instead of POST /MethodName:
+//
+//	ListUsers   GET    /users
+//	GetUser     GET    /user/{id}
+//	CreateUser  POST   /user
+//	UpdateUser  PUT    /user/{id}
+//	PatchUser   PATCH  /user/{id}
+//	DeleteUser  DELETE /user/{id}
+//
+// Multi-word resource names are written in kebab case, so
+// GetUserProfile is served at /user-profile/{id}, unless changed with
+// WithNamingStrategy. The {id} segment is bound to the method's
+// argument: directly if it is a scalar, or to the field named by a
+// `path:"id"` tag, `json` tag, or field name, ignoring case, if it is
+// a struct. Methods without such a prefix keep the default route,
+// and routes declared with `route` tags take precedence. The values
+// of the variables of the matched route can also be read with
+// PathParams.
+func WithRESTRouting(enabled bool) Option {
+	return func(o *options) {
+		o.restRouting = enabled
+	}
+}
+
+// WithPositionalArgs returns an Option that controls whether methods
+// taking only scalar arguments, such as strings, numbers, booleans,
+// and types implementing encoding.TextUnmarshaler, are served with
+// their arguments in trailing path segments. With it enabled,
+// Add(a, b int) is served at GET /Add/{arg0}/{arg1}, so that
+// GET /Add/1/2 calls Add(1, 2); the variables are named by
+// WithParamNames if given. Methods routed by a tag or WithRESTRouting
+// keep their routes.
+//
+// More generally, DefaultMatcherFunc binds a method taking several
+// scalar arguments from the variables of its route, in order, when
+// there is one variable for each argument.
+func WithPositionalArgs(enabled bool) Option {
+	return func(o *options) {
+		o.positionalArgs = enabled
+	}
+}
+
+// WithTrailingSlashPolicy returns an Option that sets how Handler
+// treats requests that match no method, but would once a trailing
+// slash is removed from their path, or added if it has none. By
+// default, they match no method.
+func WithTrailingSlashPolicy(policy TrailingSlashPolicy) Option {
+	return func(o *options) {
+		o.trailingSlash = policy
+	}
+}
+
+// WithCaseInsensitivePaths returns an Option that controls whether
+// the paths of requests are matched to the routes of methods without
+// regard to case, so that /inputs matches the route /Inputs. The
+// values of path variables keep their case. Custom matchers are
+// unaffected.
+func WithCaseInsensitivePaths(enabled bool) Option {
+	return func(o *options) {
+		o.foldPaths = enabled
+	}
+}
+
+// WithStrictPaths returns an Option that controls whether
+// DefaultMatcherFunc and QueryMatcherFunc accept only the canonical
+// path of a method. By default, a method named Create matches both
+// "/Create" and "Create"; with strict paths, only "/Create" matches.
+func WithStrictPaths(enabled bool) Option {
+	return func(o *options) {
+		o.strictPaths = enabled
+	}
+}
+
+// WithEmptyObjectResponse returns an Option that controls whether
+// methods returning nothing, or only a nil error, respond with status
+// 200 and an empty JSON object, {}, rather than 204 with no body. A
+// status code set with WithSuccessStatus still takes precedence; if
+// it does not permit a body, such as 204, no body is written.
+func WithEmptyObjectResponse(enabled bool) Option {
+	return func(o *options) {
+		o.emptyObject = enabled
+	}
+}
+
+// WithJSONIndent returns an Option that indents JSON-encoded results
+// as by json.Encoder.SetIndent, beginning each line with prefix and
+// indenting nested elements with indent. Results are compact by
+// default. Error responses and server-sent events are not affected.
+func WithJSONIndent(prefix, indent string) Option {
+	return func(o *options) {
+		o.jsonPrefix = prefix
+		o.jsonIndent = indent
+	}
+}
+
+// WithJSONEscapeHTML returns an Option that controls whether strings
+// in JSON-encoded results have <, >, and & escaped as \u003c, \u003e,
+// and \u0026, as by json.Encoder.SetEscapeHTML, so that the JSON can
+// be embedded in HTML. Escaping is enabled by default. It also applies
+// to the lines of NDJSON streams and the data of server-sent events,
+// but not to error responses. With WithJSONEngine, escaping is left to
+// the engine's configuration.
+func WithJSONEscapeHTML(enabled bool) Option {
+	return func(o *options) {
+		o.jsonRawHTML = !enabled
+	}
+}
+
+// WithJSONTrailingNewline returns an Option that controls whether
+// JSON-encoded results end with a newline, as json.Encoder writes
+// them. The newline is written by default. Error responses are not
+// affected.
+func WithJSONTrailingNewline(enabled bool) Option {
+	return func(o *options) {
+		o.jsonNoNewline = !enabled
+	}
+}
+
+// WithJSONEngine returns an Option that sets the implementation of
+// JSON used in place of encoding/json to encode results, including
+// the lines of NDJSON streams and the data of server-sent events, and
+// to decode request bodies. For example,
+//
+//	structhttp.WithJSONEngine(jsoniter.ConfigCompatibleWithStandardLibrary)
+//
+// Results are indented for WithJSONIndent by json.Indent. Request
+// bodies are read whole and unmarshalled, so data following the JSON
+// value is rejected. With WithStrictJSON, request bodies are still
+// decoded by encoding/json, which reports unknown properties, and
+// error responses are always encoded by the ErrorEncoder.
+func WithJSONEngine(e JSONEngine) Option {
+	return func(o *options) {
+		o.jsonEngine = e
+	}
+}
+
+// WithStringAsPlainText returns an Option that controls whether string
+// results are written verbatim with a Content-Type of text/plain
+// rather than encoded as JSON strings. Results of type PlainText are
+// always written as text.
+func WithStringAsPlainText(enabled bool) Option {
+	return func(o *options) {
+		o.stringAsText = enabled
+	}
+}
+
+// WithStringerAsText returns an Option that controls whether results
+// implementing fmt.Stringer are written as text/plain using their
+// String method rather than encoded as JSON. Nil results, including
+// nil pointers whose type implements fmt.Stringer, are still encoded
+// as JSON null.
+func WithStringerAsText(enabled bool) Option {
+	return func(o *options) {
+		o.stringerAsText = enabled
+	}
+}
+
+// WithNilResultStatus returns an Option that sets the status code
+// written when a method returning (T, bool, error) reports an absent
+// value with a false bool and nil error. The default is 404. Error
+// status codes are written as errors using the configured
+// ErrorEncoder; other status codes are written without a body.
+func WithNilResultStatus(code int) Option {
+	return func(o *options) {
+		o.absentStatus = code
+	}
+}
+
+// WithErrorEncoder returns an Option that sets the ErrorEncoder used
+// to write error responses. The default is JSONErrorEncoder. Once an
+// ErrorEncoder is configured, it also writes the 404 responses to
+// requests that match no method, unless WithNotFoundHandler is used.
+func WithErrorEncoder(e ErrorEncoder) Option {
+	return func(o *options) {
+		o.errorEncoder = e
+		o.encodeNotFound = true
+	}
+}
+
+// WithNotFoundHandler returns an Option that sets the handler for
+// requests that match no method. It takes precedence over an
+// ErrorEncoder configured with WithErrorEncoder, but is not used with
+// WithFallbackHandler. By default, such requests are answered with
+// http.NotFound.
+func WithNotFoundHandler(h http.Handler) Option {
+	return func(o *options) {
+		o.notFoundHandler = h
+	}
+}
+
+// WithFallbackHandler returns an Option that sets a handler to serve
+// every request that matches no method, such as a file server or an
+// existing API whose endpoints are being moved to the struct. Unlike
+// the handler set with WithNotFoundHandler, it also receives requests
+// whose path matches a method with a different HTTP method, which
+// would otherwise receive a 405 response, and OPTIONS requests that
+// would otherwise be answered automatically. CORS preflight requests
+// and automatic HEAD requests are still handled as described for
+// WithCORS and WithAutomaticHEAD. The request body is passed on
+// unread unless a MatcherFunc read it.
+func WithFallbackHandler(h http.Handler) Option {
+	return func(o *options) {
+		o.fallback = h
+	}
+}
+
+// WithArgProvider returns an Option that registers a provider for
+// method arguments of the given type. Arguments of that type are not
+// passed to the MatcherFunc; instead, the provider is called with the
+// request to supply the value. An error returned by the provider is
+// written as the response, with its status code resolved as for
+// errors returned by methods.
+//
+// Providers are useful for values placed in the request context by
+// middleware, such as an authenticated user.
+func WithArgProvider(argType reflect.Type, provider ArgProviderFunc) Option {
+	return WithInjectable(argType, func(r *http.Request) (reflect.Value, error) {
+		v, err := provider(r)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		return argValue(v, argType), nil
+	})
+}
+
+// WithProvider returns an Option that registers provider for method
+// arguments of type T, as with WithArgProvider, such as
+//
+//	structhttp.WithProvider(func(r *http.Request) (*CurrentUser, error) {
+//		return auth.UserFromContext(r.Context())
+//	})
+//
+// so that methods can take the current user, tenant, or transaction
+// as an argument instead of each looking it up from the request.
+func WithProvider[T any](provider func(r *http.Request) (T, error)) Option {
+	return WithInjectable(reflect.TypeOf((*T)(nil)).Elem(), func(r *http.Request) (reflect.Value, error) {
+		v, err := provider(r)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		return reflect.ValueOf(&v).Elem(), nil
+	})
+}
+
+// WithInjectable returns an Option that makes method arguments of the
+// given type injectable, like context.Context and *http.Request. Such
+// arguments are not passed to the MatcherFunc; instead, inject is
+// called with the request to supply the value, which must be
+// assignable to argType. An error returned by inject is written as the
+// response. Methods whose arguments are all injectable match requests
+// like methods without arguments.
+//
+// WithInjectable is the reflect-based form of WithArgProvider, useful
+// for values such as a *sql.Tx or logger scoped to the request.
+func WithInjectable(argType reflect.Type, inject InjectorFunc) Option {
+	return func(o *options) {
+		if o.providers == nil {
+			o.providers = make(map[reflect.Type]InjectorFunc)
+		}
+		o.providers[argType] = inject
+	}
+}
+
+// WithPreflightMethodDiscovery returns an Option that controls whether
+// Handler answers OPTIONS requests that match no method with a 204
+// response carrying an Allow header. The header lists the HTTP
+// methods of the routes of the methods whose paths match, as served
+// by DefaultMatcherFunc and QueryMatcherFunc, and, with
+// WithMatcherProbing, those for which other matchers match. For
+// "OPTIONS *", it lists the HTTP methods of all routes. Paths
+// accepting no methods still receive a 404 response, and a method
+// whose route accepts OPTIONS, such as one tagged "OPTIONS /path", is
+// called instead. It is enabled by default; when disabled, such
+// requests receive a 405 response.
+func WithPreflightMethodDiscovery(enabled bool) Option {
+	return func(o *options) {
+		o.discoverMethods = enabled
+	}
+}
+
+// WithMatcherProbing returns an Option that controls whether matchers
+// other than DefaultMatcherFunc and QueryMatcherFunc are consulted to
+// find the HTTP methods accepted for a path, for 405 responses, OPTIONS
+// responses, CORS preflight requests, and trailing slash redirects.
+// Such matchers are probed with a copy of the request, with an empty
+// body, for each of GET, HEAD, POST, PUT, PATCH, and DELETE and each
+// method, so they must be cheap and free of side effects. Probing is
+// disabled by default, in which case custom matchers contribute no
+// HTTP methods.
+func WithMatcherProbing(enabled bool) Option {
+	return func(o *options) {
+		o.probeMatchers = enabled
+	}
+}
+
+// WithAutomaticHEAD returns an Option that controls whether Handler
+// serves HEAD requests that match no method as if they were GET
+// requests, writing the response headers, including Content-Length,
+// without the body. It is enabled by default; when disabled, such
+// requests receive a 405 response if the path accepts GET.
+func WithAutomaticHEAD(enabled bool) Option {
+	return func(o *options) {
+		o.autoHead = enabled
+	}
+}
+
+// WithSkipHEADCall returns an Option that controls whether automatic
+// HEAD requests, served as described for WithAutomaticHEAD, skip
+// calling the method. The arguments are still decoded and checked by
+// any PreInvokeFunc, but the response carries only the success status
+// and any content type set with WithMethodContentType, without a
+// Content-Length. This avoids the cost of methods that are expensive
+// or have side effects.
+func WithSkipHEADCall(enabled bool) Option {
+	return func(o *options) {
+		o.skipHead = enabled
+	}
+}
+
+// WithAutomaticHEADAndOPTIONS returns an Option that controls both
+// automatic HEAD handling, as with WithAutomaticHEAD, and automatic
+// OPTIONS handling, as with WithPreflightMethodDiscovery. When both
+// are enabled, as they are by default, the Allow header lists HEAD
+// wherever GET is allowed.
+func WithAutomaticHEADAndOPTIONS(enabled bool) Option {
+	return func(o *options) {
+		o.autoHead = enabled
+		o.discoverMethods = enabled
+	}
+}
+
+// WithCORS returns an Option that enables cross-origin resource
+// sharing. Responses to requests from an allowed origin carry the
+// Access-Control-Allow-Origin header. Preflight requests, OPTIONS
+// requests with an Access-Control-Request-Method header, are answered
+// with a 204 response whose Access-Control-Allow-Methods header lists
+// the HTTP methods the matcher accepts for the path, discovered as
+// with WithPreflightMethodDiscovery. Preflight requests for paths no
+// method accepts, or for which a method's route accepts OPTIONS, are
+// handled like any other request. With AllowCredentials, an
+// AllowedOrigins entry of "*" allows no origin and is reported as a
+// problem by Handler and NewHandler.
+func WithCORS(c CORSOptions) Option {
+	return func(o *options) {
+		o.cors = &c
+	}
+}
+
+// WithLogger returns an Option that sets the logger used by Handler.
+// Matching decisions and served requests are logged at debug level;
+// matcher errors, panics, and response encoding failures are logged at
+// error level. Log records include the method name, request path,
+// and, once the response is written, its status code. By default,
+// nothing is logged.
+func WithLogger(logger *slog.Logger) Option {
+	return func(o *options) {
+		o.logger = logger
+	}
+}
+
+// WithContextBaggage returns an Option that controls whether Handler
+// parses the W3C baggage header of each request and attaches it to
+// the request context, where methods, matchers, and argument
+// providers can read it with BaggageFromContext.
+func WithContextBaggage(enabled bool) Option {
+	return func(o *options) {
+		o.baggage = enabled
+	}
+}
+
+// WithSSE returns an Option that controls whether methods returning a
+// receive-capable channel, such as
+//
+//	func (a *App) Events(ctx context.Context) (<-chan Event, error)
+//
+// are served as a server-sent event stream. Each value received from
+// the channel is written as a "data:" frame and flushed, with strings
+// written verbatim and other values encoded as JSON. The stream ends
+// when the channel is closed or the client disconnects. After a
+// disconnect, Handler drains the channel in the background until it
+// is closed, so that a method sending on it does not block forever;
+// the method should still stop sending and close the channel once its
+// context is done.
+func WithSSE(enabled bool) Option {
+	return func(o *options) {
+		o.sse = enabled
+	}
+}
+
+// WithNDJSON returns an Option that controls whether results that are
+// slices, arrays, or receive-capable channels are streamed as
+// newline-delimited JSON, one element per line, to requests whose
+// Accept header names application/x-ndjson and prefers it at least as
+// much as the types of the other codecs, rather than encoded as a
+// whole. Lines of values received from a channel are flushed as they
+// are written, and those of a slice every 1,000 lines, so that large
+// exports are neither buffered nor held back. A channel's stream ends
+// as described for WithSSE, which applies to requests that do not ask
+// for NDJSON. A limit set with WithMaxResponseBytes ends the stream
+// before the line that would exceed it.
+func WithNDJSON(enabled bool) Option {
+	return func(o *options) {
+		o.ndjson = enabled
+	}
+}
+
+// WithSuccessStatus returns an Option that sets the status code of
+// successful responses for the named methods, replacing the default
+// of 200 for methods that write a body and 204 for those that do not.
+// For example, {"Create": 201} makes Create respond with 201 Created.
+// No body is written if the configured status code does not permit
+// one, such as 204.
+func WithSuccessStatus(statuses map[string]int) Option {
+	return func(o *options) {
+		if o.successStatus == nil {
+			o.successStatus = make(map[string]int)
+		}
+		for name, code := range statuses {
+			o.successStatus[name] = code
+		}
+	}
+}
+
+// WithParamNames returns an Option that registers the names of the
+// parameters of the named methods, which reflection cannot provide, in
+// the order they are declared, excluding the receiver. For example,
+//
+//	WithParamNames(map[string][]string{"Transfer": {"ctx", "from", "to"}})
+//
+// names the parameters of Transfer(ctx context.Context, from, to
+// string). Matchers receive the names of the non-injected arguments
+// as Method.ArgNames. Naming a method the struct does not have, or
+// giving the wrong number of names, is reported as by Handler.
+func WithParamNames(names map[string][]string) Option {
+	return func(o *options) {
+		if o.paramNames == nil {
+			o.paramNames = make(map[string][]string)
+		}
+		for name, params := range names {
+			o.paramNames[name] = params
+		}
+	}
+}
+
+// WithRoutePriority returns an Option that sets the priority of the
+// named methods in dispatch order. Methods are tried by priority,
+// then with more specific routes first, so that GET /users/me is
+// tried before GET /users/{id}, then in order of name, and a request
+// is served by the first method a matcher accepts. The default
+// priority is 0. For example, {"GetMe": 1} tries GetMe before methods
+// with overlapping routes.
+func WithRoutePriority(priorities map[string]int) Option {
+	return func(o *options) {
+		if o.priorities == nil {
+			o.priorities = make(map[string]int)
+		}
+		for name, priority := range priorities {
+			o.priorities[name] = priority
+		}
+	}
+}
+
+// WithMaxBodyBytes returns an Option that limits the size of request
+// bodies to n bytes. Requests whose body exceeds the limit while it is
+// decoded, including multipart forms, receive a 413 response. A limit
+// of zero, the default, means no limit.
+func WithMaxBodyBytes(n int64) Option {
+	return func(o *options) {
+		o.maxBodyBytes = n
+	}
+}
+
+// WithRequestDecompression returns an Option that controls whether
+// Handler decompresses request bodies with a Content-Encoding of gzip
+// or deflate before they are decoded or passed to methods, as sent by
+// clients compressing large payloads. Bodies with other encodings are
+// left as they are. A body that fails to decompress results in a 400
+// response. WithMaxBodyBytes limits the size of the compressed body;
+// WithMaxDecompressedBytes limits its size once decompressed.
+func WithRequestDecompression(enabled bool) Option {
+	return func(o *options) {
+		o.decompress = enabled
+	}
+}
+
+// WithMaxDecompressedBytes returns an Option that limits the size of
+// request bodies decompressed as described for
+// WithRequestDecompression to n bytes. Requests whose body exceeds the
+// limit once decompressed receive a 413 response. A limit of zero, the
+// default, means no limit.
+func WithMaxDecompressedBytes(n int64) Option {
+	return func(o *options) {
+		o.maxInflateBytes = n
+	}
+}
+
+// WithMaxMultipartParts returns an Option that limits the number of
+// parts, both files and other values, of a multipart/form-data body.
+// The body is then read part by part, and a request receives a 400
+// response as soon as it sends more than n parts, before the rest of
+// the body is read. A limit of zero, the default, means no limit
+// beyond that of the mime/multipart package, 1,000 parts.
+func WithMaxMultipartParts(n int) Option {
+	return func(o *options) {
+		o.maxParts = n
+	}
+}
+
+// WithMaxFileBytes returns an Option that limits the size of each file
+// uploaded in a multipart/form-data body to n bytes. The body is then
+// read part by part, and a request receives a 413 response as soon as
+// a file passes the limit, before the rest of the file or body is
+// read or stored. The size of the whole body can be limited with
+// WithMaxBodyBytes. A limit of zero, the default, means no limit.
+func WithMaxFileBytes(n int64) Option {
+	return func(o *options) {
+		o.maxFileBytes = n
+	}
+}
+
+// WithMaxResponseBytes returns an Option that limits the size of
+// response bodies to n bytes. A result whose encoded body exceeds the
+// limit is replaced, before anything is written, with a 500 response.
+// A result streamed from an io.Reader cannot be checked in advance; it
+// is instead truncated to n bytes. A limit of zero, the default, means
+// no limit.
+func WithMaxResponseBytes(n int64) Option {
+	return func(o *options) {
+		o.maxRespBytes = n
+	}
+}
+
+// WithObserver returns an Option that sets a function called after
+// each request is served with the method name, status code, request
+// and response body sizes, and duration, for use in metrics.
+func WithObserver(f ObserverFunc) Option {
+	return func(o *options) {
+		o.observer = f
+	}
+}
+
+// WithIdempotency returns an Option that deduplicates POST requests
+// carrying an Idempotency-Key header. Keys are scoped to the caller
+// that scope returns for each request, such as the authenticated user,
+// so that a key reused or guessed by another caller never replays a
+// response meant for someone else; scope is required, and requests for
+// which it returns "" are not deduplicated. The first response to a
+// request with a given key, caller, and method, unless it is a server
+// error or its body exceeds 1 MiB, is kept in store for the duration
+// of ttl and replayed, with an Idempotent-Replayed header, in answer
+// to later requests with the same key from the same caller.
+// Concurrent requests with the same key and caller are served one at
+// a time, so only one of them calls the method, but only within one
+// process: the lock is held in memory, not in store. When replicas
+// share a store, concurrent duplicates that reach different replicas
+// may each call the method.
+func WithIdempotency(store IdempotencyStore, ttl time.Duration, scope IdempotencyScopeFunc) Option {
+	return func(o *options) {
+		o.idempotency = &idempotency{
+			store: store,
+			ttl:   ttl,
+			scope: scope,
+			locks: make(map[string]*keyLock),
+		}
+	}
+}
+
+// WithBodyKey returns an Option that sets, per method name, the key
+// of the top-level JSON object property holding the method's
+// argument. For example, with {"Create": "data"}, DefaultMatcherFunc
+// decodes the argument of Create from the "data" property of a body
+// like {"data": {...}}. A body without the key results in a 400
+// response.
+func WithBodyKey(keys map[string]string) Option {
+	return func(o *options) {
+		if o.bodyKeys == nil {
+			o.bodyKeys = make(map[string]string)
+		}
+		for name, key := range keys {
+			o.bodyKeys[name] = key
+		}
+	}
+}
+
+// WithResponseEncoder returns an Option that registers enc to encode
+// method results as contentType, such as "application/xml" or
+// "application/x-protobuf". Each response is encoded with the
+// registered encoder whose media type the request's Accept header
+// prefers, with the response's Content-Type set to contentType. JSON
+// remains the default, used when the Accept header is absent or
+// accepts none of the registered types, and is preferred to other
+// types the header accepts equally and only by wildcard, as with
+// "*/*". An encoder returning errors.ErrUnsupported declines the
+// result, which is then encoded as JSON, as protobuf.ResponseEncoder,
+// in the protobuf subpackage, declines results that are not a
+// proto.Message.
+//
+// Registering an encoder for application/json replaces the built-in
+// one, and registering another for a media type replaces the earlier
+// one. Results written as is, such as byte slices, streams, and
+// results of methods given a content type with WithMethodContentType,
+// are not encoded. To take over writing those responses entirely,
+// rather than add a format, use WithResponseEncoderFunc.
+func WithResponseEncoder(contentType string, enc ResponseEncoder) Option {
+	return func(o *options) {
+		c := newCodec(contentType, enc)
+		for i := range o.codecs {
+			if o.codecs[i].mediaType == c.mediaType {
+				o.codecs[i] = c
+				return
+			}
+		}
+		o.codecs = append(o.codecs, c)
+	}
+}
+
+// WithResponseEncoderFunc returns an Option that sets a function to
+// write the responses for results that would otherwise be encoded,
+// replacing JSON and any encoders registered with WithResponseEncoder,
+// much as WithMatcherFunc replaces the default matching. The function
+// is responsible for the whole response, including its headers and
+// status code, so it can wrap results in an envelope or set headers
+// derived from them:
+//
+//	structhttp.WithResponseEncoderFunc(func(w http.ResponseWriter, r *http.Request, result any) error {
+//		w.Header().Set("Content-Type", "application/json")
+//		return json.NewEncoder(w).Encode(map[string]any{"data": result})
+//	})
+//
+// Results written as is, such as byte slices, streams, and results of
+// methods given a content type with WithMethodContentType, are not
+// passed to the function, and errors returned by methods are still
+// written with the ErrorEncoder. If the function returns an error
+// before writing anything, a 500 response is written instead. As with
+// methods taking an http.ResponseWriter, the limit set by
+// WithMaxResponseBytes and statuses set by WithSuccessStatus do not
+// apply.
+//
+// The option is named for the ResponseEncoderFunc type it takes, as
+// WithMatcherFunc is for MatcherFunc; WithResponseEncoder, which takes
+// a ResponseEncoder, instead adds an encoder for one content type to
+// those chosen between by the Accept header.
+func WithResponseEncoderFunc(f ResponseEncoderFunc) Option {
+	return func(o *options) {
+		o.encodeFunc = f
+	}
+}
+
+// WithRequestDecoder returns an Option that registers dec to decode
+// request bodies whose Content-Type has the media type of contentType,
+// such as "application/x-protobuf", into method arguments, in place
+// of DefaultMatcherFunc's built-in decoding. dec is given a pointer to
+// the argument, or the argument itself, newly allocated, if it is a
+// pointer. A decoder returning errors.ErrUnsupported declines the
+// argument, which results in a 415 response, as
+// protobuf.RequestDecoder, in the protobuf subpackage, declines
+// arguments that are not a proto.Message.
+//
+// Together with WithResponseEncoder, this lets the same methods serve
+// JSON to browsers and other formats to clients that ask for them;
+// WithCodecs registers both for a media type at once.
+func WithRequestDecoder(contentType string, dec RequestDecoder) Option {
+	return func(o *options) {
+		if o.decoders == nil {
+			o.decoders = make(map[string]RequestDecoder)
+		}
+		o.decoders[newCodec(contentType, nil).mediaType] = dec
+	}
+}
+
+// WithCodecs returns an Option that registers each of codecs both to
+// encode responses, as with WithResponseEncoder, and to decode request
+// bodies, as with WithRequestDecoder, for its media type:
+//
+//	structhttp.WithCodecs(msgpack.Codec, cbor.Codec)
+//
+// The msgpack, cbor, yaml, and protobuf subpackages provide Codecs for
+// those formats. A Codec for application/json replaces the built-in
+// JSON encoding and decoding, and a later Codec for a media type
+// replaces an earlier one.
+func WithCodecs(codecs ...Codec) Option {
+	return func(o *options) {
+		for _, c := range codecs {
+			WithResponseEncoder(c.MediaType(), c.Encode)(o)
+			WithRequestDecoder(c.MediaType(), c.Decode)(o)
+		}
+	}
+}
+
+// WithXML returns an Option that controls whether Handler speaks XML
+// as well as JSON. DefaultMatcherFunc then decodes bodies with a
+// Content-Type of application/xml, text/xml, or a +xml type into the
+// method's argument with encoding/xml, and results are encoded with
+// XMLResponseEncoder for requests whose Accept header prefers
+// application/xml, as if registered with WithResponseEncoder. Another
+// encoder registered for application/xml takes precedence.
+// WithBodyKey and WithStrictJSON do not apply to XML bodies.
+func WithXML(enabled bool) Option {
+	return func(o *options) {
+		o.xml = enabled
+	}
+}
+
+// WithStrictJSON returns an Option that controls whether
+// DefaultMatcherFunc rejects JSON request bodies with properties that
+// match no field of the method's argument, or with data following the
+// JSON value, so that misspelled properties are reported rather than
+// ignored. Such bodies result in a 400 response naming the unknown
+// property. With WithBodyKey, other properties of the enclosing object
+// are still allowed.
+func WithStrictJSON(enabled bool) Option {
+	return func(o *options) {
+		o.strictJSON = enabled
+	}
+}
+
+// WithRequiredContentTypes returns an Option that makes
+// DefaultMatcherFunc respond 415 Unsupported Media Type to POST, PUT,
+// and PATCH requests with a body whose Content-Type is not one of
+// types, rather than attempting to decode it. With no types, only
+// application/json is accepted. A type of the form "text/*" accepts
+// any subtype, "*/*" accepts any type, and application/json also
+// accepts JSON-based types such as application/merge-patch+json.
+//
+// A method can accept other types with a `consumes` tag listing them,
+// as in
+//
+//	Routes struct {
+//		Import string `route:"POST /import" consumes:"text/csv,application/json"`
+//	}
+//
+// which applies whether or not the option is given. An empty tag
+// exempts the method, accepting any type.
+func WithRequiredContentTypes(types ...string) Option {
+	return func(o *options) {
+		if len(types) == 0 {
+			types = []string{"application/json"}
+		}
+		o.requestTypes = types
+	}
+}
+
+// WithMethodContentType returns an Option that sets, per method name,
+// the Content-Type of successful responses with a body. If the
+// content type is not JSON, the method's result is written as is
+// rather than encoded as JSON: strings and byte slices verbatim, and
+// other values in their default fmt formatting. For example,
+// {"Page": "text/html; charset=utf-8"} serves the HTML string
+// returned by Page. Error responses are unaffected.
+func WithMethodContentType(contentTypes map[string]string) Option {
+	return func(o *options) {
+		if o.contentTypes == nil {
+			o.contentTypes = make(map[string]string)
+		}
+		for name, contentType := range contentTypes {
+			o.contentTypes[name] = contentType
+		}
+	}
+}
+
+// WithContextFunc returns an Option that adds a ContextFunc used to
+// derive the context of matched requests. The derived context is
+// passed to methods accepting a context.Context and is the context of
+// the *http.Request given to methods and argument providers. Multiple
+// ContextFuncs are applied in the order they are registered, each
+// receiving the context returned by the previous one.
+func WithContextFunc(f ContextFunc) Option {
+	return func(o *options) {
+		o.contextFuncs = append(o.contextFuncs, f)
+	}
+}
+
+// WithPreInvoke returns an Option that adds a PreInvokeFunc called
+// after a method's arguments are assembled and before it is invoked,
+// for authorization decisions that depend on the method or its
+// decoded arguments. The args are the method's arguments in order,
+// including injected ones such as context.Context. If f returns an
+// error, the method is not called and the error is written as the
+// response, with a 403 status code unless the error implements
+// HTTPStatusCoder. Multiple PreInvokeFuncs are called in the order
+// they are registered until one returns an error.
+func WithPreInvoke(f PreInvokeFunc) Option {
+	return func(o *options) {
+		o.preInvokes = append(o.preInvokes, f)
+	}
+}
+
+// WithBaseContext returns an Option that ties the context of each
+// request to ctx: when ctx is cancelled, the contexts passed to
+// methods, matchers, and argument providers are cancelled too. Values
+// are still looked up in the request context. Passing the context
+// that http.Server.BaseContext returns, and cancelling it when the
+// server begins shutting down, lets in-flight methods observe the
+// server draining.
+func WithBaseContext(ctx context.Context) Option {
+	return func(o *options) {
+		o.baseCtx = ctx
+	}
+}
+
+// WithMaxQueryParams returns an Option that limits the number of query
+// parameters a request may carry. Requests with more than n
+// parameters are rejected with a 400 response before any matching or
+// argument binding takes place. A limit of zero, the default, means no
+// limit.
+func WithMaxQueryParams(n int) Option {
+	return func(o *options) {
+		o.maxQueryParams = n
+	}
+}
+
+// WithTimeFormats returns an Option that sets the layouts, as for
+// time.Parse, in which time.Time values are parsed from path
+// variables, query parameters, headers, cookies, and form values.
+// Each value is parsed in the first layout that accepts it, and
+// TimeFormatUnix accepts seconds since the Unix epoch. For example,
+//
+//	WithTimeFormats(time.RFC3339, time.DateOnly, structhttp.TimeFormatUnix)
+//
+// accepts 2024-01-02T03:04:05Z, 2024-01-02, and 1704164645. By default,
+// times are parsed as RFC 3339. Times in JSON bodies are unaffected.
+// A time.Duration is always parsed as by time.ParseDuration, as in
+// "1h30m".
+func WithTimeFormats(layouts ...string) Option {
+	return func(o *options) {
+		o.timeFormats = layouts
+	}
+}
+
+// WithTypeConverter returns an Option that registers fn to parse
+// values of type t from path variables, query parameters, headers,
+// cookies, and form values, such as UUIDs, decimals, or enums. It
+// takes precedence over a converter registered for t with
+// RegisterTypeConverter and over the type's own parsing, such as by
+// UnmarshalText. A slice of t collects repeated values, each parsed
+// by fn.
+func WithTypeConverter(t reflect.Type, fn TypeConverterFunc) Option {
+	return func(o *options) {
+		if o.converters == nil {
+			o.converters = make(map[reflect.Type]TypeConverterFunc)
+		}
+		o.converters[t] = fn
+	}
+}
+
+// exposes reports whether the named method is exposed under the
+// filters set with WithMethods and WithExcludeMethods.
+func (o *options) exposes(methodName string) bool {
+	if o.exclude[methodName] {
+		return false
+	}
+	return o.include == nil || o.include[methodName]
+}
+
+// resourceNaming returns the naming strategy for resource names in
+// paths derived by WithRESTRouting.
+func (o *options) resourceNaming() func(string) string {
+	if o.naming != nil {
+		return o.naming
+	}
+	return KebabCase
+}
+
+// derivedEndpoint returns the endpoint of a method from its route tag,
+// WithRESTRouting, or WithNamingStrategy, in that order of precedence,
+// or else its default endpoint and false.
+func (o *options) derivedEndpoint(methodName string, tagged map[string]endpoint) (endpoint, bool) {
+	if e, ok := tagged[methodName]; ok {
+		return e, true
+	}
+	if o.restRouting {
+		if e, ok := restEndpoint(methodName, o.resourceNaming()); ok {
+			return e, true
+		}
+	}
+	if o.naming != nil {
+		return newEndpoint(http.MethodPost, "/"+o.naming(methodName)), true
+	}
+	return rpcEndpoint(methodName), false
+}
+
+// setEndpoint sets the endpoint of the named method.
+func (o *options) setEndpoint(methodName string, e endpoint) {
+	if o.endpoints == nil {
+		o.endpoints = make(map[string]endpoint)
 	}
+	o.endpoints[methodName] = e
 }
 
+// setConsumes sets the request content types accepted by the named
+// method from the comma-separated list of a `consumes` tag.
+func (o *options) setConsumes(methodName, list string) {
+	if o.consumes == nil {
+		o.consumes = make(map[string][]string)
+	}
+	var types []string
+	for _, t := range strings.Split(list, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			types = append(types, t)
+		}
+	}
+	o.consumes[methodName] = types
+}
+
+// endpoint returns the endpoint of the named method: the one derived
+// when the Handler was built, or else POST /MethodName.
+func (o *options) endpoint(methodName string) endpoint {
+	if e, ok := o.endpoints[methodName]; ok {
+		return e
+	}
+	return rpcEndpoint(methodName)
+}
+
+// optionsFromContext returns the options of the Handler serving the
+// request with the given context, or the zero options if none.
+func optionsFromContext(ctx context.Context) *options {
+	if o, ok := ctx.Value(optionsKey{}).(*options); ok {
+		return o
+	}
+	return &options{}
+}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (h discardHandler) WithAttrs([]slog.Attr) slog.Handler      { return h }
+func (h discardHandler) WithGroup(string) slog.Handler           { return h }
+
 // DefaultMatcherFunc is the default MatcherFunc for Handler.
+//
+// It matches POST requests to /MethodName (or MethodName, unless
+// WithStrictPaths is enabled), or, within a Handler, requests to the
+// endpoint derived for the method by options such as WithRESTRouting.
+// For POST, PUT, and PATCH requests, it decodes the request body as
+// JSON into the method's single argument, if any, or as XML if the
+// body is XML and WithXML is enabled, or with the decoder registered
+// for its Content-Type with WithRequestDecoder. Path variables are
+// then bound to the argument as described for WithRESTRouting, fields
+// of a struct argument are overridden by the query parameters, named
+// as for QueryMatcherFunc, then by the request cookies for fields
+// tagged `cookie:"name"`, and finally by the request headers for
+// fields tagged `header:"Name"`. A value provided in more than one
+// way is therefore taken from the headers, then the cookies, then the
+// query, then the path, then the body. A header or cookie tag with the
+// ",required" option, as in `header:"X-Request-ID,required"`, results
+// in a 400 response when the header or cookie is missing; otherwise
+// missing ones leave the field as decoded from the body. A field
+// tagged `query:"name,required"` is satisfied by either the query or
+// the body.
+//
+// Fields no source provides keep their zero values, or the values
+// given by `default` tags, as in `default:"50"`; a slice's default is
+// a comma-separated list. A field tagged `required:"true"` that is
+// still zero once every source is bound results in a 400 response
+// with a MissingFieldsError naming it; a pointer field lets a required
+// value be zero. Values from the path, query, cookies, and headers are
+// parsed according to the field's type, or by implementing
+// encoding.TextUnmarshaler or flag.Value, or by a converter given with
+// WithTypeConverter or RegisterTypeConverter.
+//
+// An empty JSON body leaves a pointer argument, such as *Options, nil,
+// so that methods can accept an optional body, unless the path, query,
+// cookies, or headers set any of its fields. An empty body is
+// otherwise an error, as is a malformed one. For a variadic method,
+// such as Sum(nums ...int), a JSON array supplies the variadic
+// arguments.
+//
+// A multipart/form-data body is parsed as a form instead. An argument
+// of type multipart.File, io.Reader, []byte, or *multipart.FileHeader
+// receives the first uploaded file, opened or read in full as its
+// type requires; an opened file is closed after the method returns.
+// For a struct argument, fields tagged `file:"name"` of those types
+// receive the first file uploaded under that name, fields of type
+// []*multipart.FileHeader receive all of them, and other fields are
+// set from the form values named by their `form` tag, `json` tag, or
+// field name. An application/x-www-form-urlencoded body, as sent by
+// HTML forms, is bound to the fields of a struct argument in the same
+// way. A malformed form results in a 400 response. The size of forms
+// can be limited with WithMaxMultipartParts and WithMaxFileBytes.
+//
+// A final argument of type io.Reader or io.ReadCloser receives the
+// request body itself, unread, so that the method can stream it. The
+// body is then not decoded, and any other argument is bound from the
+// path, query, cookies, and headers alone. A multipart form is still
+// parsed when such an argument is the method's only one, which
+// receives the first uploaded file as described above.
+//
+// The values of path variables are also set on the request, where
+// they can be read with its PathValue method.
+//
+// Within a Handler, DefaultMatcherFunc honors the options that affect
+// decoding, such as WithBodyKey.
 func DefaultMatcherFunc(r *http.Request, methodName string, methodArgs ...reflect.Type) ([]any, bool, error) {
-	if r.Method != "POST" || (r.URL.Path != "/"+methodName && r.URL.Path != methodName) {
+	o := optionsFromContext(r.Context())
+	e := o.endpoint(methodName)
+	if r.Method != e.verb {
 		return nil, false, nil
 	}
+	var params map[string]string
+	if o.pathParam != nil {
+		params = e.pathValues(r, o.pathParam)
+	} else {
+		var ok bool
+		if params, ok = e.matchPath(r.URL.Path, o.strictPaths, o.foldPaths); !ok {
+			return nil, false, nil
+		}
+		setPathValues(r, params)
+	}
 
 	if len(methodArgs) == 0 {
 		return nil, true, nil
 	}
+	if err := o.checkContentType(r, methodName); err != nil {
+		return nil, true, err
+	}
+
+	var body []any
+	if n := len(methodArgs); isBodyReader(methodArgs[n-1]) && !(n == 1 && isMultipart(r)) {
+		body, methodArgs = []any{r.Body}, methodArgs[:n-1]
+		if len(methodArgs) == 0 {
+			return body, true, nil
+		}
+	}
 
 	if len(methodArgs) > 1 {
-		return nil, false, nil
+		if !o.positional(e, methodArgs) {
+			return nil, false, nil
+		}
+		args, err := o.bindPositional(e, params, methodArgs)
+		if err != nil {
+			return nil, true, NewError(http.StatusBadRequest, err)
+		}
+		return append(args, body...), true, nil
 	}
 
 	argType := methodArgs[0]
 	arg := reflect.New(argType)
-	if err := json.NewDecoder(r.Body).Decode(arg.Interface()); err != nil {
-		return nil, true, NewError(http.StatusBadRequest, fmt.Errorf("failed to decode request body: %w", err))
+	if err := o.applyDefaults(arg.Elem()); err != nil {
+		return nil, true, err
+	}
+	empty := false
+	if hasBody(r.Method) && body == nil {
+		var err error
+		switch {
+		case isMultipart(r):
+			err = o.decodeMultipart(r, arg)
+		case isForm(r):
+			err = o.decodeForm(r, arg)
+		default:
+			if dec, ok := o.decoder(r); ok {
+				err = decodeWith(dec, r, arg)
+			} else if o.xml && isXML(r) {
+				err = decodeXML(r, arg.Interface())
+			} else {
+				err = o.decodeBody(r, o.bodyKeys[methodName], arg.Interface())
+			}
+			if errors.Is(err, io.EOF) && argType.Kind() == reflect.Pointer {
+				err, empty = nil, true
+			}
+		}
+		if err != nil {
+			return nil, true, bodyError(err)
+		}
+	}
+	if err := o.bindPath(arg.Elem(), params); err != nil {
+		return nil, true, NewError(http.StatusBadRequest, err)
+	}
+	if r.URL.RawQuery != "" {
+		if err := o.bindQuery(arg.Elem(), r.URL.Query()); err != nil {
+			return nil, true, NewError(http.StatusBadRequest, err)
+		}
+	}
+	if err := o.bindCookies(arg.Elem(), r); err != nil {
+		return nil, true, NewError(http.StatusBadRequest, err)
+	}
+	if err := o.bindHeaders(arg.Elem(), r.Header); err != nil {
+		return nil, true, NewError(http.StatusBadRequest, err)
+	}
+	if empty && o.isDefault(arg.Elem()) {
+		return append([]any{reflect.Zero(argType).Interface()}, body...), true, nil
+	}
+	if err := o.checkRequired(arg.Elem()); err != nil {
+		return nil, true, err
+	}
+	return append([]any{arg.Elem().Interface()}, body...), true, nil
+}
+
+// hasBody reports whether requests with the given HTTP method carry
+// a body decoded into the method's argument.
+func hasBody(verb string) bool {
+	switch verb {
+	case http.MethodPost, http.MethodPut, http.MethodPatch:
+		return true
+	}
+	return false
+}
+
+// checkContentType returns a 415 error if the request has a body whose
+// Content-Type the named method does not accept, as configured by
+// WithRequiredContentTypes and `consumes` tags.
+func (o *options) checkContentType(r *http.Request, methodName string) error {
+	types, ok := o.consumes[methodName]
+	if !ok {
+		types = o.requestTypes
+	}
+	if len(types) == 0 || !hasBody(r.Method) || r.ContentLength == 0 {
+		return nil
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	for _, t := range types {
+		if matchMediaType(mediaType, t) {
+			return nil
+		}
+	}
+	expected := strings.Join(types, ", ")
+	if mediaType == "" {
+		return NewError(http.StatusUnsupportedMediaType, fmt.Errorf("missing content type: expected %s", expected))
+	}
+	return NewError(http.StatusUnsupportedMediaType, fmt.Errorf("unsupported content type %q: expected %s", mediaType, expected))
+}
+
+// matchMediaType reports whether mediaType is accepted by pattern, as
+// described for WithRequiredContentTypes.
+func matchMediaType(mediaType, pattern string) bool {
+	pattern = strings.ToLower(pattern)
+	switch {
+	case mediaType == "":
+		return false
+	case pattern == "*/*":
+		return true
+	case strings.HasSuffix(pattern, "/*"):
+		return strings.HasPrefix(mediaType, strings.TrimSuffix(pattern, "*"))
+	case pattern == "application/json" && strings.HasPrefix(mediaType, "application/") && strings.HasSuffix(mediaType, "+json"):
+		return true
+	}
+	return mediaType == pattern
+}
+
+// bodyError returns the error for a failure to decode the request
+// body: 413 if the body exceeded the configured limit, the error itself
+// if it has a status code, or 400.
+func bodyError(err error) error {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return NewError(http.StatusRequestEntityTooLarge, err)
+	}
+	var coder HTTPStatusCoder
+	if errors.As(err, &coder) {
+		return err
+	}
+	return NewError(http.StatusBadRequest, err)
+}
+
+// decodeBody decodes the JSON request body into v. If key is not
+// empty, v is decoded from that property of the body instead. With
+// WithStrictJSON, unknown properties and trailing data are errors.
+func (o *options) decodeBody(r *http.Request, key string, v any) error {
+	if o.jsonEngine != nil && !o.strictJSON {
+		return o.unmarshalBody(r, key, v)
+	}
+	dec := json.NewDecoder(r.Body)
+	if key == "" {
+		if err := o.decodeJSON(dec, v); err != nil {
+			return fmt.Errorf("failed to decode request body: %w", err)
+		}
+		return nil
+	}
+
+	var wrapper map[string]json.RawMessage
+	if err := o.decodeJSON(dec, &wrapper); err != nil {
+		return fmt.Errorf("failed to decode request body: %w", err)
+	}
+	raw, ok := wrapper[key]
+	if !ok {
+		return fmt.Errorf("missing %q in request body", key)
+	}
+	if err := o.decodeJSON(json.NewDecoder(bytes.NewReader(raw)), v); err != nil {
+		return fmt.Errorf("failed to decode %q in request body: %w", key, err)
+	}
+	return nil
+}
+
+// unmarshalBody is decodeBody for the engine set with WithJSONEngine,
+// which unmarshals the body as a whole.
+func (o *options) unmarshalBody(r *http.Request, key string, v any) error {
+	data, err := io.ReadAll(r.Body)
+	if err == nil && len(bytes.TrimSpace(data)) == 0 {
+		err = io.EOF
+	}
+	if err != nil {
+		return fmt.Errorf("failed to decode request body: %w", err)
+	}
+	if key == "" {
+		if err := o.jsonEngine.Unmarshal(data, v); err != nil {
+			return fmt.Errorf("failed to decode request body: %w", err)
+		}
+		return nil
+	}
+
+	var wrapper map[string]json.RawMessage
+	if err := o.jsonEngine.Unmarshal(data, &wrapper); err != nil {
+		return fmt.Errorf("failed to decode request body: %w", err)
+	}
+	raw, ok := wrapper[key]
+	if !ok {
+		return fmt.Errorf("missing %q in request body", key)
+	}
+	if err := o.jsonEngine.Unmarshal(raw, v); err != nil {
+		return fmt.Errorf("failed to decode %q in request body: %w", key, err)
+	}
+	return nil
+}
+
+// decodeJSON decodes the single JSON value read by dec into v,
+// strictly with WithStrictJSON.
+func (o *options) decodeJSON(dec *json.Decoder, v any) error {
+	if !o.strictJSON {
+		return dec.Decode(v)
+	}
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return errors.New("unexpected data after JSON value")
+	}
+	return nil
+}
+
+// QueryMatcherFunc is a MatcherFunc for read-only endpoints. It
+// matches GET requests to the method's path, as accepted by
+// DefaultMatcherFunc, and binds the path variables, as described for
+// WithRESTRouting, and then the query parameters to the fields of the
+// method's single struct argument, if any. Each field is bound from
+// the parameter named by its `query` tag, or else by the name in its
+// `json` tag, or else by its field name; a `query` tag with the
+// ",required" option results in a 400 response when the parameter is
+// missing. A slice field collects a repeated or comma-separated
+// parameter, and a slice argument, rather than a struct, is bound from
+// the sole parameter, if there is exactly one. The fields of a nested
+// struct field are bound from parameters in dot or bracket notation,
+// such as ?filter.status=open or ?filter[status]=open, and those of an
+// embedded struct as if they were the argument's own. Cookie- and
+// header-tagged fields are bound as with DefaultMatcherFunc.
+// Requests with other HTTP methods, and those for methods taking only
+// scalar arguments bound from the path, are matched by
+// DefaultMatcherFunc.
+func QueryMatcherFunc(r *http.Request, methodName string, methodArgs ...reflect.Type) ([]any, bool, error) {
+	if r.Method != http.MethodGet {
+		return DefaultMatcherFunc(r, methodName, methodArgs...)
+	}
+	o := optionsFromContext(r.Context())
+	params, ok := o.endpoint(methodName).matchPath(r.URL.Path, o.strictPaths, o.foldPaths)
+	if !ok {
+		return nil, false, nil
+	}
+	setPathValues(r, params)
+
+	if len(methodArgs) == 0 {
+		return nil, true, nil
+	}
+
+	if o.positional(o.endpoint(methodName), methodArgs) && !o.isNested(methodArgs[0]) {
+		return DefaultMatcherFunc(r, methodName, methodArgs...)
+	}
+	if len(methodArgs) > 1 || !isStructType(methodArgs[0]) && !isList(reflect.New(methodArgs[0]).Elem()) {
+		return nil, false, nil
+	}
+
+	arg := reflect.New(methodArgs[0])
+	if err := o.applyDefaults(arg.Elem()); err != nil {
+		return nil, true, err
+	}
+	if err := o.bindPath(arg.Elem(), params); err != nil {
+		return nil, true, NewError(http.StatusBadRequest, err)
+	}
+	if err := o.bindQuery(arg.Elem(), r.URL.Query()); err != nil {
+		return nil, true, NewError(http.StatusBadRequest, err)
+	}
+	if err := o.bindCookies(arg.Elem(), r); err != nil {
+		return nil, true, NewError(http.StatusBadRequest, err)
+	}
+	if err := o.bindHeaders(arg.Elem(), r.Header); err != nil {
+		return nil, true, NewError(http.StatusBadRequest, err)
+	}
+	if err := o.checkRequired(arg.Elem()); err != nil {
+		return nil, true, err
 	}
 	return []any{arg.Elem().Interface()}, true, nil
 }
+
+// setPathValues sets the values of the path variables of the route r
+// matched, so that they can be read with r.PathValue by methods,
+// argument providers, and later matchers.
+func setPathValues(r *http.Request, params map[string]string) {
+	for name, value := range params {
+		r.SetPathValue(name, value)
+	}
+}
+
+// isStructType reports whether t is a struct or pointer to a struct.
+func isStructType(t reflect.Type) bool {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	return t.Kind() == reflect.Struct
+}
@@ -0,0 +1,60 @@
+package structhttp
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestOrderedMap(t *testing.T) {
+	var m OrderedMap
+	m.Set("zebra", 1)
+	m.Set("apple", "two")
+	m.Set("mango", []int{3})
+	m.Set("zebra", 4)
+	m.Delete("mango")
+
+	if v, ok := m.Get("zebra"); !ok || v != 4 {
+		t.Errorf("expected zebra to be 4, got %v", v)
+	}
+	if _, ok := m.Get("mango"); ok {
+		t.Errorf("expected mango to be deleted")
+	}
+
+	b, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"zebra":4,"apple":"two"}`; string(b) != want {
+		t.Errorf("expected %s, got %s", want, b)
+	}
+
+	var decoded OrderedMap
+	if err := json.Unmarshal([]byte(`{"b":1,"a":{"c":true}}`), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if keys := decoded.Keys(); !reflect.DeepEqual(keys, []string{"b", "a"}) {
+		t.Errorf("expected keys [b a], got %v", keys)
+	}
+	if err := json.Unmarshal([]byte(`[1]`), &decoded); err == nil {
+		t.Errorf("expected error decoding array")
+	}
+}
+
+func TestHandlerOrderedMap(t *testing.T) {
+	var m OrderedMap
+	m.Set("z", 1)
+	m.Set("a", 2)
+
+	testCases := []testCase{
+		{
+			name:               "ordered keys",
+			httpMethod:         "POST",
+			path:               "/OnlyResult",
+			result:             m,
+			expectedStatusCode: 200,
+			expectedBody:       "{\"z\":1,\"a\":2}\n",
+		},
+	}
+	runTests(t, testCases)
+}
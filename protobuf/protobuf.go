@@ -0,0 +1,56 @@
+// Package protobuf provides a structhttp.Codec for Protocol Buffers,
+// so that methods taking and returning generated message types can
+// serve protobuf to clients that ask for it and JSON to the rest:
+//
+//	structhttp.Handler(svc, structhttp.WithCodecs(protobuf.Codec))
+package protobuf
+
+import (
+	"errors"
+	"io"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/jfhamlin/structhttp"
+)
+
+// ContentType is the Content-Type of protobuf bodies, that of Codec.
+const ContentType = "application/x-protobuf"
+
+// Codec is the structhttp.Codec for ContentType, encoding with
+// ResponseEncoder and decoding with RequestDecoder.
+var Codec = structhttp.NewCodec(ContentType, ResponseEncoder, RequestDecoder)
+
+// ResponseEncoder is a structhttp.ResponseEncoder that encodes results
+// implementing proto.Message in the protobuf wire format. It declines
+// other results with errors.ErrUnsupported, so that they are encoded
+// as JSON.
+func ResponseEncoder(w io.Writer, v any) error {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return errors.ErrUnsupported
+	}
+	b, err := proto.Marshal(m)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+// RequestDecoder is a structhttp.RequestDecoder that decodes a body in
+// the protobuf wire format into v, a method argument implementing
+// proto.Message, such as a pointer to a generated message type. It
+// declines other arguments with errors.ErrUnsupported, which results
+// in a 415 response.
+func RequestDecoder(r io.Reader, v any) error {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return errors.ErrUnsupported
+	}
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	return proto.Unmarshal(b, m)
+}
@@ -0,0 +1,64 @@
+package protobuf
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+
+	"github.com/jfhamlin/structhttp"
+)
+
+type service struct{}
+
+func (service) Echo(m *wrapperspb.StringValue) *wrapperspb.StringValue {
+	return wrapperspb.String("echo " + m.GetValue())
+}
+
+func (service) Plain(args struct{ Name string }) map[string]string {
+	return map[string]string{"name": args.Name}
+}
+
+func TestCodec(t *testing.T) {
+	handler := structhttp.Handler(service{}, structhttp.WithCodecs(Codec))
+
+	body, err := proto.Marshal(wrapperspb.String("hi"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest("POST", "/Echo", bytes.NewReader(body))
+	r.Header.Set("Content-Type", ContentType)
+	r.Header.Set("Accept", ContentType)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != 200 || w.Header().Get("Content-Type") != ContentType {
+		t.Fatalf("expected 200 with Content-Type %q, got %d with %q: %s", ContentType, w.Code, w.Header().Get("Content-Type"), w.Body)
+	}
+	var got wrapperspb.StringValue
+	if err := proto.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.GetValue() != "echo hi" {
+		t.Errorf("expected %q, got %q", "echo hi", got.GetValue())
+	}
+
+	// Results that are not messages are encoded as JSON.
+	r = httptest.NewRequest("POST", "/Plain", bytes.NewReader([]byte(`{"Name":"ann"}`)))
+	r.Header.Set("Accept", ContentType)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != 200 || w.Body.String() != "{\"name\":\"ann\"}\n" {
+		t.Errorf("expected 200 with a JSON body, got %d with %q", w.Code, w.Body)
+	}
+
+	// Arguments that are not messages cannot be decoded from protobuf.
+	r = httptest.NewRequest("POST", "/Plain", bytes.NewReader(body))
+	r.Header.Set("Content-Type", ContentType)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != 415 {
+		t.Errorf("expected 415, got %d with %q", w.Code, w.Body)
+	}
+}
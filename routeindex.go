@@ -0,0 +1,130 @@
+package structhttp
+
+import (
+	"slices"
+	"strings"
+)
+
+type (
+	// routeIndex finds the methods whose endpoints match a request
+	// path without trying each method in turn. Methods are identified
+	// by their index in dispatch order.
+	routeIndex struct {
+		// exact holds the methods whose paths have no variables.
+		exact map[string][]int
+		// root is the trie of the methods whose paths have variables.
+		root *routeNode
+		// fold indicates that paths are indexed and looked up in
+		// lower case, for case-insensitive matching.
+		fold bool
+	}
+
+	// routeNode is a node of a routeIndex trie, reached by matching
+	// the path segments leading to it.
+	routeNode struct {
+		literals map[string]*routeNode
+		variable *routeNode
+		// methods are the methods whose paths end at the node.
+		methods []int
+		// rest are the methods whose paths end at the node with a
+		// {name...} variable matching the remainder of a path, which
+		// must include the slash before the variable.
+		rest []int
+	}
+)
+
+// newRouteIndex returns an index of the endpoints of methods, which
+// are in dispatch order.
+func (sh *structHandler) newRouteIndex() *routeIndex {
+	idx := &routeIndex{exact: make(map[string][]int), root: &routeNode{}, fold: sh.foldPaths}
+	for i, method := range sh.methods {
+		e := sh.endpoint(method.Name)
+		if !strings.Contains(e.path, "{") {
+			path := idx.normalize(e.path)
+			idx.exact[path] = append(idx.exact[path], i)
+			continue
+		}
+		segments := e.segments
+		if idx.fold {
+			segments = make([]string, len(e.segments))
+			for j, seg := range e.segments {
+				if _, _, ok := variable(seg); ok {
+					segments[j] = seg
+				} else {
+					segments[j] = idx.normalize(seg)
+				}
+			}
+		}
+		idx.root.insert(segments, i)
+	}
+	return idx
+}
+
+func (n *routeNode) insert(segments []string, i int) {
+	for j, seg := range segments {
+		_, rest, ok := variable(seg)
+		switch {
+		case ok && rest && j == len(segments)-1:
+			n.rest = append(n.rest, i)
+			return
+		case ok:
+			if n.variable == nil {
+				n.variable = &routeNode{}
+			}
+			n = n.variable
+		default:
+			if n.literals == nil {
+				n.literals = make(map[string]*routeNode)
+			}
+			child, found := n.literals[seg]
+			if !found {
+				child = &routeNode{}
+				n.literals[seg] = child
+			}
+			n = child
+		}
+	}
+	n.methods = append(n.methods, i)
+}
+
+// lookup returns the methods whose endpoints match path, as with
+// endpoint.matchPath, in dispatch order.
+func (idx *routeIndex) lookup(path string, strict bool) []int {
+	if !strings.HasPrefix(path, "/") {
+		if strict {
+			return nil
+		}
+		path = "/" + path
+	}
+	path = idx.normalize(path)
+
+	found := idx.root.lookup(strings.Split(strings.TrimPrefix(path, "/"), "/"), nil)
+	if len(found) == 0 {
+		return idx.exact[path]
+	}
+	found = append(found, idx.exact[path]...)
+	slices.Sort(found)
+	return slices.Compact(found)
+}
+
+// normalize returns s in lower case if the index folds case.
+func (idx *routeIndex) normalize(s string) string {
+	if idx.fold {
+		return strings.ToLower(s)
+	}
+	return s
+}
+
+func (n *routeNode) lookup(segments []string, found []int) []int {
+	if len(segments) == 0 {
+		return append(found, n.methods...)
+	}
+	found = append(found, n.rest...)
+	if child, ok := n.literals[segments[0]]; ok {
+		found = child.lookup(segments[1:], found)
+	}
+	if n.variable != nil && segments[0] != "" {
+		found = n.variable.lookup(segments[1:], found)
+	}
+	return found
+}
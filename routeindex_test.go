@@ -0,0 +1,72 @@
+package structhttp
+
+import (
+	"reflect"
+	"testing"
+)
+
+type indexedService struct {
+	Routes struct {
+		File       string `route:"GET /files/{path...}"`
+		Readme     string `route:"GET /files/readme"`
+		User       string `route:"GET /users/{id}"`
+		Me         string `route:"GET /users/me"`
+		CreateUser string `route:"/users"`
+		Post       string `route:"GET /users/{id}/posts/{post}"`
+	}
+}
+
+func (indexedService) File(args struct{ Path string }) string { return args.Path }
+func (indexedService) Readme() string                         { return "readme" }
+func (indexedService) User(id string) string                  { return id }
+func (indexedService) Me() string                             { return "me" }
+func (indexedService) CreateUser(name string) string          { return name }
+func (indexedService) Post(args struct{ ID, Post string }) string {
+	return args.ID + "/" + args.Post
+}
+func (indexedService) Ping() string { return "pong" }
+
+func TestRouteIndex(t *testing.T) {
+	sh := newStructHandler(indexedService{})
+	if sh.index == nil {
+		t.Fatal("expected a route index with the default matcher")
+	}
+
+	paths := []string{
+		"/files", "/files/", "/files/readme", "/files/a/b",
+		"/users", "/users/", "/users/me", "/users/7", "/users/7/posts/3",
+		"/users/7/posts", "/users//posts/3", "/Ping", "Ping", "/missing",
+	}
+	for _, path := range paths {
+		var want []int
+		for i, method := range sh.methods {
+			if _, ok := sh.endpoint(method.Name).matchPath(path, false, false); ok {
+				want = append(want, i)
+			}
+		}
+		if got := sh.index.lookup(path, false); !reflect.DeepEqual(got, want) {
+			t.Errorf("lookup(%q) = %v, want %v", path, got, want)
+		}
+	}
+
+	sh = newStructHandler(indexedService{}, WithCaseInsensitivePaths(true))
+	for _, path := range []string{"/FILES/README", "/Users/ME", "/users/ABC/Posts/x", "/ping"} {
+		var want []int
+		for i, method := range sh.methods {
+			if _, ok := sh.endpoint(method.Name).matchPath(path, false, true); ok {
+				want = append(want, i)
+			}
+		}
+		if got := sh.index.lookup(path, false); len(got) == 0 || !reflect.DeepEqual(got, want) {
+			t.Errorf("case-insensitive lookup(%q) = %v, want %v", path, got, want)
+		}
+	}
+
+	if got := sh.index.lookup("Ping", true); got != nil {
+		t.Errorf("strict lookup of a relative path = %v, want none", got)
+	}
+
+	if sh := newStructHandler(indexedService{}, WithMatcherFunc(restMatcherFunc)); sh.index != nil {
+		t.Error("expected no route index with a custom matcher")
+	}
+}
@@ -0,0 +1,78 @@
+package structhttp
+
+import (
+	"reflect"
+	"strings"
+)
+
+// Route describes a method exposed by Handler.
+type Route struct {
+	// Name is the name of the method.
+	Name string
+	// HTTPMethod and Pattern are the HTTP method and path pattern at
+	// which DefaultMatcherFunc serves the method, such as "GET" and
+	// "/user/{id}". Custom matchers may route requests differently.
+	HTTPMethod string
+	Pattern    string
+	// Args are the types of the arguments supplied by the
+	// MatcherFunc, excluding injected arguments such as
+	// context.Context, *http.Request, and provided types.
+	Args []reflect.Type
+	// Results are the types of the method's return values.
+	Results []reflect.Type
+}
+
+// Describe returns the routes Handler would expose for s when
+// configured with opts, in dispatch order. The routes can be used to
+// generate documentation or to mount the methods on another router.
+func Describe(s any, opts ...Option) []Route {
+	sh := newStructHandler(s, opts...)
+
+	routes := make([]Route, 0, len(sh.methods))
+	for _, method := range sh.methods {
+		e := sh.endpoint(method.Name)
+		route := Route{
+			Name:       method.Name,
+			HTTPMethod: e.verb,
+			Pattern:    e.path,
+			Args:       method.argTypes,
+		}
+		for i := 0; i < method.Type.NumOut(); i++ {
+			route.Results = append(route.Results, method.Type.Out(i))
+		}
+		routes = append(routes, route)
+	}
+	return routes
+}
+
+// String returns the route's signature, such as
+// "Create(*app.Item) (*app.Item, error)".
+func (r Route) String() string {
+	var sb strings.Builder
+	sb.WriteString(r.Name)
+	sb.WriteString("(")
+	for i, arg := range r.Args {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		sb.WriteString(arg.String())
+	}
+	sb.WriteString(")")
+
+	switch len(r.Results) {
+	case 0:
+	case 1:
+		sb.WriteString(" ")
+		sb.WriteString(r.Results[0].String())
+	default:
+		sb.WriteString(" (")
+		for i, result := range r.Results {
+			if i > 0 {
+				sb.WriteString(", ")
+			}
+			sb.WriteString(result.String())
+		}
+		sb.WriteString(")")
+	}
+	return sb.String()
+}
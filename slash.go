@@ -0,0 +1,67 @@
+package structhttp
+
+import (
+	"net/http"
+	"path"
+	"strings"
+)
+
+// TrailingSlashPolicy determines how Handler treats requests whose
+// paths match a method's route only once a trailing slash is added or
+// removed.
+type TrailingSlashPolicy int
+
+const (
+	// TrailingSlashStrict matches paths exactly, so that /Inputs/
+	// does not match the route /Inputs. It is the default.
+	TrailingSlashStrict TrailingSlashPolicy = iota
+	// TrailingSlashLenient serves such requests as if their paths
+	// matched the route.
+	TrailingSlashLenient
+	// TrailingSlashRedirect redirects such requests to the path
+	// matching the route, with status 301 for GET and HEAD requests
+	// and 308, which preserves the method and body, for others.
+	TrailingSlashRedirect
+)
+
+// toggleTrailingSlash returns a copy of r whose path has a trailing
+// slash removed, or added if it has none, or false if r's path is
+// the root.
+func toggleTrailingSlash(r *http.Request) (*http.Request, bool) {
+	p := r.URL.Path
+	if p == "" || p == "/" || p == "*" {
+		return nil, false
+	}
+	alt := r.Clone(r.Context())
+	if trimmed, ok := strings.CutSuffix(p, "/"); ok {
+		alt.URL.Path = trimmed
+	} else {
+		alt.URL.Path = p + "/"
+	}
+	alt.URL.RawPath = ""
+	return alt, true
+}
+
+// redirectTrailingSlash redirects r to its path with a trailing slash
+// removed, or added if it has none. The Location is relative, so that
+// it is correct even if a prefix was stripped from the path, and
+// begins with ./ or ../, so that a last segment such as "https:x" is
+// not taken for a scheme.
+func redirectTrailingSlash(w http.ResponseWriter, r *http.Request) {
+	p := r.URL.EscapedPath()
+	base := path.Base(p)
+	location := "./" + base + "/"
+	if strings.HasSuffix(p, "/") {
+		location = "../" + base
+	}
+	if r.URL.RawQuery != "" {
+		location += "?" + r.URL.RawQuery
+	}
+
+	code := http.StatusPermanentRedirect
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		code = http.StatusMovedPermanently
+	}
+	w.Header().Set("Location", location)
+	w.WriteHeader(code)
+}
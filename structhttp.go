@@ -1,25 +1,98 @@
 package structhttp
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
 	"net/http"
 	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type (
 	// MatcherFunc is a function that determines whether a request
-	// matches a method. It returns the non-default arguments to pass to
-	// the method, a boolean indicating whether the request matches, and
-	// an error if one occurred.
+	// matches a method. The methodArgs are the types of the method's
+	// arguments in order, excluding injected arguments such as
+	// context.Context, *http.Request, and types registered with
+	// WithInjectable, which the handler supplies itself. It returns
+	// the values for those non-injected arguments, a boolean
+	// indicating whether the request matches, and an error if one
+	// occurred. A non-nil error ends matching and is written as the
+	// response, whether or not the request matches.
+	//
+	// A MatcherFunc is a Matcher receiving the Name and Args of the
+	// Method.
 	MatcherFunc func(r *http.Request, methodName string, methodArgs ...reflect.Type) (arguments []any, matches bool, err error)
 
+	// Matcher determines whether a request matches a method. Match
+	// returns the values of the method's non-injected arguments, as
+	// described for MatcherFunc, whether the request matches, and an
+	// error if one occurred. A non-nil error ends matching and is
+	// written as the response, whether or not the request matches.
+	Matcher interface {
+		Match(r *http.Request, method Method) (arguments []any, matches bool, err error)
+	}
+
+	// Method describes a method exposed by Handler to a Matcher.
+	Method struct {
+		// Name is the name of the method, qualified for the methods
+		// of mounted structs, such as "Users.Create".
+		Name string
+		// Args are the types of the method's arguments in order,
+		// excluding injected arguments.
+		Args []reflect.Type
+		// ArgNames are the names of the Args, if registered with
+		// WithParamNames.
+		ArgNames []string
+		// ReflectMethod is the method as found by reflection on its
+		// receiver, with its unqualified name.
+		ReflectMethod reflect.Method
+		// Tag holds the struct tags annotating the method: the tags of
+		// fields named after the method in struct-typed fields of its
+		// receiver, as with route tags. The tags of several such
+		// fields are joined.
+		Tag reflect.StructTag
+	}
+
 	structHandler struct {
-		structValue reflect.Value
-		methods     []reflect.Method
+		*options
+
+		methods []methodInfo
+		// index, if not nil, finds the methods whose routes match a
+		// request path, for handlers whose matchers only match requests
+		// to their methods' routes.
+		index *routeIndex
 
-		matcher MatcherFunc
+		// errs are the problems found while building the handler.
+		errs []error
+	}
+
+	// methodInfo is a method exposed by a structHandler along with
+	// the argument types passed to the matcher.
+	methodInfo struct {
+		reflect.Method
+		argTypes []reflect.Type
+		// desc describes the method to matchers.
+		desc Method
+
+		// recv is the receiver of the method: the struct, or a struct
+		// mounted within it.
+		recv reflect.Value
+
+		// successStatus overrides the status code of successful
+		// responses if non-zero.
+		successStatus int
+		// contentType is the Content-Type of successful responses
+		// with a body, if set.
+		contentType string
 	}
 )
 
@@ -29,6 +102,20 @@ var (
 	errorType = reflect.TypeOf((*error)(nil)).Elem()
 	ctxType   = reflect.TypeOf((*context.Context)(nil)).Elem()
 	reqType   = reflect.TypeOf((*http.Request)(nil))
+	rwType    = reflect.TypeOf((*http.ResponseWriter)(nil)).Elem()
+	byteType  = reflect.TypeOf(byte(0))
+	boolType  = reflect.TypeOf(false)
+
+	// probeVerbs are the HTTP methods tried when discovering the
+	// methods allowed for a path.
+	probeVerbs = []string{
+		http.MethodGet,
+		http.MethodHead,
+		http.MethodPost,
+		http.MethodPut,
+		http.MethodPatch,
+		http.MethodDelete,
+	}
 )
 
 // Handler returns an http.Handler for the given struct.
@@ -39,12 +126,33 @@ var (
 // # Route Mapping
 //
 // By default, requests are mapped to methods where the HTTP method is
-// POST and the path is the method name prefixed with a slash. If a
-// method accepts an *http.Request or context.Context argument, the
-// value is provided directly from the incoming *http.Request. At most
-// one other argument may be present, and its value will be the
-// request body decoded as JSON. The matching behavior can be
-// customized by providing a MatcherFunc option.
+// POST and the path is the method name prefixed with a slash. A
+// method's route can instead be derived from its name with
+// WithRESTRouting, or declared with a `route` tag on a field named
+// after the method in a struct-typed field of the struct:
+//
+//	type Users struct {
+//		Routes struct {
+//			GetUser    string `route:"GET /users/{id}"`
+//			CreateUser string `route:"POST /users"`
+//		}
+//	}
+//
+// A tag holds an HTTP method and a path pattern, or only a path for a
+// POST route. A struct-typed field tagged `mount:"/prefix"` is served
+// under the prefix, or under its name in kebab case if the tag is
+// empty, and its methods are named with the field name as a
+// qualifier, such as "Users.Create", in options and matchers.
+//
+// If a method accepts an *http.Request or context.Context argument,
+// the value is provided directly from the incoming *http.Request; an
+// http.ResponseWriter argument lets the method write the response
+// itself, in which case its results are ignored; it may be asserted
+// to an http.Flusher or http.Hijacker. At most one other
+// argument may be present, and its value is bound from the request
+// body, decoded as JSON, and from the path, query, cookies, and
+// headers, as described for DefaultMatcherFunc. The matching behavior
+// can be customized by providing a Matcher or MatcherFunc option.
 //
 // # Return Values
 //
@@ -54,143 +162,1107 @@ var (
 // 2. An error
 // 3. A single value
 // 4. A single value and an error
+// 5. A single value, a bool, and an error
 //
 // Methods that return anything else will not be matched.
 //
+// A single value is encoded as JSON with status 200, or as negotiated
+// with WithCodecs, while byte slices, PlainText, and io.Reader results
+// are written verbatim, as described for ContentTyper. A false bool
+// with a nil error reports the value as absent, with a 404 response.
+//
 // # HTTP Status Codes
 //
 // If the method returns an error, the error's Error() method will be
 // used as the response body, and the status code will be set to 500.
 // If the error implements the HTTPStatusCoder interface, the status
-// code will be set to the value returned by HTTPStatusCode().
+// code will be set to the value returned by HTTPStatusCode(). Requests
+// whose path matches a method, but not with their HTTP method, receive
+// a 405 response, and other requests that match no method a 404
+// response. OPTIONS and HEAD requests that match no method are
+// answered as described for WithPreflightMethodDiscovery and
+// WithAutomaticHEAD.
+//
+// Problems found while building the handler, as described for
+// NewHandler, are logged as warnings rather than returned.
 func Handler(s any, opts ...Option) http.Handler {
+	sh := newStructHandler(s, opts...)
+	if err := sh.validate(); err != nil {
+		sh.logger.Warn("methods have invalid routes or arguments", "error", err)
+	}
+	return sh
+}
+
+// NewHandler is like Handler, but returns an error describing the
+// problems found while building the handler: invalid route or mount
+// tags, tags and options such as WithMethods and WithParamNames naming
+// no method of the struct, methods sharing an HTTP method and path
+// pattern, and options that cannot take effect, such as CORSOptions
+// allowing credentials from any origin.
+//
+// It also checks, at construction rather than on the first request,
+// that the argument DefaultMatcherFunc decodes from each method's
+// request body can be decoded from JSON and has valid `default` tags.
+// The check is conservative: it reports only types encoding/json can
+// never decode, such as those containing a channel or function, and
+// it is skipped for types implementing json.Unmarshaler or
+// encoding.TextUnmarshaler and for handlers without DefaultMatcherFunc
+// or QueryMatcherFunc among their matchers. With WithStrictSignatures,
+// methods whose signatures cannot be served are reported too, rather
+// than silently left out.
+func NewHandler(s any, opts ...Option) (http.Handler, error) {
+	sh := newStructHandler(s, opts...)
+	if err := sh.validate(); err != nil {
+		return nil, err
+	}
+	return sh, nil
+}
+
+// HandlerWithError is like Handler, but returns an error as described
+// for NewHandler.
+//
+// Deprecated: Use NewHandler.
+func HandlerWithError(s any, opts ...Option) (http.Handler, error) {
+	return NewHandler(s, opts...)
+}
+
+// newStructHandler returns the structHandler for s configured with
+// opts.
+func newStructHandler(s any, opts ...Option) *structHandler {
 	o := &options{
-		matcher: DefaultMatcherFunc,
+		matchers:        []Matcher{MatcherFunc(DefaultMatcherFunc)},
+		errorEncoder:    JSONErrorEncoder,
+		absentStatus:    http.StatusNotFound,
+		discoverMethods: true,
+		autoHead:        true,
 	}
 	for _, opt := range opts {
 		opt(o)
 	}
+	if o.logger == nil {
+		o.logger = slog.New(discardHandler{})
+	}
+
+	sh := &structHandler{options: o}
+	names := make(map[string]bool)
+	sh.addMethods(reflect.ValueOf(s), "", "", names)
+
+	for _, name := range sortedKeys(o.include) {
+		if !names[name] {
+			sh.errs = append(sh.errs, fmt.Errorf("WithMethods: no such method %s", name))
+		}
+	}
+	for _, name := range sortedKeys(o.paramNames) {
+		if !names[name] {
+			sh.errs = append(sh.errs, fmt.Errorf("WithParamNames: no such method %s", name))
+		}
+	}
+	if o.idempotency != nil && o.idempotency.scope == nil {
+		sh.errs = append(sh.errs, errors.New("WithIdempotency: scope is required; no requests are deduplicated"))
+	}
+	if o.cors != nil && o.cors.AllowCredentials && slices.Contains(o.cors.AllowedOrigins, "*") {
+		sh.errs = append(sh.errs, errors.New(`WithCORS: AllowCredentials requires explicit AllowedOrigins; "*" allows no origin`))
+	}
+	sh.sortMethods()
+	sh.errs = append(sh.errs, sh.routeConflicts()...)
+	if sh.onlyDefaultMatchers() {
+		sh.index = sh.newRouteIndex()
+	}
+
+	return sh
+}
+
+// newMethodInfo returns the methodInfo of m, a method of recv served
+// under the given name and annotated with tag. Problems with the
+// method are recorded in sh.errs.
+func (sh *structHandler) newMethodInfo(m reflect.Method, recv reflect.Value, name string, tag reflect.StructTag) methodInfo {
+	desc := Method{Name: name, ReflectMethod: m, Tag: tag}
+	paramNames, named := sh.paramNames[name]
+	if named && len(paramNames) != m.Type.NumIn()-1 {
+		sh.errs = append(sh.errs, fmt.Errorf("WithParamNames: method %s has %d parameters, got %d names", name, m.Type.NumIn()-1, len(paramNames)))
+		named = false
+	}
+	argTypes := make([]reflect.Type, 0, m.Type.NumIn()-1)
+	for i := 1; i < m.Type.NumIn(); i++ {
+		typ := m.Type.In(i)
+		if !sh.injected(typ) {
+			argTypes = append(argTypes, typ)
+			if named {
+				desc.ArgNames = append(desc.ArgNames, paramNames[i-1])
+			}
+		}
+	}
+	desc.Args = argTypes
+
+	if e, ok := sh.positionalEndpoint(m.Name, sh.endpoint(name), desc, tag); ok {
+		sh.setEndpoint(name, e)
+	}
+	if consumes, ok := tag.Lookup("consumes"); ok {
+		sh.setConsumes(name, consumes)
+	}
+	bound := argTypes
+	if n := len(bound); n > 0 && isBodyReader(bound[n-1]) {
+		bound = bound[:n-1]
+	}
+	if sh.strictSignatures && len(bound) > 1 && !sh.positional(sh.endpoint(name), bound) && sh.onlyDefaultMatchers() {
+		sh.errs = append(sh.errs, fmt.Errorf("method %s: takes %d arguments, but DefaultMatcherFunc supplies at most one", name, len(argTypes)))
+	}
+
+	m.Name = name
+	return methodInfo{
+		Method:        m,
+		recv:          recv,
+		argTypes:      argTypes,
+		desc:          desc,
+		successStatus: sh.successStatus[name],
+		contentType:   sh.contentTypes[name],
+	}
+}
+
+// routeKey returns a key equal for endpoints matching the same
+// requests, as described for routeConflicts.
+func (sh *structHandler) routeKey(e endpoint) string {
+	key := e.verb + " " + e.shape()
+	if sh.foldPaths {
+		key = strings.ToLower(key)
+	}
+	return key
+}
+
+// sortMethods puts the methods in dispatch order: by priority, as set
+// with WithRoutePriority, then by the specificity of their endpoints,
+// then by name.
+func (sh *structHandler) sortMethods() {
+	slices.SortStableFunc(sh.methods, func(a, b methodInfo) int {
+		if c := sh.priorities[b.Name] - sh.priorities[a.Name]; c != 0 {
+			return c
+		}
+		if c := compareSpecificity(sh.endpoint(a.Name), sh.endpoint(b.Name)); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Name, b.Name)
+	})
+}
+
+// routeConflicts returns an error for each method whose endpoint has
+// the same HTTP method and path pattern as that of an earlier method,
+// ignoring the names of path variables, and the case of the paths
+// with WithCaseInsensitivePaths. Only the first such method
+// would ever be matched.
+func (sh *structHandler) routeConflicts() []error {
+	var errs []error
+	seen := make(map[string]string)
+	for _, method := range sh.methods {
+		e := sh.endpoint(method.Name)
+		key := sh.routeKey(e)
+		if other, ok := seen[key]; ok {
+			errs = append(errs, fmt.Errorf("route %s %s of method %s conflicts with method %s", e.verb, e.path, method.Name, other))
+			continue
+		}
+		seen[key] = method.Name
+	}
+	return errs
+}
 
-	sv := reflect.ValueOf(s)
-	sh := &structHandler{
-		structValue: sv,
-		matcher:     o.matcher,
+// addMethods adds the routable methods of v, recording the names of
+// all its methods in names. The methods of a struct mounted under a
+// path prefix, as described for Handler, are named with the
+// qualifier, such as "Users.", and served under the prefix.
+func (sh *structHandler) addMethods(v reflect.Value, prefix, qualifier string, names map[string]bool) {
+	o := sh.options
+	if v.Kind() == reflect.Struct && v.CanAddr() {
+		v = v.Addr()
 	}
 
-	for i := 0; i < sv.NumMethod(); i++ {
-		m := sv.Type().Method(i)
+	tags := methodTags(v.Type())
+	tagged, errs := taggedEndpoints(tags)
+	sh.errs = append(sh.errs, errs...)
+
+	for i := 0; i < v.NumMethod(); i++ {
+		m := v.Type().Method(i)
+		name := qualifier + m.Name
+		names[name] = true
 
+		if !o.exposes(name) {
+			continue
+		}
 		if !allowedMethod(m.Type) {
+			if o.strictSignatures {
+				sh.errs = append(sh.errs, fmt.Errorf("method %s: unsupported results; want (), (error), (T), (T, error), or (T, bool, error)", name))
+			}
 			continue
 		}
 
-		sh.methods = append(sh.methods, m)
+		e, derived := o.derivedEndpoint(m.Name, tagged)
+		delete(tagged, m.Name)
+		if derived || prefix != "" {
+			o.setEndpoint(name, newEndpoint(e.verb, prefix+e.path))
+		}
+
+		sh.methods = append(sh.methods, sh.newMethodInfo(m, v, name, tags[m.Name]))
 	}
 
-	return sh
+	for _, name := range sortedKeys(tagged) {
+		sh.errs = append(sh.errs, fmt.Errorf("route tag for %s%s: no such method", qualifier, name))
+	}
+
+	sv := reflect.Indirect(v)
+	if sv.Kind() != reflect.Struct {
+		return
+	}
+	for i := 0; i < sv.NumField(); i++ {
+		field := sv.Type().Field(i)
+		path, ok := field.Tag.Lookup("mount")
+		if !ok {
+			continue
+		}
+		if path == "" {
+			path = "/" + o.resourceNaming()(field.Name)
+		}
+
+		fv := sv.Field(i)
+		switch {
+		case !field.IsExported():
+			sh.errs = append(sh.errs, fmt.Errorf("mount %s%s: field is unexported", qualifier, field.Name))
+		case !strings.HasPrefix(path, "/"):
+			sh.errs = append(sh.errs, fmt.Errorf("mount %s%s: path %q must begin with /", qualifier, field.Name, path))
+		case fv.Kind() == reflect.Pointer && fv.IsNil():
+			sh.errs = append(sh.errs, fmt.Errorf("mount %s%s: field is nil", qualifier, field.Name))
+		default:
+			sh.addMethods(fv, prefix+path, qualifier+field.Name+".", names)
+		}
+	}
 }
 
 func (sh *structHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	for _, method := range sh.methods {
-		argTypes := make([]reflect.Type, 0, method.Type.NumIn()-1)
-		for i := 1; i < method.Type.NumIn(); i++ {
-			typ := method.Type.In(i)
-			switch typ {
-			case ctxType, reqType:
-			default:
-				argTypes = append(argTypes, typ)
+	start := time.Now()
+	rw := &responseWriter{ResponseWriter: w}
+	body := &countingReader{ReadCloser: http.NoBody}
+	var name string
+	defer func() {
+		// A panic is re-raised for net/http to handle once the request
+		// has been cleaned up, logged, and observed as a 500.
+		v := recover()
+		if r.MultipartForm != nil {
+			_ = r.MultipartForm.RemoveAll()
+		}
+		status := rw.status
+		if status == 0 {
+			status = http.StatusOK
+			if v != nil {
+				status = http.StatusInternalServerError
 			}
 		}
+		if v != nil {
+			sh.logger.ErrorContext(r.Context(), "panic serving request", "method", name, "path", r.URL.Path, "status", status, "panic", v)
+		} else {
+			sh.logger.DebugContext(r.Context(), "served request", "method", name, "path", r.URL.Path, "status", status)
+		}
+		if sh.observer != nil {
+			sh.observer(r, Observation{
+				Method:        name,
+				StatusCode:    status,
+				RequestBytes:  body.n,
+				ResponseBytes: rw.written,
+				Duration:      time.Since(start),
+			})
+		}
+		if v != nil {
+			panic(v)
+		}
+	}()
 
-		args, matches, err := sh.matcher(r, method.Name, argTypes...)
-		if !matches {
-			continue
+	if sh.maxQueryParams > 0 && countQueryParams(r.URL.RawQuery) > sh.maxQueryParams {
+		sh.writeError(rw, r, NewError(http.StatusBadRequest, fmt.Errorf("too many query parameters: limit is %d", sh.maxQueryParams)))
+		return
+	}
+
+	crossOrigin := sh.cors != nil && sh.cors.allowOrigin(rw, r.Header.Get("Origin"))
+
+	r = r.WithContext(context.WithValue(r.Context(), optionsKey{}, sh.options))
+	if sh.baseCtx != nil {
+		ctx, cancel := withBase(r.Context(), sh.baseCtx)
+		defer cancel()
+		r = r.WithContext(ctx)
+	}
+	if sh.baggage {
+		r = withBaggage(r)
+	}
+	if sh.observer != nil && r.Body != nil {
+		body.ReadCloser = r.Body
+		r.Body = body
+	}
+	if sh.maxBodyBytes > 0 {
+		r.Body = http.MaxBytesReader(rw, r.Body, sh.maxBodyBytes)
+	}
+	if sh.decompress {
+		r = withDecompression(rw, r, sh.maxInflateBytes)
+	}
+
+	method, args, matches, err := sh.matchHEAD(rw, r)
+	if !matches && sh.trailingSlash != TrailingSlashStrict {
+		if alt, ok := toggleTrailingSlash(r); ok {
+			switch sh.trailingSlash {
+			case TrailingSlashRedirect:
+				if slices.Contains(sh.allowedVerbs(alt), r.Method) {
+					redirectTrailingSlash(rw, r)
+					return
+				}
+			case TrailingSlashLenient:
+				if method, args, matches, err = sh.matchHEAD(rw, alt); matches {
+					r = alt
+				}
+			}
+		}
+	}
+	if !matches {
+		sh.logger.DebugContext(r.Context(), "no method matched", "path", r.URL.Path)
+		if crossOrigin && isPreflight(r) {
+			if allow := sh.allowedVerbs(r); len(allow) > 0 {
+				sh.cors.writePreflight(rw, r, allow)
+				return
+			}
 		}
-		if err != nil {
-			writeResponse(w, []reflect.Value{reflect.ValueOf(err)})
+		sh.notFound(rw, r)
+		return
+	}
+	name = method.Name
+	sh.logger.DebugContext(r.Context(), "matched method", "method", name, "path", r.URL.Path)
+	if err != nil {
+		sh.logger.ErrorContext(r.Context(), "failed to match request", "method", name, "path", r.URL.Path, "error", err)
+		sh.writeError(rw, r, err)
+		return
+	}
+
+	r = sh.withPathParams(r, name)
+
+	if key := sh.idempotency.key(r, method); key != "" {
+		unlock := sh.idempotency.lock(key)
+		defer unlock()
+		if sh.replay(rw, r, key) {
 			return
 		}
+		rw.recorded, rw.recordLimit = &bytes.Buffer{}, maxIdempotentResponseBytes
+		defer sh.remember(rw, r, key)
+	}
+
+	if len(sh.contextFuncs) > 0 {
+		ctx := r.Context()
+		for _, f := range sh.contextFuncs {
+			ctx = f(ctx, r)
+		}
+		r = r.WithContext(ctx)
+	}
 
-		name := method.Name
-
-		methodArgs := make([]reflect.Value, method.Type.NumIn())
-		methodArgs[0] = sh.structValue
-		for i := 1; i < method.Type.NumIn(); i++ {
-			argType := method.Type.In(i)
-			switch argType {
-			case ctxType:
-				methodArgs[i] = reflect.ValueOf(r.Context())
-			case reqType:
-				methodArgs[i] = reflect.ValueOf(r)
-			default:
-				if len(args) == 0 {
-					panic("not enough arguments to " + name + " method")
+	numIn := method.Type.NumIn()
+	takesWriter := false
+	methodArgs := make([]reflect.Value, numIn)
+	methodArgs[0] = method.recv
+	for i := 1; i < numIn; i++ {
+		argType := method.Type.In(i)
+		if method.Type.IsVariadic() && i == numIn-1 && !sh.injected(argType) {
+			v, err := method.variadicArg(args)
+			if err != nil {
+				sh.writeError(rw, r, err)
+				return
+			}
+			methodArgs[i] = v
+			break
+		}
+		switch argType {
+		case ctxType:
+			methodArgs[i] = reflect.ValueOf(r.Context())
+		case reqType:
+			methodArgs[i] = reflect.ValueOf(r)
+		case rwType:
+			methodArgs[i] = reflect.ValueOf(http.ResponseWriter(rw))
+			takesWriter = true
+		default:
+			if inject, ok := sh.providers[argType]; ok {
+				v, err := inject(r)
+				if err != nil {
+					sh.writeError(rw, r, err)
+					return
 				}
-				methodArgs[i] = reflect.ValueOf(args[0])
-				args = args[1:]
+				methodArgs[i] = v
+				continue
 			}
+			methodArgs[i] = argValue(args[0], argType)
+			args = args[1:]
+		}
+	}
+	for i := 1; i < numIn; i++ {
+		if err := method.checkArg(i, methodArgs[i]); err != nil {
+			sh.writeError(rw, r, err)
+			return
 		}
-		if len(args) > 0 {
-			panic("too many arguments to " + name + " method")
+	}
+
+	if err := sh.preInvoke(r, method, methodArgs[1:]); err != nil {
+		sh.writeError(rw, r, err)
+		return
+	}
+
+	if rw.discardBody && sh.skipHead {
+		if method.contentType != "" {
+			rw.Header().Set("Content-Type", method.contentType)
 		}
+		rw.WriteHeader(method.status(http.StatusOK))
+		return
+	}
 
-		result := method.Func.Call(methodArgs)
-		writeResponse(w, result)
+	var result []reflect.Value
+	if method.Type.IsVariadic() {
+		result = method.Func.CallSlice(methodArgs)
+	} else {
+		result = method.Func.Call(methodArgs)
+	}
+	closeFiles(method.Type, methodArgs)
+	if takesWriter && rw.status != 0 {
+		if n := len(result); n > 0 {
+			if err, ok := result[n-1].Interface().(error); ok && err != nil {
+				sh.logger.ErrorContext(r.Context(), "method failed after writing the response", "method", name, "path", r.URL.Path, "error", err)
+			}
+		}
 		return
 	}
+	if err := sh.writeResponse(rw, r, method, result); err != nil {
+		sh.logger.ErrorContext(r.Context(), "failed to encode response", "method", name, "path", r.URL.Path, "error", err)
+	}
+}
 
-	http.NotFound(w, r)
+// withBase returns a copy of ctx that is also cancelled when base is
+// cancelled, with base's cause.
+func withBase(ctx, base context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	if base.Err() != nil {
+		cancel(context.Cause(base))
+		return ctx, func() {}
+	}
+	stop := context.AfterFunc(base, func() {
+		cancel(context.Cause(base))
+	})
+	return ctx, func() {
+		stop()
+		cancel(nil)
+	}
 }
 
-func writeResponse(w http.ResponseWriter, out []reflect.Value) {
-	if len(out) == 0 {
-		w.WriteHeader(http.StatusNoContent)
+// preInvoke calls the functions set with WithPreInvoke, returning
+// the first error, which resolves to a 403 status code unless it
+// implements HTTPStatusCoder.
+func (sh *structHandler) preInvoke(r *http.Request, method *methodInfo, args []reflect.Value) error {
+	if len(sh.preInvokes) == 0 {
+		return nil
+	}
+
+	values := make([]any, len(args))
+	for i, arg := range args {
+		values[i] = arg.Interface()
+	}
+	for _, f := range sh.preInvokes {
+		err := f(r.Context(), r, method.Name, values)
+		if err == nil {
+			continue
+		}
+		var statusCoder HTTPStatusCoder
+		if !errors.As(err, &statusCoder) {
+			err = NewError(http.StatusForbidden, err)
+		}
+		return err
+	}
+	return nil
+}
+
+// match returns the first method accepted by a matcher for r, along
+// with the arguments supplied by the matcher. Matchers are tried in
+// order, each against every method, and matching stops at the first
+// match or error. The error is non-nil if the matcher failed or
+// supplied the wrong number of arguments.
+func (sh *structHandler) match(r *http.Request) (*methodInfo, []any, bool, error) {
+	candidates := sh.candidates(r.URL.Path)
+	for _, matcher := range sh.matchers {
+		for _, i := range candidates {
+			method := &sh.methods[i]
+			args, matches, err := matcher.Match(r, method.desc)
+			if !matches && err == nil {
+				continue
+			}
+			if err == nil {
+				err = method.checkArgs(args)
+			}
+			if err != nil {
+				return method, nil, true, err
+			}
+			return method, args, true, nil
+		}
+	}
+	return nil, nil, false, nil
+}
+
+// Match calls f with the name and argument types of the method.
+func (f MatcherFunc) Match(r *http.Request, method Method) ([]any, bool, error) {
+	return f(r, method.Name, method.Args...)
+}
+
+// matchHEAD is like match, but with automatic HEAD handling, matches
+// a HEAD request that matches no method as a GET request, discarding
+// the body written to rw.
+func (sh *structHandler) matchHEAD(rw *responseWriter, r *http.Request) (*methodInfo, []any, bool, error) {
+	method, args, matches, err := sh.match(r)
+	if !matches && r.Method == http.MethodHead && sh.autoHead {
+		get := r.Clone(r.Context())
+		get.Method = http.MethodGet
+		if method, args, matches, err = sh.match(get); matches {
+			rw.discardBody = true
+		}
+	}
+	return method, args, matches, err
+}
+
+// candidates returns the indices of the methods that may match a
+// request to path: those whose routes match it, if the handler has a
+// route index, or else all of them.
+func (sh *structHandler) candidates(path string) []int {
+	if sh.index != nil && path != "*" {
+		return sh.index.lookup(path, sh.strictPaths)
+	}
+	all := make([]int, len(sh.methods))
+	for i := range all {
+		all[i] = i
+	}
+	return all
+}
+
+// checkArgs returns an error if a matcher supplied the wrong number of
+// arguments for the method.
+func (m *methodInfo) checkArgs(args []any) error {
+	if m.variadic() {
+		if len(args) < len(m.argTypes)-1 {
+			err := fmt.Errorf("method %s: expected at least %d arguments from matcher, got %d", m.Name, len(m.argTypes)-1, len(args))
+			return NewError(http.StatusInternalServerError, err)
+		}
+	} else if len(args) != len(m.argTypes) {
+		err := fmt.Errorf("method %s: expected %d arguments from matcher, got %d", m.Name, len(m.argTypes), len(args))
+		return NewError(http.StatusInternalServerError, err)
+	}
+	return nil
+}
+
+// notFound responds to a request that matched no method. The handler
+// set with WithFallbackHandler, if any, serves every such request.
+// Otherwise, if methods match the request's path with other HTTP
+// methods, the response is a 405 listing them in the Allow header.
+// Otherwise, the response is a 404 written by the handler set with
+// WithNotFoundHandler, or else the ErrorEncoder set with
+// WithErrorEncoder, or else http.NotFound.
+func (sh *structHandler) notFound(w http.ResponseWriter, r *http.Request) {
+	if sh.fallback != nil {
+		sh.fallback.ServeHTTP(w, r)
 		return
 	}
 
-	last := out[len(out)-1]
-	if last.Type().Implements(errorType) {
-		if !last.IsNil() {
-			code := http.StatusInternalServerError
-			var statusCoder HTTPStatusCoder
-			if errors.As(last.Interface().(error), &statusCoder) {
-				code = statusCoder.HTTPStatusCode()
+	allow := sh.allowedVerbs(r)
+	if len(allow) > 0 && !slices.Contains(allow, r.Method) {
+		w.Header().Set("Allow", strings.Join(allow, ", "))
+		switch {
+		case r.Method == http.MethodOptions && sh.discoverMethods:
+			w.WriteHeader(http.StatusNoContent)
+		case sh.encodeNotFound:
+			sh.writeError(w, r, NewError(http.StatusMethodNotAllowed, errors.New("method not allowed")))
+		default:
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	switch {
+	case sh.notFoundHandler != nil:
+		sh.notFoundHandler.ServeHTTP(w, r)
+	case sh.encodeNotFound:
+		sh.writeError(w, r, NewError(http.StatusNotFound, errors.New("not found")))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// allowedVerbs returns the HTTP methods accepted for r's path, in the
+// order of probeVerbs, followed by any others in lexical order. The
+// methods accepted by DefaultMatcherFunc and QueryMatcherFunc are
+// derived from the routes of the methods whose paths match; other
+// matchers are consulted only with WithMatcherProbing, by probing
+// them with each of probeVerbs. For the server-wide "OPTIONS *"
+// request, every method's route matches. With automatic HEAD
+// handling, HEAD is allowed wherever GET is.
+func (sh *structHandler) allowedVerbs(r *http.Request) []string {
+	allowed := make(map[string]bool)
+	candidates := sh.candidates(r.URL.Path)
+	for _, matcher := range sh.matchers {
+		if isDefaultMatcher(matcher) {
+			query := reflect.ValueOf(matcher).Pointer() == reflect.ValueOf(QueryMatcherFunc).Pointer()
+			for _, i := range candidates {
+				sh.routeVerbs(&sh.methods[i], r.URL.Path, query, allowed)
+			}
+			continue
+		}
+		if !sh.probeMatchers {
+			continue
+		}
+		for _, verb := range probeVerbs {
+			if !allowed[verb] && sh.probe(r, matcher, candidates, verb) {
+				allowed[verb] = true
 			}
-			writeError(w, last.Interface().(error), code)
+		}
+	}
+	if sh.autoHead && allowed[http.MethodGet] {
+		allowed[http.MethodHead] = true
+	}
+
+	var allow []string
+	for _, verb := range probeVerbs {
+		if allowed[verb] {
+			allow = append(allow, verb)
+			delete(allowed, verb)
+		}
+	}
+	return append(allow, sortedKeys(allowed)...)
+}
 
+// routeVerbs adds to allowed the HTTP methods for which
+// DefaultMatcherFunc, or QueryMatcherFunc if query is true, matches a
+// request to path for method, as determined by its route and
+// arguments, without calling the matcher.
+func (sh *structHandler) routeVerbs(method *methodInfo, path string, query bool, allowed map[string]bool) {
+	e := sh.endpoint(method.Name)
+	if path != "*" {
+		if _, ok := e.matchPath(path, sh.strictPaths, sh.foldPaths); !ok {
 			return
 		}
+	}
+
+	args := method.argTypes
+	if n := len(args); n > 0 && isBodyReader(args[n-1]) {
+		args = args[:n-1]
+	}
+	if len(args) <= 1 || sh.positional(e, args) {
+		allowed[e.verb] = true
+	}
+
+	// QueryMatcherFunc matches GET requests itself unless it defers
+	// to DefaultMatcherFunc, as for positional arguments.
+	args = method.argTypes
+	switch {
+	case !query:
+	case len(args) == 0:
+		allowed[http.MethodGet] = true
+	case sh.positional(e, args) && !sh.isNested(args[0]):
+	case len(args) == 1 && (isStructType(args[0]) || isList(reflect.New(args[0]).Elem())):
+		allowed[http.MethodGet] = true
+	}
+}
+
+// probe reports whether matcher matches a request like r, but with
+// the given HTTP method and an empty body, to any of the candidate
+// methods.
+func (sh *structHandler) probe(r *http.Request, matcher Matcher, candidates []int, verb string) bool {
+	probe := r.Clone(r.Context())
+	probe.Method = verb
+	probe.Body = http.NoBody
+	probe.ContentLength = 0
+
+	for _, i := range candidates {
+		method := &sh.methods[i]
+		if r.URL.Path == "*" {
+			probe.URL.Path = sh.endpoint(method.Name).path
+		}
+		if _, matches, _ := matcher.Match(probe, method.desc); matches {
+			return true
+		}
+	}
+	return false
+}
+
+// status returns the status code for a successful response, which is
+// def unless overridden with WithSuccessStatus.
+func (m *methodInfo) status(def int) int {
+	if m.successStatus != 0 {
+		return m.successStatus
+	}
+	return def
+}
+
+// countQueryParams returns the number of parameters in a raw query
+// string without parsing it.
+func countQueryParams(rawQuery string) int {
+	n := 0
+	for rawQuery != "" {
+		var param string
+		param, rawQuery, _ = strings.Cut(rawQuery, "&")
+		if param != "" {
+			n++
+		}
+	}
+	return n
+}
+
+// variadic reports whether the method's final argument is variadic
+// and supplied by the matcher.
+func (m *methodInfo) variadic() bool {
+	return m.Type.IsVariadic() && len(m.argTypes) > 0 && m.argTypes[len(m.argTypes)-1] == m.Type.In(m.Type.NumIn()-1)
+}
+
+// variadicArg returns the slice to pass as the method's variadic
+// argument. The remaining matcher arguments are either a single value
+// of the slice type, such as a decoded JSON array, or the individual
+// elements.
+func (m *methodInfo) variadicArg(args []any) (reflect.Value, error) {
+	sliceType := m.Type.In(m.Type.NumIn() - 1)
+	if len(args) == 1 && args[0] != nil && reflect.TypeOf(args[0]).AssignableTo(sliceType) {
+		return reflect.ValueOf(args[0]), nil
+	}
+
+	// index of the first variadic argument, excluding the receiver
+	first := m.Type.NumIn() - 2
+	slice := reflect.MakeSlice(sliceType, len(args), len(args))
+	for i, arg := range args {
+		v := argValue(arg, sliceType.Elem())
+		if !v.IsValid() || !v.Type().AssignableTo(sliceType.Elem()) {
+			return reflect.Value{}, m.argError(first+i, sliceType.Elem(), v)
+		}
+		slice.Index(i).Set(v)
+	}
+	return slice, nil
+}
+
+// checkArg returns an error if v cannot be passed as the method's
+// i-th input, counting the receiver as input 0.
+func (m *methodInfo) checkArg(i int, v reflect.Value) error {
+	want := m.Type.In(i)
+	if v.IsValid() && v.Type().AssignableTo(want) {
+		return nil
+	}
+	return m.argError(i-1, want, v)
+}
+
+// argError returns the error for an argument value that does not match
+// the type of the method's argument at index, excluding the receiver.
+func (m *methodInfo) argError(index int, want reflect.Type, got reflect.Value) error {
+	gotType := "nil"
+	if got.IsValid() {
+		gotType = got.Type().String()
+	}
+	err := fmt.Errorf("method %s: argument %d expected %s, got %s", m.Name, index, want, gotType)
+	return NewError(http.StatusInternalServerError, err)
+}
+
+// argValue returns the reflect.Value of an argument of type t. A nil
+// argument is the zero value of t if t can be nil, or the invalid
+// Value otherwise.
+func argValue(v any, t reflect.Type) reflect.Value {
+	if v == nil {
+		switch t.Kind() {
+		case reflect.Pointer, reflect.Interface, reflect.Map, reflect.Slice, reflect.Func, reflect.Chan:
+			return reflect.Zero(t)
+		}
+	}
+	return reflect.ValueOf(v)
+}
+
+// injected reports whether arguments of the given type are supplied
+// by the handler rather than the matcher.
+func (sh *structHandler) injected(typ reflect.Type) bool {
+	if typ == ctxType || typ == reqType || typ == rwType {
+		return true
+	}
+	_, ok := sh.providers[typ]
+	return ok
+}
+
+// writeResponse writes the values returned by a method. It returns
+// an error if the result could not be encoded, in which case a 500
+// response has been written instead.
+func (sh *structHandler) writeResponse(w http.ResponseWriter, r *http.Request, method *methodInfo, out []reflect.Value) error {
+	if len(out) == 0 {
+		sh.writeEmpty(w, method)
+		return nil
+	}
+
+	last := out[len(out)-1]
+	if last.Type().Implements(errorType) {
+		if !last.IsNil() {
+			sh.writeError(w, r, last.Interface().(error))
+			return nil
+		}
 		if len(out) == 1 {
-			w.WriteHeader(http.StatusNoContent)
-			return
+			sh.writeEmpty(w, method)
+			return nil
+		}
+		if len(out) == 3 && !out[1].Bool() {
+			sh.writeAbsent(w, r)
+			return nil
 		}
 	}
 
-	// special case for returning []byte
-	if bytes, ok := out[0].Interface().([]byte); ok {
-		_, _ = w.Write(bytes)
-		return
+	if stream, ok := ndjsonStream(out[0]); ok && sh.ndjson && method.contentType == "" && sh.acceptsNDJSON(r) {
+		return writeNDJSON(w, r, stream, method.status(http.StatusOK), sh.maxRespBytes, sh.marshalJSON)
+	}
+	if sh.sse && isEventStream(out[0]) {
+		return writeEvents(w, r, out[0], sh.marshalJSON)
+	}
+
+	if reader, ok := out[0].Interface().(io.Reader); ok && !isNil(reader) {
+		return sh.writeStream(w, method, reader)
+	}
+
+	if method.contentType != "" {
+		w.Header().Set("Content-Type", method.contentType)
+		if !isJSONMediaType(method.contentType) {
+			return sh.writeResult(w, r, method.status(http.StatusOK), textBody(out[0]))
+		}
+	}
+
+	// byte slices are written as is rather than encoded
+	if raw, ok := out[0].Interface().(json.RawMessage); ok {
+		w.Header().Set("Content-Type", "application/json")
+		return sh.writeResult(w, r, method.status(http.StatusOK), raw)
+	}
+	if bytes, ok := byteSlice(out[0].Interface()); ok {
+		if contentType := resultContentType(out[0].Interface()); contentType != "" {
+			w.Header().Set("Content-Type", contentType)
+		} else if w.Header().Get("Content-Type") == "" {
+			w.Header().Set("Content-Type", http.DetectContentType(bytes))
+		}
+		return sh.writeResult(w, r, method.status(http.StatusOK), bytes)
+	}
+	if text, ok := sh.plainText(out[0].Interface()); ok {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		return sh.writeResult(w, r, method.status(http.StatusOK), []byte(text))
 	}
 
 	// encode the first return value
-	if err := json.NewEncoder(w).Encode(out[0].Interface()); err != nil {
-		panic(err)
+	result := out[0].Interface()
+	if sh.encodeFunc != nil && method.contentType == "" {
+		return sh.writeEncoded(w, r, result)
+	}
+	codec := sh.negotiate(r)
+	if method.contentType != "" {
+		codec = sh.defaultCodec()
+	}
+	var buf bytes.Buffer
+	err := codec.encode(&buf, result)
+	if errors.Is(err, errors.ErrUnsupported) {
+		codec = sh.defaultCodec()
+		buf.Reset()
+		err = codec.encode(&buf, result)
+	}
+	if err != nil {
+		sh.writeError(w, r, errors.New("failed to encode response"))
+		return err
+	}
+	if method.contentType == "" {
+		if len(sh.negotiable()) > 1 {
+			w.Header().Add("Vary", "Accept")
+		}
+		w.Header().Set("Content-Type", codec.contentType)
 	}
+	return sh.writeResult(w, r, method.status(multiStatusCode(result)), buf.Bytes())
 }
 
-func writeError(w http.ResponseWriter, err error, code int) {
-	// JSON encode the error
-	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("X-Content-Type-Options", "nosniff")
+// writeEncoded writes the response for result with the function set
+// by WithResponseEncoderFunc, or a 500 error if it fails before
+// writing anything.
+func (sh *structHandler) writeEncoded(w http.ResponseWriter, r *http.Request, result any) error {
+	err := sh.encodeFunc(w, r, result)
+	if err == nil {
+		return nil
+	}
+	if rw, ok := w.(*responseWriter); ok && rw.status == 0 {
+		sh.writeError(w, r, errors.New("failed to encode response"))
+	}
+	return err
+}
+
+// writeResult writes the buffered body of a method's result, or a 500
+// error if it exceeds the limit set by WithMaxResponseBytes.
+func (sh *structHandler) writeResult(w http.ResponseWriter, r *http.Request, code int, body []byte) error {
+	if sh.maxRespBytes > 0 && int64(len(body)) > sh.maxRespBytes {
+		w.Header().Del("Content-Type")
+		sh.writeError(w, r, errors.New("response too large"))
+		return fmt.Errorf("response of %d bytes exceeds limit of %d bytes", len(body), sh.maxRespBytes)
+	}
+	writeBody(w, code, body)
+	return nil
+}
+
+// writeStream copies a result implementing io.Reader to the response,
+// closing it afterwards if it is an io.Closer. The response is
+// truncated at the limit set by WithMaxResponseBytes.
+func (sh *structHandler) writeStream(w http.ResponseWriter, method *methodInfo, reader io.Reader) error {
+	if closer, ok := reader.(io.Closer); ok {
+		defer closer.Close()
+	}
+	contentType := method.contentType
+	if contentType == "" {
+		contentType = resultContentType(reader)
+	}
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(method.status(http.StatusOK))
+	if sh.maxRespBytes > 0 {
+		reader = io.LimitReader(reader, sh.maxRespBytes)
+	}
+	_, err := io.Copy(w, reader)
+	return err
+}
+
+// writeEmpty responds to a successful call of a method without a
+// result: 204 with no body by default, or an empty JSON object with
+// status 200 if WithEmptyObjectResponse is enabled. In either case,
+// the status code can be overridden with WithSuccessStatus.
+func (sh *structHandler) writeEmpty(w http.ResponseWriter, method *methodInfo) {
+	if !sh.emptyObject {
+		w.WriteHeader(method.status(http.StatusNoContent))
+		return
+	}
+	writeBody(w, method.status(http.StatusOK), []byte("{}\n"))
+}
+
+// writeAbsent responds to a lookup that found no value. Error status
+// codes are written with the configured ErrorEncoder.
+func (sh *structHandler) writeAbsent(w http.ResponseWriter, r *http.Request) {
+	code := sh.absentStatus
+	if code >= http.StatusBadRequest {
+		sh.writeError(w, r, NewError(code, errors.New(strings.ToLower(http.StatusText(code)))))
+		return
+	}
 	w.WriteHeader(code)
-	_ = json.NewEncoder(w).Encode(map[string]interface{}{
-		"error": err.Error(),
-	})
+}
+
+// byteSlice returns the contents of v if its dynamic type is []byte or
+// a named type whose underlying type is []byte.
+func byteSlice(v any) ([]byte, bool) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Slice || rv.Type().Elem() != byteType {
+		return nil, false
+	}
+	return rv.Bytes(), true
+}
+
+// ContentTyper is implemented by byte slice and io.Reader results
+// that report the Content-Type of the response they are written as,
+// such as a named byte slice holding an image:
+//
+//	type PNG []byte
+//
+//	func (PNG) ContentType() string { return "image/png" }
+//
+// Other byte slices are written with the Content-Type detected by
+// http.DetectContentType, or application/json for a json.RawMessage,
+// and other io.Readers as application/octet-stream, unless set with
+// WithMethodContentType. An io.Reader is streamed and then closed if
+// it is an io.Closer.
+type ContentTyper interface {
+	ContentType() string
+}
+
+// resultContentType returns the Content-Type reported by v if it is a
+// ContentTyper, or else "".
+func resultContentType(v any) string {
+	if ct, ok := v.(ContentTyper); ok {
+		return ct.ContentType()
+	}
+	return ""
+}
+
+// PlainText is a result written verbatim as the response body with a
+// Content-Type of text/plain rather than encoded as JSON.
+type PlainText string
+
+// plainText returns the text of v if it is a PlainText, a string with
+// WithStringAsPlainText enabled, or a non-nil fmt.Stringer with
+// WithStringerAsText enabled.
+func (sh *structHandler) plainText(v any) (string, bool) {
+	switch v := v.(type) {
+	case PlainText:
+		return string(v), true
+	case string:
+		return v, sh.stringAsText
+	case fmt.Stringer:
+		if sh.stringerAsText && !isNil(v) {
+			return v.String(), true
+		}
+	}
+	return "", false
+}
+
+// isNil reports whether v is nil or holds a nil pointer, map, slice,
+// channel, function, or interface.
+func isNil(v any) bool {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Invalid:
+		return true
+	case reflect.Pointer, reflect.Map, reflect.Slice, reflect.Chan, reflect.Func, reflect.Interface:
+		return rv.IsNil()
+	}
+	return false
+}
+
+// isJSONMediaType reports whether contentType is application/json or
+// a +json structured syntax type.
+func isJSONMediaType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// textBody returns the raw bytes of a []byte or string result, or its
+// default formatting otherwise.
+func textBody(v reflect.Value) []byte {
+	switch {
+	case v.Kind() == reflect.String:
+		return []byte(v.String())
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8:
+		return v.Bytes()
+	default:
+		return []byte(fmt.Sprint(v.Interface()))
+	}
+}
+
+// writeBody writes a response with the given status code and body,
+// omitting the body for status codes that do not permit one.
+func writeBody(w http.ResponseWriter, code int, body []byte) {
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(code)
+	if code == http.StatusNoContent || code == http.StatusNotModified {
+		return
+	}
+	_, _ = w.Write(body)
+}
+
+// writeError writes err with the status code it resolves to using the
+// configured ErrorEncoder.
+func (sh *structHandler) writeError(w http.ResponseWriter, r *http.Request, err error) {
+	sh.errorEncoder(w, r, err, statusCode(err))
+}
+
+// statusCode returns the HTTP status code for err: the value reported
+// by an HTTPStatusCoder in its chain, or 500.
+func statusCode(err error) int {
+	var statusCoder HTTPStatusCoder
+	if errors.As(err, &statusCoder) {
+		return statusCoder.HTTPStatusCode()
+	}
+	return http.StatusInternalServerError
 }
 
 func allowedMethod(typ reflect.Type) bool {
 	out := typ.NumOut()
-	if out > 2 {
+	if out > 3 {
 		return false
 	}
 
@@ -203,5 +1275,9 @@ func allowedMethod(typ reflect.Type) bool {
 		return false
 	}
 
+	if out == 3 && typ.Out(1) != boolType {
+		return false
+	}
+
 	return true
 }
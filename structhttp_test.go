@@ -1,17 +1,28 @@
 package structhttp
 
 import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
 	"context"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
+	"mime/multipart"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 type (
@@ -25,15 +36,56 @@ type (
 		Name string
 	}
 
+	headerArgs struct {
+		Name      string
+		RequestID string `header:"X-Request-ID"`
+		Count     int    `header:"X-Count"`
+		Token     string `header:"Authorization,required"`
+	}
+
+	queryArgs struct {
+		ID     int    `query:"id,required"`
+		Name   string `json:"name"`
+		Active bool
+		Limit  *int
+		Secret string `query:"-"`
+	}
+
+	filterArgs struct {
+		Tags  []string  `query:"tag"`
+		IDs   []int     `query:"id"`
+		Since time.Time `query:"since"`
+		Until *time.Time
+	}
+
+	testUser struct {
+		Name string
+	}
+
+	testContextKey string
+
+	testBlob []byte
+
+	testStringer struct {
+		Name string
+	}
+
+	uploadArgs struct {
+		Title string                `form:"title"`
+		File  *multipart.FileHeader `file:"file,required"`
+	}
+
 	testCase struct {
 		name               string
 		httpMethod         string
 		path               string
 		body               string
+		headers            map[string]string
 		result             any
 		err                error
 		expectedStatusCode int
 		expectedBody       string
+		expectedHeaders    map[string]string
 	}
 )
 
@@ -55,10 +107,117 @@ func (a *app) Inputs(ctx context.Context, param *testArgs) (*testArgs, error) {
 	return param, a.err
 }
 
+func (a *app) Headers(param *headerArgs) (*headerArgs, error) {
+	return param, a.err
+}
+
+func (a *app) NilPointer() (*testArgs, error) {
+	return nil, a.err
+}
+
+func (a *app) WhoAmI(ctx context.Context, user *testUser) (*testUser, error) {
+	return user, a.err
+}
+
+func (a *app) Bulk(items []string) (*MultiStatus, error) {
+	var ms MultiStatus
+	for _, item := range items {
+		if item == "taken" {
+			ms.AddError(NewError(http.StatusConflict, errors.New(item+" already exists")))
+			continue
+		}
+		ms.Add(http.StatusCreated, item)
+	}
+	return &ms, a.err
+}
+
+func (a *app) Baggage(ctx context.Context) map[string]string {
+	return BaggageFromContext(ctx)
+}
+
+func (a *app) Events(ctx context.Context) (<-chan any, error) {
+	if a.err != nil {
+		return nil, a.err
+	}
+	ch := make(chan any)
+	go func() {
+		defer close(ch)
+		for _, v := range a.result.([]any) {
+			select {
+			case ch <- v:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
+
+func (a *app) Sum(nums ...int) (int, error) {
+	sum := 0
+	for _, n := range nums {
+		sum += n
+	}
+	return sum, a.err
+}
+
+func (a *app) ContextValues(ctx context.Context, r *http.Request) ([]any, error) {
+	return []any{ctx.Value(testContextKey("trace")), r.Context().Value(testContextKey("tenant"))}, a.err
+}
+
+func (a *app) Search(args queryArgs) (queryArgs, error) {
+	return args, a.err
+}
+
+func (a *app) Filter(args filterArgs) filterArgs {
+	return args
+}
+
+func (a *app) Lookup(key string) (string, bool, error) {
+	value, ok := a.result.(map[string]string)[key]
+	return value, ok, a.err
+}
+
+func (a *app) ThreeResults() (string, string, error) {
+	return "", "", a.err
+}
+
+func (a *app) Injected(logger *slog.Logger, user *testUser) (string, error) {
+	logger.Info("injected")
+	return user.Name, a.err
+}
+
+func (a *app) Combined(ctx context.Context, param *testArgs, r *http.Request) (*testArgs, error) {
+	if ctx == nil || r == nil {
+		return nil, errors.New("missing injected argument")
+	}
+	param.Name += " via " + r.Method
+	return param, a.err
+}
+
 func (a *app) Bytes() ([]byte, error) {
 	return a.result.([]byte), a.err
 }
 
+func (a *app) Text() (PlainText, error) {
+	return PlainText(a.result.(string)), a.err
+}
+
+func (a *app) Stream() (io.Reader, error) {
+	return strings.NewReader(a.result.(string)), a.err
+}
+
+func (a *app) Label() (fmt.Stringer, error) {
+	if a.result == nil {
+		return nil, a.err
+	}
+	return a.result.(fmt.Stringer), a.err
+}
+
+func (s *testStringer) String() string {
+	return "stringer " + s.Name
+}
+
 func (a *app) GetThing() (any, error) {
 	return a.result, a.err
 }
@@ -67,6 +226,43 @@ func (a *app) TooManyArgs(foo, bar, baz int) error {
 	return a.err
 }
 
+func (a *app) Upload(file multipart.File) (string, error) {
+	b, err := io.ReadAll(file)
+	if err != nil {
+		return "", err
+	}
+	return string(b), a.err
+}
+
+func (a *app) UploadForm(args uploadArgs) (string, error) {
+	return args.Title + ": " + args.File.Filename, a.err
+}
+
+func restMatcherFunc(r *http.Request, methodName string, methodArgs ...reflect.Type) ([]any, bool, error) {
+	switch {
+	case strings.HasPrefix(methodName, "Get"):
+		if r.Method != http.MethodGet {
+			return nil, false, nil
+		}
+
+		if len(methodArgs) == 0 {
+			return nil, true, nil
+		}
+		if len(methodArgs) > 1 {
+			return nil, false, nil
+		}
+		re := regexp.MustCompile(fmt.Sprintf(`^\/%s\/([a-zA-Z0-9_-]+)$`, strings.ToLower(methodName[3:])))
+		m := re.FindStringSubmatch(r.URL.Path)
+		if len(m) != 2 {
+			return nil, false, nil
+		}
+
+		return []any{m[1]}, true, nil
+	}
+
+	return DefaultMatcherFunc(r, methodName, methodArgs...)
+}
+
 func runTests(t *testing.T, testCases []testCase, opts ...Option) {
 	t.Helper()
 
@@ -79,12 +275,20 @@ func runTests(t *testing.T, testCases []testCase, opts ...Option) {
 			if tc.body != "" {
 				req.Body = io.NopCloser(strings.NewReader(tc.body))
 			}
+			for k, v := range tc.headers {
+				req.Header.Set(k, v)
+			}
 			w := httptest.NewRecorder()
 			handler.ServeHTTP(w, req)
 
 			if w.Code != tc.expectedStatusCode {
 				t.Errorf("expected status code %d, got %d", tc.expectedStatusCode, w.Code)
 			}
+			for k, v := range tc.expectedHeaders {
+				if got := w.Header().Get(k); got != v {
+					t.Errorf("expected header %s %q, got %q", k, v, got)
+				}
+			}
 			if tc.expectedStatusCode >= 400 && tc.err != nil {
 				var errMap map[string]any
 				if err := json.Unmarshal(w.Body.Bytes(), &errMap); err != nil {
@@ -164,14 +368,143 @@ func TestHandlerDefault(t *testing.T) {
 			expectedStatusCode: 200,
 			expectedBody:       "{\"ID\":1,\"Name\":\"foo\"}\n",
 		},
+		{
+			name:               "inputs, empty body",
+			httpMethod:         "POST",
+			path:               "/Inputs",
+			body:               "",
+			expectedStatusCode: 200,
+			expectedBody:       "null\n",
+		},
 		{
 			name:               "inputs, malformed request",
 			httpMethod:         "POST",
 			path:               "/Inputs",
+			body:               "{\"ID\":",
+			expectedStatusCode: 400,
+			expectedBody:       "{\"error\":\"failed to decode request body: unexpected EOF\"}\n",
+		},
+		{
+			name:               "inputs, empty body with query",
+			httpMethod:         "POST",
+			path:               "/Inputs?Name=foo",
+			body:               "",
+			expectedStatusCode: 200,
+			expectedBody:       "{\"ID\":0,\"Name\":\"foo\"}\n",
+		},
+		{
+			name:               "non-pointer argument, empty body",
+			httpMethod:         "POST",
+			path:               "/Bulk",
 			body:               "",
 			expectedStatusCode: 400,
 			expectedBody:       "{\"error\":\"failed to decode request body: EOF\"}\n",
 		},
+		{
+			name:               "headers, bound",
+			httpMethod:         "POST",
+			path:               "/Headers",
+			body:               "{\"Name\":\"foo\",\"RequestID\":\"body\"}",
+			headers:            map[string]string{"X-Request-ID": "abc", "X-Count": "3", "Authorization": "secret"},
+			expectedStatusCode: 200,
+			expectedBody:       "{\"Name\":\"foo\",\"RequestID\":\"abc\",\"Count\":3,\"Token\":\"secret\"}\n",
+		},
+		{
+			name:               "headers, missing optional",
+			httpMethod:         "POST",
+			path:               "/Headers",
+			body:               "{\"RequestID\":\"body\"}",
+			headers:            map[string]string{"Authorization": "secret"},
+			expectedStatusCode: 200,
+			expectedBody:       "{\"Name\":\"\",\"RequestID\":\"body\",\"Count\":0,\"Token\":\"secret\"}\n",
+		},
+		{
+			name:               "headers, missing required",
+			httpMethod:         "POST",
+			path:               "/Headers",
+			body:               "{}",
+			expectedStatusCode: 400,
+			expectedBody:       "{\"error\":\"missing required header \\\"Authorization\\\"\"}\n",
+		},
+		{
+			name:               "headers, invalid value",
+			httpMethod:         "POST",
+			path:               "/Headers",
+			body:               "{}",
+			headers:            map[string]string{"X-Count": "three", "Authorization": "secret"},
+			expectedStatusCode: 400,
+			expectedBody:       "{\"error\":\"invalid value for header \\\"X-Count\\\": strconv.ParseInt: parsing \\\"three\\\": invalid syntax\"}\n",
+		},
+		{
+			name:               "nil pointer, no error",
+			httpMethod:         "POST",
+			path:               "/NilPointer",
+			expectedStatusCode: 200,
+			expectedBody:       "null\n",
+		},
+		{
+			name:               "nil pointer, with error",
+			httpMethod:         "POST",
+			path:               "/NilPointer",
+			err:                errors.New("test error"),
+			expectedStatusCode: 500,
+		},
+		{
+			name:               "nil result",
+			httpMethod:         "POST",
+			path:               "/OnlyResult",
+			expectedStatusCode: 200,
+			expectedBody:       "null\n",
+		},
+		{
+			name:               "multi-status",
+			httpMethod:         "POST",
+			path:               "/Bulk",
+			body:               "[\"new\",\"taken\"]",
+			expectedStatusCode: 207,
+			expectedBody:       "{\"items\":[{\"status\":201,\"result\":\"new\"},{\"status\":409,\"error\":\"taken already exists\"}]}\n",
+		},
+		{
+			name:               "variadic, none",
+			httpMethod:         "POST",
+			path:               "/Sum",
+			body:               "[]",
+			expectedStatusCode: 200,
+			expectedBody:       "0\n",
+		},
+		{
+			name:               "variadic, many",
+			httpMethod:         "POST",
+			path:               "/Sum",
+			body:               "[1,2,3]",
+			expectedStatusCode: 200,
+			expectedBody:       "6\n",
+		},
+		{
+			name:               "named byte slice",
+			httpMethod:         "POST",
+			path:               "/OnlyResult",
+			result:             testBlob("foo"),
+			expectedStatusCode: 200,
+			expectedBody:       "foo",
+		},
+		{
+			name:               "raw JSON",
+			httpMethod:         "POST",
+			path:               "/OnlyResult",
+			result:             json.RawMessage(`{"foo":1}`),
+			expectedStatusCode: 200,
+			expectedBody:       "{\"foo\":1}",
+			expectedHeaders:    map[string]string{"Content-Type": "application/json"},
+		},
+		{
+			name:               "slice of structs",
+			httpMethod:         "POST",
+			path:               "/OnlyResult",
+			result:             []testArgs{{ID: 1, Name: "foo"}},
+			expectedStatusCode: 200,
+			expectedBody:       "[{\"ID\":1,\"Name\":\"foo\"}]\n",
+		},
 		{
 			name:               "bytes, no error",
 			httpMethod:         "POST",
@@ -206,30 +539,4500 @@ func TestHandlerCustomMatcher(t *testing.T) {
 		},
 	}
 
-	matcherFunc := func(r *http.Request, methodName string, methodArgs ...reflect.Type) ([]any, bool, error) {
-		switch {
-		case strings.HasPrefix(methodName, "Get"):
-			if r.Method != http.MethodGet {
-				return nil, false, nil
+	runTests(t, testCases, WithMatcherFunc(restMatcherFunc))
+}
+
+func TestHandlerArgProvider(t *testing.T) {
+	testCases := []testCase{
+		{
+			name:               "provided",
+			httpMethod:         "POST",
+			path:               "/WhoAmI",
+			headers:            map[string]string{"X-User": "alice"},
+			expectedStatusCode: 200,
+			expectedBody:       "{\"Name\":\"alice\"}\n",
+		},
+		{
+			name:               "provider error",
+			httpMethod:         "POST",
+			path:               "/WhoAmI",
+			expectedStatusCode: 401,
+			expectedBody:       "{\"error\":\"unauthenticated\"}\n",
+		},
+	}
+
+	provider := func(r *http.Request) (any, error) {
+		name := r.Header.Get("X-User")
+		if name == "" {
+			return nil, NewError(http.StatusUnauthorized, errors.New("unauthenticated"))
+		}
+		return &testUser{Name: name}, nil
+	}
+
+	runTests(t, testCases, WithArgProvider(reflect.TypeOf(&testUser{}), provider))
+}
+
+func TestHandlerProvider(t *testing.T) {
+	testCases := []testCase{
+		{
+			name:               "provided",
+			httpMethod:         "POST",
+			path:               "/WhoAmI",
+			headers:            map[string]string{"X-User": "alice"},
+			expectedStatusCode: 200,
+			expectedBody:       "{\"Name\":\"alice\"}\n",
+		},
+		{
+			name:               "provided nil",
+			httpMethod:         "POST",
+			path:               "/WhoAmI",
+			headers:            map[string]string{"X-User": "-"},
+			expectedStatusCode: 200,
+			expectedBody:       "null\n",
+		},
+		{
+			name:               "provider error",
+			httpMethod:         "POST",
+			path:               "/WhoAmI",
+			expectedStatusCode: 401,
+			expectedBody:       "{\"error\":\"unauthenticated\"}\n",
+		},
+	}
+
+	runTests(t, testCases, WithProvider(func(r *http.Request) (*testUser, error) {
+		switch name := r.Header.Get("X-User"); name {
+		case "":
+			return nil, NewError(http.StatusUnauthorized, errors.New("unauthenticated"))
+		case "-":
+			return nil, nil
+		default:
+			return &testUser{Name: name}, nil
+		}
+	}))
+}
+
+func TestHandlerPreflightMethodDiscovery(t *testing.T) {
+	testCases := []testCase{
+		{
+			name:               "OPTIONS default route",
+			httpMethod:         "OPTIONS",
+			path:               "/NoResult",
+			expectedStatusCode: 204,
+			expectedHeaders:    map[string]string{"Allow": "POST"},
+		},
+		{
+			name:               "OPTIONS unknown route",
+			httpMethod:         "OPTIONS",
+			path:               "/Missing",
+			expectedStatusCode: 404,
+			expectedBody:       "404 page not found\n",
+		},
+	}
+
+	runTests(t, testCases, WithPreflightMethodDiscovery(true))
+
+	testCases = []testCase{
+		{
+			name:               "OPTIONS REST route",
+			httpMethod:         "OPTIONS",
+			path:               "/GetThing",
+			expectedStatusCode: 204,
+			expectedHeaders:    map[string]string{"Allow": "GET, HEAD"},
+		},
+		{
+			name:               "OPTIONS *",
+			httpMethod:         "OPTIONS",
+			path:               "*",
+			expectedStatusCode: 204,
+			expectedHeaders:    map[string]string{"Allow": "GET, HEAD, POST"},
+		},
+	}
+
+	runTests(t, testCases, WithMatcherFunc(restMatcherFunc), WithPreflightMethodDiscovery(true), WithMatcherProbing(true))
+}
+
+func TestHandlerAutomaticOPTIONS(t *testing.T) {
+	testCases := []testCase{
+		{
+			name:               "enabled by default",
+			httpMethod:         "OPTIONS",
+			path:               "/NoResult",
+			expectedStatusCode: 204,
+			expectedHeaders:    map[string]string{"Allow": "POST"},
+		},
+	}
+	runTests(t, testCases)
+
+	testCases = []testCase{
+		{
+			name:               "disabled",
+			httpMethod:         "OPTIONS",
+			path:               "/NoResult",
+			expectedStatusCode: 405,
+			expectedHeaders:    map[string]string{"Allow": "POST"},
+			expectedBody:       "Method Not Allowed\n",
+		},
+	}
+	runTests(t, testCases, WithPreflightMethodDiscovery(false))
+}
+
+type optionsService struct {
+	userService
+
+	Routes struct {
+		Ping string `route:"OPTIONS /users"`
+	}
+}
+
+func TestHandlerOPTIONSRoute(t *testing.T) {
+	handler := Handler(&optionsService{}, WithRESTRouting(true), WithCORS(CORSOptions{AllowedOrigins: []string{"*"}}))
+
+	testCases := []struct {
+		name               string
+		path               string
+		headers            map[string]string
+		expectedStatusCode int
+		expectedAllow      string
+		expectedBody       string
+	}{
+		{"automatic", "/user/7", nil, 204, "GET, HEAD, PUT, DELETE", ""},
+		{"overridden", "/users", nil, 200, "", "\"pong\"\n"},
+		{"preflight overridden", "/users", map[string]string{
+			"Origin":                        "https://example.com",
+			"Access-Control-Request-Method": "POST",
+		}, 200, "", "\"pong\"\n"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("OPTIONS", tc.path, nil)
+			for k, v := range tc.headers {
+				req.Header.Set(k, v)
 			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
 
-			if len(methodArgs) == 0 {
-				return nil, true, nil
+			if w.Code != tc.expectedStatusCode {
+				t.Errorf("expected status code %d, got %d", tc.expectedStatusCode, w.Code)
 			}
-			if len(methodArgs) > 1 {
-				return nil, false, nil
+			if got := w.Header().Get("Allow"); got != tc.expectedAllow {
+				t.Errorf("expected Allow %q, got %q", tc.expectedAllow, got)
 			}
-			re := regexp.MustCompile(fmt.Sprintf(`^\/%s\/([a-zA-Z0-9_-]+)$`, strings.ToLower(methodName[3:])))
-			m := re.FindStringSubmatch(r.URL.Path)
-			if len(m) != 2 {
-				return nil, false, nil
+			if w.Body.String() != tc.expectedBody {
+				t.Errorf("expected body %q, got %q", tc.expectedBody, w.Body.String())
 			}
+		})
+	}
+}
 
-			return []any{m[1]}, true, nil
-		}
-
-		return DefaultMatcherFunc(r, methodName, methodArgs...)
+func TestHandlerMatcherArgumentCount(t *testing.T) {
+	testCases := []testCase{
+		{
+			name:               "too few arguments",
+			httpMethod:         "POST",
+			path:               "/Inputs",
+			expectedStatusCode: 500,
+			expectedBody:       "{\"error\":\"method Inputs: expected 1 arguments from matcher, got 0\"}\n",
+		},
+		{
+			name:               "too many arguments",
+			httpMethod:         "POST",
+			path:               "/NoResult",
+			expectedStatusCode: 500,
+			expectedBody:       "{\"error\":\"method NoResult: expected 0 arguments from matcher, got 1\"}\n",
+		},
+	}
+
+	matcherFunc := func(r *http.Request, methodName string, methodArgs ...reflect.Type) ([]any, bool, error) {
+		if r.URL.Path != "/"+methodName {
+			return nil, false, nil
+		}
+		if methodName == "NoResult" {
+			return []any{1}, true, nil
+		}
+		return nil, true, nil
 	}
 
 	runTests(t, testCases, WithMatcherFunc(matcherFunc))
 }
+
+func TestHandlerLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	handler := Handler(&app{result: func() {}}, WithLogger(logger))
+
+	for _, path := range []string{"/NoResult", "/Missing", "/Inputs", "/OnlyResult"} {
+		req := httptest.NewRequest("POST", path, strings.NewReader("{"))
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	for _, want := range []string{
+		`level=DEBUG msg="matched method" method=NoResult path=/NoResult`,
+		`level=DEBUG msg="served request" method=NoResult path=/NoResult status=204`,
+		`level=DEBUG msg="no method matched" path=/Missing`,
+		`level=DEBUG msg="served request" method="" path=/Missing status=404`,
+		`level=ERROR msg="failed to match request" method=Inputs path=/Inputs`,
+		`level=ERROR msg="failed to encode response" method=OnlyResult path=/OnlyResult`,
+		`level=DEBUG msg="served request" method=OnlyResult path=/OnlyResult status=500`,
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("expected log to contain %q, got:\n%s", want, buf.String())
+		}
+	}
+}
+
+type panicService struct{}
+
+func (panicService) Panic() error {
+	panic("boom")
+}
+
+func TestHandlerLoggerPanic(t *testing.T) {
+	var buf bytes.Buffer
+	var got []Observation
+	handler := Handler(panicService{},
+		WithLogger(slog.New(slog.NewTextHandler(&buf, nil))),
+		WithObserver(func(r *http.Request, o Observation) {
+			got = append(got, o)
+		}),
+	)
+
+	func() {
+		defer func() {
+			if v := recover(); v != "boom" {
+				t.Errorf("expected the panic to be re-raised, got %v", v)
+			}
+		}()
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/Panic", nil))
+	}()
+
+	want := `level=ERROR msg="panic serving request" method=Panic path=/Panic status=500 panic=boom`
+	if !strings.Contains(buf.String(), want) {
+		t.Errorf("expected log to contain %q, got:\n%s", want, buf.String())
+	}
+	if len(got) != 1 || got[0].Method != "Panic" || got[0].StatusCode != 500 {
+		t.Errorf("expected a 500 observation of Panic, got %+v", got)
+	}
+}
+
+func TestHandlerContextBaggage(t *testing.T) {
+	testCases := []testCase{
+		{
+			name:               "baggage",
+			httpMethod:         "POST",
+			path:               "/Baggage",
+			headers:            map[string]string{"Baggage": "userId=alice, serverNode=DF%2028;prop=1,invalid"},
+			expectedStatusCode: 200,
+			expectedBody:       "{\"serverNode\":\"DF 28\",\"userId\":\"alice\"}\n",
+		},
+		{
+			name:               "no baggage",
+			httpMethod:         "POST",
+			path:               "/Baggage",
+			expectedStatusCode: 200,
+			expectedBody:       "null\n",
+		},
+	}
+
+	runTests(t, testCases, WithContextBaggage(true))
+}
+
+type paramsService struct {
+	Routes struct {
+		Post string `route:"GET /users/{id}/posts/{post}"`
+	}
+}
+
+func (paramsService) Post(ctx context.Context) map[string]string { return PathParams(ctx) }
+func (paramsService) Ping(ctx context.Context) bool              { return PathParams(ctx) == nil }
+
+func TestHandlerPathParams(t *testing.T) {
+	var seen string
+	handler := Handler(paramsService{}, WithContextFunc(func(ctx context.Context, r *http.Request) context.Context {
+		seen = PathParams(ctx)["id"]
+		return ctx
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/users/7/posts/3", nil))
+	if want := "{\"id\":\"7\",\"post\":\"3\"}\n"; w.Code != 200 || w.Body.String() != want {
+		t.Errorf("expected 200 with body %q, got %d with body %q", want, w.Code, w.Body.String())
+	}
+	if seen != "7" {
+		t.Errorf("expected context function to see id 7, got %q", seen)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/Ping", nil))
+	if w.Body.String() != "true\n" {
+		t.Errorf("expected no path params for a route without variables, got %q", w.Body.String())
+	}
+}
+
+func TestHandlerSSE(t *testing.T) {
+	testCases := []testCase{
+		{
+			name:               "events",
+			httpMethod:         "POST",
+			path:               "/Events",
+			result:             []any{1, "two\nlines", map[string]int{"three": 3}},
+			expectedStatusCode: 200,
+			expectedBody:       "data: 1\n\ndata: two\ndata: lines\n\ndata: {\"three\":3}\n\n",
+			expectedHeaders:    map[string]string{"Content-Type": "text/event-stream"},
+		},
+		{
+			name:               "events with carriage returns",
+			httpMethod:         "POST",
+			path:               "/Events",
+			result:             []any{"x\rid: 999", "y\r\nevent: admin\r"},
+			expectedStatusCode: 200,
+			expectedBody:       "data: x\ndata: id: 999\n\ndata: y\ndata: event: admin\ndata: \n\n",
+		},
+		{
+			name:               "events, with error",
+			httpMethod:         "POST",
+			path:               "/Events",
+			err:                errors.New("test error"),
+			expectedStatusCode: 500,
+		},
+	}
+
+	runTests(t, testCases, WithSSE(true))
+}
+
+func TestHandlerSSEClientDisconnect(t *testing.T) {
+	handler := Handler(&app{result: []any{1, 2, 3}}, WithSSE(true))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req := httptest.NewRequest("POST", "/Events", nil).WithContext(ctx)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Code != 200 {
+		t.Errorf("expected status code 200, got %d", w.Code)
+	}
+}
+
+// tickService streams ticks on an unbuffered channel without watching
+// its context, closing done once it has sent them all.
+type tickService struct {
+	done chan struct{}
+}
+
+func (s tickService) Ticks() <-chan int {
+	ch := make(chan int)
+	go func() {
+		defer close(s.done)
+		defer close(ch)
+		for i := 0; i < 3; i++ {
+			ch <- i
+		}
+	}()
+	return ch
+}
+
+func TestHandlerStreamDrainedAfterDisconnect(t *testing.T) {
+	for _, accept := range []string{"text/event-stream", NDJSONContentType} {
+		s := tickService{done: make(chan struct{})}
+		handler := Handler(s, WithSSE(true), WithNDJSON(true))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		req := httptest.NewRequest("POST", "/Ticks", nil).WithContext(ctx)
+		req.Header.Set("Accept", accept)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+
+		select {
+		case <-s.done:
+		case <-time.After(5 * time.Second):
+			t.Errorf("%s: expected the channel to be drained after the client disconnected", accept)
+		}
+	}
+}
+
+func TestHandlerNDJSON(t *testing.T) {
+	ndjson := map[string]string{"Accept": NDJSONContentType}
+	testCases := []testCase{
+		{
+			name:               "slice",
+			httpMethod:         "POST",
+			path:               "/OnlyResult",
+			headers:            ndjson,
+			result:             []any{1, "two\nlines", map[string]int{"three": 3}},
+			expectedStatusCode: 200,
+			expectedBody:       "1\n\"two\\nlines\"\n{\"three\":3}\n",
+			expectedHeaders:    map[string]string{"Content-Type": NDJSONContentType},
+		},
+		{
+			name:               "channel",
+			httpMethod:         "POST",
+			path:               "/Events",
+			headers:            ndjson,
+			result:             []any{1, "two"},
+			expectedStatusCode: 200,
+			expectedBody:       "1\n\"two\"\n",
+			expectedHeaders:    map[string]string{"Content-Type": NDJSONContentType},
+		},
+		{
+			name:               "empty slice",
+			httpMethod:         "POST",
+			path:               "/OnlyResult",
+			headers:            ndjson,
+			result:             []int{},
+			expectedStatusCode: 200,
+			expectedBody:       "",
+			expectedHeaders:    map[string]string{"Content-Type": NDJSONContentType},
+		},
+		{
+			name:               "not requested",
+			httpMethod:         "POST",
+			path:               "/OnlyResult",
+			result:             []int{1, 2},
+			expectedStatusCode: 200,
+			expectedBody:       "[1,2]\n",
+			expectedHeaders:    map[string]string{"Content-Type": "application/json"},
+		},
+		{
+			name:               "JSON preferred",
+			httpMethod:         "POST",
+			path:               "/OnlyResult",
+			headers:            map[string]string{"Accept": "application/json, application/x-ndjson;q=0.5"},
+			result:             []int{1, 2},
+			expectedStatusCode: 200,
+			expectedBody:       "[1,2]\n",
+			expectedHeaders:    map[string]string{"Content-Type": "application/json"},
+		},
+		{
+			name:               "not a sequence",
+			httpMethod:         "POST",
+			path:               "/OnlyResult",
+			headers:            ndjson,
+			result:             map[string]int{"one": 1},
+			expectedStatusCode: 200,
+			expectedBody:       "{\"one\":1}\n",
+			expectedHeaders:    map[string]string{"Content-Type": "application/json"},
+		},
+		{
+			name:               "with error",
+			httpMethod:         "POST",
+			path:               "/Events",
+			headers:            ndjson,
+			err:                errors.New("test error"),
+			expectedStatusCode: 500,
+		},
+	}
+
+	runTests(t, testCases, WithNDJSON(true))
+}
+
+func TestHandlerNDJSONMaxResponseBytes(t *testing.T) {
+	handler := Handler(&app{result: []string{"aaaa", "bbbb", "cccc"}}, WithNDJSON(true), WithMaxResponseBytes(16))
+
+	req := httptest.NewRequest("POST", "/OnlyResult", nil)
+	req.Header.Set("Accept", NDJSONContentType)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Code != 200 || w.Body.String() != "\"aaaa\"\n\"bbbb\"\n" {
+		t.Errorf("expected 200 with the lines within the limit, got %d with %q", w.Code, w.Body.String())
+	}
+}
+
+func TestHandlerResponseEncoderFunc(t *testing.T) {
+	envelope := func(w http.ResponseWriter, r *http.Request, result any) error {
+		if _, ok := result.(chan int); ok {
+			return errors.New("unsupported result")
+		}
+		w.Header().Set("Content-Type", "application/vnd.envelope+json")
+		w.Header().Set("X-Method", r.URL.Path)
+		w.WriteHeader(http.StatusAccepted)
+		return json.NewEncoder(w).Encode(map[string]any{"data": result})
+	}
+	testCases := []testCase{
+		{
+			name:               "envelope",
+			httpMethod:         "POST",
+			path:               "/OnlyResult",
+			result:             map[string]string{"foo": "bar"},
+			expectedStatusCode: 202,
+			expectedBody:       "{\"data\":{\"foo\":\"bar\"}}\n",
+			expectedHeaders: map[string]string{
+				"Content-Type": "application/vnd.envelope+json",
+				"X-Method":     "/OnlyResult",
+			},
+		},
+		{
+			name:               "encoder error",
+			httpMethod:         "POST",
+			path:               "/OnlyResult",
+			result:             make(chan int),
+			expectedStatusCode: 500,
+			expectedBody:       "{\"error\":\"failed to encode response\"}\n",
+		},
+		{
+			name:               "method error",
+			httpMethod:         "POST",
+			path:               "/ErrorAndResult",
+			err:                errors.New("test error"),
+			expectedStatusCode: 500,
+			expectedBody:       "{\"error\":\"test error\"}\n",
+		},
+		{
+			name:               "bytes written as is",
+			httpMethod:         "POST",
+			path:               "/Bytes",
+			result:             []byte("foo"),
+			expectedStatusCode: 200,
+			expectedBody:       "foo",
+		},
+	}
+
+	runTests(t, testCases, WithResponseEncoderFunc(envelope))
+}
+
+func TestHandlerSuccessStatus(t *testing.T) {
+	testCases := []testCase{
+		{
+			name:               "body",
+			httpMethod:         "POST",
+			path:               "/OnlyResult",
+			result:             map[string]string{"foo": "bar"},
+			expectedStatusCode: 201,
+			expectedBody:       "{\"foo\":\"bar\"}\n",
+		},
+		{
+			name:               "no body",
+			httpMethod:         "POST",
+			path:               "/NoResult",
+			expectedStatusCode: 202,
+		},
+		{
+			name:               "body suppressed",
+			httpMethod:         "POST",
+			path:               "/ErrorAndResult",
+			result:             map[string]string{"foo": "bar"},
+			expectedStatusCode: 204,
+		},
+		{
+			name:               "error unaffected",
+			httpMethod:         "POST",
+			path:               "/OnlyError",
+			err:                errors.New("test error"),
+			expectedStatusCode: 500,
+		},
+		{
+			name:               "unconfigured method",
+			httpMethod:         "POST",
+			path:               "/Bytes",
+			result:             []byte("foo"),
+			expectedStatusCode: 200,
+			expectedBody:       "foo",
+		},
+	}
+
+	runTests(t, testCases, WithSuccessStatus(map[string]int{
+		"OnlyResult":     201,
+		"NoResult":       202,
+		"ErrorAndResult": 204,
+		"OnlyError":      200,
+	}))
+}
+
+func TestHandlerBodyKey(t *testing.T) {
+	testCases := []testCase{
+		{
+			name:               "nested",
+			httpMethod:         "POST",
+			path:               "/Inputs",
+			body:               "{\"data\":{\"ID\":1,\"Name\":\"x\"}}",
+			expectedStatusCode: 200,
+			expectedBody:       "{\"ID\":1,\"Name\":\"x\"}\n",
+		},
+		{
+			name:               "missing key",
+			httpMethod:         "POST",
+			path:               "/Inputs",
+			body:               "{\"ID\":1,\"Name\":\"x\"}",
+			expectedStatusCode: 400,
+			expectedBody:       "{\"error\":\"missing \\\"data\\\" in request body\"}\n",
+		},
+		{
+			name:               "other method",
+			httpMethod:         "POST",
+			path:               "/Headers",
+			body:               "{\"Name\":\"x\"}",
+			headers:            map[string]string{"Authorization": "secret"},
+			expectedStatusCode: 200,
+			expectedBody:       "{\"Name\":\"x\",\"RequestID\":\"\",\"Count\":0,\"Token\":\"secret\"}\n",
+		},
+	}
+
+	runTests(t, testCases, WithBodyKey(map[string]string{"Inputs": "data"}))
+}
+
+func TestHandlerStrictJSON(t *testing.T) {
+	testCases := []testCase{
+		{
+			name:               "known fields",
+			httpMethod:         "POST",
+			path:               "/Inputs",
+			body:               "{\"ID\":1,\"Name\":\"x\"}",
+			expectedStatusCode: 200,
+			expectedBody:       "{\"ID\":1,\"Name\":\"x\"}\n",
+		},
+		{
+			name:               "unknown field",
+			httpMethod:         "POST",
+			path:               "/Inputs",
+			body:               "{\"ID\":1,\"Nmae\":\"x\"}",
+			expectedStatusCode: 400,
+			expectedBody:       "{\"error\":\"failed to decode request body: json: unknown field \\\"Nmae\\\"\"}\n",
+		},
+		{
+			name:               "trailing data",
+			httpMethod:         "POST",
+			path:               "/Inputs",
+			body:               "{\"ID\":1} {}",
+			expectedStatusCode: 400,
+			expectedBody:       "{\"error\":\"failed to decode request body: unexpected data after JSON value\"}\n",
+		},
+		{
+			name:               "trailing whitespace",
+			httpMethod:         "POST",
+			path:               "/Inputs",
+			body:               "{\"ID\":1}\n",
+			expectedStatusCode: 200,
+			expectedBody:       "{\"ID\":1,\"Name\":\"\"}\n",
+		},
+		{
+			name:               "body key",
+			httpMethod:         "POST",
+			path:               "/Headers",
+			body:               "{\"meta\":{},\"data\":{\"Nmae\":\"x\"}}",
+			expectedStatusCode: 400,
+			expectedBody:       "{\"error\":\"failed to decode \\\"data\\\" in request body: json: unknown field \\\"Nmae\\\"\"}\n",
+		},
+	}
+
+	runTests(t, testCases, WithStrictJSON(true), WithBodyKey(map[string]string{"Headers": "data"}))
+}
+
+type importService struct {
+	Routes struct {
+		Import string `consumes:"text/csv, application/json"`
+		Raw    string `consumes:""`
+	}
+}
+
+func (importService) Create(args *testArgs) *testArgs { return args }
+
+func (importService) Import(body io.Reader) (string, error) {
+	b, err := io.ReadAll(body)
+	return string(b), err
+}
+
+func (importService) Raw(body io.Reader) (string, error) {
+	b, err := io.ReadAll(body)
+	return string(b), err
+}
+
+func TestHandlerRequiredContentTypes(t *testing.T) {
+	testCases := []struct {
+		testCase
+		opts []Option
+	}{
+		{
+			testCase: testCase{
+				name:               "json",
+				path:               "/Create",
+				body:               "{\"ID\":1}",
+				headers:            map[string]string{"Content-Type": "application/json; charset=utf-8"},
+				expectedStatusCode: 200,
+				expectedBody:       "{\"ID\":1,\"Name\":\"\"}\n",
+			},
+			opts: []Option{WithRequiredContentTypes()},
+		},
+		{
+			testCase: testCase{
+				name:               "json-based type",
+				path:               "/Create",
+				body:               "{\"ID\":1}",
+				headers:            map[string]string{"Content-Type": "application/merge-patch+json"},
+				expectedStatusCode: 200,
+				expectedBody:       "{\"ID\":1,\"Name\":\"\"}\n",
+			},
+			opts: []Option{WithRequiredContentTypes()},
+		},
+		{
+			testCase: testCase{
+				name:               "unsupported type",
+				path:               "/Create",
+				body:               "{\"ID\":1}",
+				headers:            map[string]string{"Content-Type": "text/plain"},
+				expectedStatusCode: 415,
+				expectedBody:       "{\"error\":\"unsupported content type \\\"text/plain\\\": expected application/json\"}\n",
+			},
+			opts: []Option{WithRequiredContentTypes()},
+		},
+		{
+			testCase: testCase{
+				name:               "missing type",
+				path:               "/Create",
+				body:               "{\"ID\":1}",
+				expectedStatusCode: 415,
+				expectedBody:       "{\"error\":\"missing content type: expected application/json\"}\n",
+			},
+			opts: []Option{WithRequiredContentTypes()},
+		},
+		{
+			testCase: testCase{
+				name:               "empty body",
+				path:               "/Create",
+				expectedStatusCode: 200,
+				expectedBody:       "null\n",
+			},
+			opts: []Option{WithRequiredContentTypes()},
+		},
+		{
+			testCase: testCase{
+				name:               "configured types",
+				path:               "/Create",
+				body:               "ID=1",
+				headers:            map[string]string{"Content-Type": "application/x-www-form-urlencoded"},
+				expectedStatusCode: 200,
+				expectedBody:       "{\"ID\":1,\"Name\":\"\"}\n",
+			},
+			opts: []Option{WithRequiredContentTypes("application/json", "application/*")},
+		},
+		{
+			testCase: testCase{
+				name:               "consumes tag",
+				path:               "/Import",
+				body:               "a,b\n",
+				headers:            map[string]string{"Content-Type": "text/csv"},
+				expectedStatusCode: 200,
+				expectedBody:       "\"a,b\\n\"\n",
+			},
+			opts: []Option{WithRequiredContentTypes()},
+		},
+		{
+			testCase: testCase{
+				name:               "consumes tag without option",
+				path:               "/Import",
+				body:               "a,b\n",
+				headers:            map[string]string{"Content-Type": "text/plain"},
+				expectedStatusCode: 415,
+				expectedBody:       "{\"error\":\"unsupported content type \\\"text/plain\\\": expected text/csv, application/json\"}\n",
+			},
+		},
+		{
+			testCase: testCase{
+				name:               "empty consumes tag",
+				path:               "/Raw",
+				body:               "raw",
+				headers:            map[string]string{"Content-Type": "text/plain"},
+				expectedStatusCode: 200,
+				expectedBody:       "\"raw\"\n",
+			},
+			opts: []Option{WithRequiredContentTypes()},
+		},
+		{
+			testCase: testCase{
+				name:               "not enforced",
+				path:               "/Create",
+				body:               "{\"ID\":1}",
+				headers:            map[string]string{"Content-Type": "text/plain"},
+				expectedStatusCode: 200,
+				expectedBody:       "{\"ID\":1,\"Name\":\"\"}\n",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", tc.path, strings.NewReader(tc.body))
+			for name, value := range tc.headers {
+				req.Header.Set(name, value)
+			}
+			w := httptest.NewRecorder()
+			Handler(importService{}, tc.opts...).ServeHTTP(w, req)
+			if w.Code != tc.expectedStatusCode || w.Body.String() != tc.expectedBody {
+				t.Errorf("expected %d with body %q, got %d with body %q", tc.expectedStatusCode, tc.expectedBody, w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+// compress returns s compressed with the given Content-Encoding.
+func compress(t *testing.T, encoding, s string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	if encoding == "deflate" {
+		w = zlib.NewWriter(&buf)
+	} else {
+		w = gzip.NewWriter(&buf)
+	}
+	if _, err := io.WriteString(w, s); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestHandlerRequestDecompression(t *testing.T) {
+	body := "{\"ID\":1,\"Name\":\"" + strings.Repeat("x", 100) + "\"}"
+	testCases := []testCase{
+		{
+			name:               "gzip",
+			httpMethod:         "POST",
+			path:               "/Inputs",
+			body:               compress(t, "gzip", body),
+			headers:            map[string]string{"Content-Encoding": "gzip"},
+			expectedStatusCode: 200,
+			expectedBody:       body + "\n",
+		},
+		{
+			name:               "deflate",
+			httpMethod:         "POST",
+			path:               "/Inputs",
+			body:               compress(t, "deflate", body),
+			headers:            map[string]string{"Content-Encoding": "deflate"},
+			expectedStatusCode: 200,
+			expectedBody:       body + "\n",
+		},
+		{
+			name:               "uncompressed",
+			httpMethod:         "POST",
+			path:               "/Inputs",
+			body:               body,
+			expectedStatusCode: 200,
+			expectedBody:       body + "\n",
+		},
+		{
+			name:               "malformed",
+			httpMethod:         "POST",
+			path:               "/Inputs",
+			body:               body,
+			headers:            map[string]string{"Content-Encoding": "gzip"},
+			expectedStatusCode: 400,
+			expectedBody:       "{\"error\":\"failed to decode request body: gzip: invalid header\"}\n",
+		},
+		{
+			name:               "too large",
+			httpMethod:         "POST",
+			path:               "/Inputs",
+			body:               compress(t, "gzip", "{\"Name\":\""+strings.Repeat("x", 1000)+"\"}"),
+			headers:            map[string]string{"Content-Encoding": "gzip"},
+			expectedStatusCode: 413,
+			expectedBody:       "{\"error\":\"failed to decode request body: http: request body too large\"}\n",
+		},
+	}
+
+	runTests(t, testCases, WithRequestDecompression(true), WithMaxDecompressedBytes(512))
+}
+
+func TestHandlerResponseEncoder(t *testing.T) {
+	result := testArgs{ID: 1, Name: "x"}
+	jsonBody := "{\"ID\":1,\"Name\":\"x\"}\n"
+	xmlBody := "<testArgs><ID>1</ID><Name>x</Name></testArgs>\n"
+	testCases := []testCase{
+		{
+			name:               "no Accept header",
+			httpMethod:         "POST",
+			path:               "/OnlyResult",
+			result:             result,
+			expectedStatusCode: 200,
+			expectedHeaders:    map[string]string{"Content-Type": "application/json", "Vary": "Accept"},
+			expectedBody:       jsonBody,
+		},
+		{
+			name:               "registered type",
+			httpMethod:         "POST",
+			path:               "/OnlyResult",
+			result:             result,
+			headers:            map[string]string{"Accept": "application/xml"},
+			expectedStatusCode: 200,
+			expectedHeaders:    map[string]string{"Content-Type": "application/xml", "Vary": "Accept"},
+			expectedBody:       xmlBody,
+		},
+		{
+			name:               "quality",
+			httpMethod:         "POST",
+			path:               "/OnlyResult",
+			result:             result,
+			headers:            map[string]string{"Accept": "application/xml;q=0.5, application/json"},
+			expectedStatusCode: 200,
+			expectedHeaders:    map[string]string{"Content-Type": "application/json"},
+			expectedBody:       jsonBody,
+		},
+		{
+			name:               "wildcard subtype",
+			httpMethod:         "POST",
+			path:               "/OnlyResult",
+			result:             result,
+			headers:            map[string]string{"Accept": "text/*, application/json;q=0.1"},
+			expectedStatusCode: 200,
+			expectedHeaders:    map[string]string{"Content-Type": "text/plain; charset=utf-8"},
+			expectedBody:       "{1 x}",
+		},
+		{
+			name:               "equally acceptable",
+			httpMethod:         "POST",
+			path:               "/OnlyResult",
+			result:             result,
+			headers:            map[string]string{"Accept": "application/xml, */*"},
+			expectedStatusCode: 200,
+			expectedHeaders:    map[string]string{"Content-Type": "application/xml"},
+			expectedBody:       xmlBody,
+		},
+		{
+			name:               "any type",
+			httpMethod:         "POST",
+			path:               "/OnlyResult",
+			result:             result,
+			headers:            map[string]string{"Accept": "*/*"},
+			expectedStatusCode: 200,
+			expectedHeaders:    map[string]string{"Content-Type": "application/json"},
+			expectedBody:       jsonBody,
+		},
+		{
+			name:               "unacceptable",
+			httpMethod:         "POST",
+			path:               "/OnlyResult",
+			result:             result,
+			headers:            map[string]string{"Accept": "image/png"},
+			expectedStatusCode: 200,
+			expectedHeaders:    map[string]string{"Content-Type": "application/json"},
+			expectedBody:       jsonBody,
+		},
+		{
+			name:               "encoding failure",
+			httpMethod:         "POST",
+			path:               "/OnlyResult",
+			result:             map[string]int{"a": 1},
+			headers:            map[string]string{"Accept": "application/xml"},
+			expectedStatusCode: 500,
+			expectedBody:       "{\"error\":\"failed to encode response\"}\n",
+		},
+	}
+
+	runTests(t, testCases,
+		WithResponseEncoder("application/xml", XMLResponseEncoder),
+		WithResponseEncoder("text/plain; charset=utf-8", func(w io.Writer, v any) error {
+			_, err := fmt.Fprint(w, v)
+			return err
+		}),
+	)
+
+	runTests(t, []testCase{
+		{
+			name:               "JSON only",
+			httpMethod:         "POST",
+			path:               "/OnlyResult",
+			result:             result,
+			headers:            map[string]string{"Accept": "application/xml"},
+			expectedStatusCode: 200,
+			expectedHeaders:    map[string]string{"Content-Type": "application/json", "Vary": ""},
+			expectedBody:       jsonBody,
+		},
+	})
+}
+
+func TestHandlerCodecs(t *testing.T) {
+	testCases := []testCase{
+		{
+			name:               "request and response",
+			httpMethod:         "POST",
+			path:               "/Inputs",
+			body:               "<testArgs><ID>1</ID><Name>x</Name></testArgs>",
+			headers:            map[string]string{"Content-Type": "application/xml", "Accept": "application/xml"},
+			expectedStatusCode: 200,
+			expectedHeaders:    map[string]string{"Content-Type": "application/xml", "Vary": "Accept"},
+			expectedBody:       "<testArgs><ID>1</ID><Name>x</Name></testArgs>\n",
+		},
+		{
+			name:               "encode only",
+			httpMethod:         "POST",
+			path:               "/Inputs",
+			body:               "ID,Name\r\n1,x\r\n",
+			headers:            map[string]string{"Content-Type": "text/csv"},
+			expectedStatusCode: 415,
+			expectedBody:       "{\"error\":\"unsupported content type \\\"text/csv\\\" for argument of type *structhttp.testArgs\"}\n",
+		},
+		{
+			name:               "declined",
+			httpMethod:         "POST",
+			path:               "/Inputs",
+			body:               "{\"ID\":1,\"Name\":\"x\"}",
+			headers:            map[string]string{"Content-Type": "application/json", "Accept": "text/csv"},
+			expectedStatusCode: 200,
+			expectedHeaders:    map[string]string{"Content-Type": "application/json"},
+			expectedBody:       "{\"ID\":1,\"Name\":\"x\"}\n",
+		},
+	}
+
+	runTests(t, testCases, WithCodecs(XMLCodec, CSVCodec))
+}
+
+func TestHandlerXML(t *testing.T) {
+	testCases := []testCase{
+		{
+			name:               "XML request and response",
+			httpMethod:         "POST",
+			path:               "/Inputs",
+			body:               "<testArgs><ID>1</ID><Name>x</Name></testArgs>",
+			headers:            map[string]string{"Content-Type": "application/xml", "Accept": "application/xml"},
+			expectedStatusCode: 200,
+			expectedHeaders:    map[string]string{"Content-Type": "application/xml"},
+			expectedBody:       "<testArgs><ID>1</ID><Name>x</Name></testArgs>\n",
+		},
+		{
+			name:               "XML request, JSON response",
+			httpMethod:         "POST",
+			path:               "/Inputs",
+			body:               "<args><ID>2</ID></args>",
+			headers:            map[string]string{"Content-Type": "text/xml; charset=utf-8"},
+			expectedStatusCode: 200,
+			expectedHeaders:    map[string]string{"Content-Type": "application/json"},
+			expectedBody:       "{\"ID\":2,\"Name\":\"\"}\n",
+		},
+		{
+			name:               "JSON request, XML response",
+			httpMethod:         "POST",
+			path:               "/Inputs",
+			body:               "{\"ID\":3}",
+			headers:            map[string]string{"Accept": "application/xml"},
+			expectedStatusCode: 200,
+			expectedBody:       "<testArgs><ID>3</ID><Name></Name></testArgs>\n",
+		},
+		{
+			name:               "malformed XML",
+			httpMethod:         "POST",
+			path:               "/Inputs",
+			body:               "<testArgs><ID>x</ID></testArgs>",
+			headers:            map[string]string{"Content-Type": "application/xml"},
+			expectedStatusCode: 400,
+			expectedBody:       "{\"error\":\"failed to decode request body: strconv.ParseInt: parsing \\\"x\\\": invalid syntax\"}\n",
+		},
+	}
+
+	runTests(t, testCases, WithXML(true))
+
+	runTests(t, []testCase{
+		{
+			name:               "disabled",
+			httpMethod:         "POST",
+			path:               "/Inputs",
+			body:               "<testArgs><ID>1</ID></testArgs>",
+			headers:            map[string]string{"Content-Type": "application/xml", "Accept": "application/xml"},
+			expectedStatusCode: 400,
+			expectedBody:       "{\"error\":\"failed to decode request body: invalid character '\\u003c' looking for beginning of value\"}\n",
+		},
+	})
+}
+
+// wireMessage stands in for proto.Message in tests of codecs for
+// types that support them.
+type wireMessage interface {
+	MarshalWire() []byte
+	UnmarshalWire([]byte) error
+}
+
+type wireArgs struct{ Name string }
+
+func (a *wireArgs) MarshalWire() []byte { return []byte("wire:" + a.Name) }
+
+func (a *wireArgs) UnmarshalWire(b []byte) error {
+	name, ok := strings.CutPrefix(string(b), "wire:")
+	if !ok {
+		return errors.New("bad wire message")
+	}
+	a.Name = name
+	return nil
+}
+
+type wireService struct{}
+
+func (wireService) Echo(args *wireArgs) *wireArgs { return args }
+func (wireService) Plain(args testArgs) testArgs  { return args }
+
+func TestHandlerCustomCodecs(t *testing.T) {
+	const wire = "application/x-wire"
+	handler := Handler(wireService{},
+		WithRequestDecoder(wire, func(r io.Reader, v any) error {
+			m, ok := v.(wireMessage)
+			if !ok {
+				return errors.ErrUnsupported
+			}
+			b, err := io.ReadAll(r)
+			if err != nil {
+				return err
+			}
+			return m.UnmarshalWire(b)
+		}),
+		WithResponseEncoder(wire, func(w io.Writer, v any) error {
+			m, ok := v.(wireMessage)
+			if !ok {
+				return errors.ErrUnsupported
+			}
+			_, err := w.Write(m.MarshalWire())
+			return err
+		}),
+	)
+
+	testCases := []struct {
+		name, path, contentType, accept, body string
+		expectedStatusCode                    int
+		expectedContentType, expectedBody     string
+	}{
+		{"wire in and out", "/Echo", wire, wire, "wire:x", 200, wire, "wire:x"},
+		{"wire in, JSON out", "/Echo", wire + "; charset=binary", "", "wire:x", 200, "application/json", "{\"Name\":\"x\"}\n"},
+		{"JSON in, wire out", "/Echo", "application/json", wire, "{\"Name\":\"y\"}", 200, wire, "wire:y"},
+		{"malformed", "/Echo", wire, "", "x", 400, "application/json", "{\"error\":\"failed to decode request body: bad wire message\"}\n"},
+		{"unsupported argument", "/Plain", wire, "", "wire:x", 415, "application/json", "{\"error\":\"unsupported content type \\\"application/x-wire\\\" for argument of type structhttp.testArgs\"}\n"},
+		{"unsupported result", "/Plain", "application/json", wire, "{\"ID\":1}", 200, "application/json", "{\"ID\":1,\"Name\":\"\"}\n"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", tc.path, strings.NewReader(tc.body))
+			req.Header.Set("Content-Type", tc.contentType)
+			if tc.accept != "" {
+				req.Header.Set("Accept", tc.accept)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			if w.Code != tc.expectedStatusCode || w.Body.String() != tc.expectedBody {
+				t.Errorf("expected %d with body %q, got %d with body %q", tc.expectedStatusCode, tc.expectedBody, w.Code, w.Body.String())
+			}
+			if got := w.Header().Get("Content-Type"); got != tc.expectedContentType {
+				t.Errorf("expected Content-Type %q, got %q", tc.expectedContentType, got)
+			}
+		})
+	}
+}
+
+func TestHandlerMethodContentType(t *testing.T) {
+	testCases := []testCase{
+		{
+			name:               "html",
+			httpMethod:         "POST",
+			path:               "/OnlyResult",
+			result:             "<p>hello</p>",
+			expectedStatusCode: 200,
+			expectedBody:       "<p>hello</p>",
+			expectedHeaders:    map[string]string{"Content-Type": "text/html; charset=utf-8"},
+		},
+		{
+			name:               "text from bytes",
+			httpMethod:         "POST",
+			path:               "/Bytes",
+			result:             []byte("foo"),
+			expectedStatusCode: 200,
+			expectedBody:       "foo",
+			expectedHeaders:    map[string]string{"Content-Type": "text/plain"},
+		},
+		{
+			name:               "json",
+			httpMethod:         "POST",
+			path:               "/ErrorAndResult",
+			result:             map[string]string{"foo": "bar"},
+			expectedStatusCode: 200,
+			expectedBody:       "{\"foo\":\"bar\"}\n",
+			expectedHeaders:    map[string]string{"Content-Type": "application/vnd.api+json"},
+		},
+		{
+			name:               "error",
+			httpMethod:         "POST",
+			path:               "/ErrorAndResult",
+			err:                errors.New("test error"),
+			expectedStatusCode: 500,
+			expectedHeaders:    map[string]string{"Content-Type": "application/json"},
+		},
+	}
+
+	runTests(t, testCases, WithMethodContentType(map[string]string{
+		"OnlyResult":     "text/html; charset=utf-8",
+		"Bytes":          "text/plain",
+		"ErrorAndResult": "application/vnd.api+json",
+	}))
+}
+
+func TestHandlerVariadicMatcherArgs(t *testing.T) {
+	testCases := []testCase{
+		{
+			name:               "zero",
+			httpMethod:         "GET",
+			path:               "/Sum",
+			expectedStatusCode: 200,
+			expectedBody:       "0\n",
+		},
+		{
+			name:               "one",
+			httpMethod:         "GET",
+			path:               "/Sum/4",
+			expectedStatusCode: 200,
+			expectedBody:       "4\n",
+		},
+		{
+			name:               "many",
+			httpMethod:         "GET",
+			path:               "/Sum/1/2/3",
+			expectedStatusCode: 200,
+			expectedBody:       "6\n",
+		},
+	}
+
+	matcherFunc := func(r *http.Request, methodName string, methodArgs ...reflect.Type) ([]any, bool, error) {
+		segments := strings.Split(strings.TrimPrefix(r.URL.Path, "/"), "/")
+		if r.Method != http.MethodGet || segments[0] != methodName {
+			return nil, false, nil
+		}
+		var args []any
+		for _, segment := range segments[1:] {
+			n, err := strconv.Atoi(segment)
+			if err != nil {
+				return nil, true, NewError(http.StatusBadRequest, err)
+			}
+			args = append(args, n)
+		}
+		return args, true, nil
+	}
+
+	runTests(t, testCases, WithMatcherFunc(matcherFunc))
+}
+
+func TestHandlerContextFunc(t *testing.T) {
+	testCases := []testCase{
+		{
+			name:               "composed",
+			httpMethod:         "POST",
+			path:               "/ContextValues",
+			headers:            map[string]string{"X-Tenant": "acme"},
+			expectedStatusCode: 200,
+			expectedBody:       "[\"trace-acme\",\"acme\"]\n",
+		},
+	}
+
+	tenant := func(ctx context.Context, r *http.Request) context.Context {
+		return context.WithValue(ctx, testContextKey("tenant"), r.Header.Get("X-Tenant"))
+	}
+	trace := func(ctx context.Context, r *http.Request) context.Context {
+		return context.WithValue(ctx, testContextKey("trace"), "trace-"+ctx.Value(testContextKey("tenant")).(string))
+	}
+
+	runTests(t, testCases, WithContextFunc(tenant), WithContextFunc(trace))
+}
+
+func TestHandlerMaxQueryParams(t *testing.T) {
+	testCases := []testCase{
+		{
+			name:               "within limit",
+			httpMethod:         "POST",
+			path:               "/NoResult?a=1&b=2&&",
+			expectedStatusCode: 204,
+		},
+		{
+			name:               "over limit",
+			httpMethod:         "POST",
+			path:               "/NoResult?a=1&b=2&c=3",
+			expectedStatusCode: 400,
+			expectedBody:       "{\"error\":\"too many query parameters: limit is 2\"}\n",
+		},
+	}
+
+	runTests(t, testCases, WithMaxQueryParams(2))
+}
+
+func TestHandlerAutomaticHEADAndOPTIONS(t *testing.T) {
+	testCases := []testCase{
+		{
+			name:               "HEAD GET route",
+			httpMethod:         "HEAD",
+			path:               "/thing/1",
+			result:             map[string]string{"id": "1"},
+			expectedStatusCode: 200,
+			expectedBody:       "",
+			expectedHeaders:    map[string]string{"Content-Length": "11"},
+		},
+		{
+			name:               "OPTIONS GET route",
+			httpMethod:         "OPTIONS",
+			path:               "/thing/1",
+			expectedStatusCode: 204,
+			expectedHeaders:    map[string]string{"Allow": "GET, HEAD"},
+		},
+	}
+
+	runTests(t, testCases, WithMatcherFunc(restMatcherFunc), WithAutomaticHEADAndOPTIONS(true), WithMatcherProbing(true))
+
+	testCases = []testCase{
+		{
+			name:               "HEAD disabled",
+			httpMethod:         "HEAD",
+			path:               "/thing/1",
+			expectedStatusCode: 405,
+			expectedHeaders:    map[string]string{"Allow": "GET"},
+			expectedBody:       "Method Not Allowed\n",
+		},
+	}
+
+	runTests(t, testCases, WithMatcherFunc(restMatcherFunc), WithAutomaticHEAD(false), WithMatcherProbing(true))
+
+	testCases = []testCase{
+		{
+			name:               "HEAD by default",
+			httpMethod:         "HEAD",
+			path:               "/thing/1",
+			result:             map[string]string{"id": "1"},
+			expectedStatusCode: 200,
+			expectedBody:       "",
+			expectedHeaders:    map[string]string{"Content-Length": "11"},
+		},
+	}
+
+	runTests(t, testCases, WithMatcherFunc(restMatcherFunc))
+}
+
+func TestHandlerMatcherProbing(t *testing.T) {
+	for _, probing := range []bool{false, true} {
+		verbs := make(map[string]bool)
+		matcher := func(r *http.Request, methodName string, methodArgs ...reflect.Type) ([]any, bool, error) {
+			verbs[r.Method] = true
+			return restMatcherFunc(r, methodName, methodArgs...)
+		}
+		handler := Handler(&app{}, WithMatcherFunc(matcher), WithMatcherProbing(probing))
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("OPTIONS", "/thing/1", nil))
+		if probing && (w.Code != 204 || w.Header().Get("Allow") != "GET, HEAD") {
+			t.Errorf("expected 204 allowing GET and HEAD with probing, got %d allowing %q", w.Code, w.Header().Get("Allow"))
+		}
+		if !probing && (w.Code != 404 || len(verbs) != 1) {
+			t.Errorf("expected 404 from the matcher called only for OPTIONS without probing, got %d after calls for %v", w.Code, verbs)
+		}
+	}
+
+	// The built-in matchers are never probed.
+	testCases := []testCase{
+		{
+			name:               "default matcher",
+			httpMethod:         "OPTIONS",
+			path:               "/NoResult",
+			expectedStatusCode: 204,
+			expectedHeaders:    map[string]string{"Allow": "GET, HEAD, POST"},
+		},
+		{
+			name:               "query matcher",
+			httpMethod:         "DELETE",
+			path:               "/Search",
+			expectedStatusCode: 405,
+			expectedHeaders:    map[string]string{"Allow": "GET, HEAD, POST"},
+			expectedBody:       "Method Not Allowed\n",
+		},
+	}
+	runTests(t, testCases, WithMatchers(DefaultMatcherFunc, QueryMatcherFunc))
+}
+
+func TestHandlerSkipHEADCall(t *testing.T) {
+	s := &counter{}
+	handler := Handler(s,
+		WithMatcherFunc(func(r *http.Request, methodName string, methodArgs ...reflect.Type) ([]any, bool, error) {
+			return nil, r.Method == http.MethodGet && r.URL.Path == "/"+methodName, nil
+		}),
+		WithMethodContentType(map[string]string{"Increment": "text/plain"}),
+		WithSkipHEADCall(true),
+	)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("HEAD", "/Increment", nil))
+	if w.Code != 200 {
+		t.Errorf("expected status code 200, got %d", w.Code)
+	}
+	if got := w.Header().Get("Content-Type"); got != "text/plain" {
+		t.Errorf("expected Content-Type %q, got %q", "text/plain", got)
+	}
+	if w.Body.Len() != 0 {
+		t.Errorf("expected empty body, got %q", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/Increment", nil))
+	if w.Body.String() != "1" {
+		t.Errorf("expected method to be called once, got body %q", w.Body.String())
+	}
+}
+
+func TestHandlerQueryMatcher(t *testing.T) {
+	testCases := []testCase{
+		{
+			name:               "query bound",
+			httpMethod:         "GET",
+			path:               "/Search?id=7&name=foo&Active=true&Limit=10&Secret=x",
+			expectedStatusCode: 200,
+			expectedBody:       "{\"ID\":7,\"name\":\"foo\",\"Active\":true,\"Limit\":10,\"Secret\":\"\"}\n",
+		},
+		{
+			name:               "missing required",
+			httpMethod:         "GET",
+			path:               "/Search?name=foo",
+			expectedStatusCode: 400,
+			expectedBody:       "{\"error\":\"missing required query parameter \\\"id\\\"\"}\n",
+		},
+		{
+			name:               "invalid value",
+			httpMethod:         "GET",
+			path:               "/Search?id=x",
+			expectedStatusCode: 400,
+			expectedBody:       "{\"error\":\"invalid value for query parameter \\\"id\\\": strconv.ParseInt: parsing \\\"x\\\": invalid syntax\"}\n",
+		},
+		{
+			name:               "repeated and text parameters",
+			httpMethod:         "GET",
+			path:               "/Filter?tag=a&tag=b&id=1&id=2&since=2024-01-02T03:04:05Z&Until=2024-02-01T00:00:00Z",
+			expectedStatusCode: 200,
+			expectedBody:       "{\"Tags\":[\"a\",\"b\"],\"IDs\":[1,2],\"Since\":\"2024-01-02T03:04:05Z\",\"Until\":\"2024-02-01T00:00:00Z\"}\n",
+		},
+		{
+			name:               "invalid repeated parameter",
+			httpMethod:         "GET",
+			path:               "/Filter?id=1&id=x",
+			expectedStatusCode: 400,
+			expectedBody:       "{\"error\":\"invalid value for query parameter \\\"id\\\": strconv.ParseInt: parsing \\\"x\\\": invalid syntax\"}\n",
+		},
+		{
+			name:               "no arguments",
+			httpMethod:         "GET",
+			path:               "/NoResult",
+			expectedStatusCode: 204,
+		},
+		{
+			name:               "comma-separated parameters",
+			httpMethod:         "GET",
+			path:               "/Filter?tag=a,b&id=1,2&id=3",
+			expectedStatusCode: 200,
+			expectedBody:       "{\"Tags\":[\"a\",\"b\"],\"IDs\":[1,2,3],\"Since\":\"0001-01-01T00:00:00Z\",\"Until\":null}\n",
+		},
+		{
+			name:               "slice argument",
+			httpMethod:         "GET",
+			path:               "/Sum?nums=1,2&nums=3",
+			expectedStatusCode: 200,
+			expectedBody:       "6\n",
+		},
+		{
+			name:               "slice argument with several parameters",
+			httpMethod:         "GET",
+			path:               "/Sum?a=1&b=2",
+			expectedStatusCode: 200,
+			expectedBody:       "0\n",
+		},
+		{
+			name:               "non-struct argument",
+			httpMethod:         "GET",
+			path:               "/Lookup?key=a",
+			expectedStatusCode: 405,
+			expectedBody:       "Method Not Allowed\n",
+		},
+		{
+			name:               "POST falls back to default",
+			httpMethod:         "POST",
+			path:               "/Inputs",
+			body:               "{\"ID\":1,\"Name\":\"foo\"}",
+			expectedStatusCode: 200,
+			expectedBody:       "{\"ID\":1,\"Name\":\"foo\"}\n",
+		},
+	}
+
+	runTests(t, testCases, WithMatcherFunc(QueryMatcherFunc))
+}
+
+func TestHandlerStrictPaths(t *testing.T) {
+	testCases := []struct {
+		name               string
+		path               string
+		strict             bool
+		expectedStatusCode int
+	}{
+		{name: "canonical path", path: "/NoResult", expectedStatusCode: 204},
+		{name: "slash-less path", path: "NoResult", expectedStatusCode: 204},
+		{name: "canonical path, strict", path: "/NoResult", strict: true, expectedStatusCode: 204},
+		{name: "slash-less path, strict", path: "NoResult", strict: true, expectedStatusCode: 404},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			handler := Handler(&app{}, WithStrictPaths(tc.strict))
+
+			req := httptest.NewRequest("POST", "/", nil)
+			req.URL.Path = tc.path
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if w.Code != tc.expectedStatusCode {
+				t.Errorf("expected status code %d, got %d", tc.expectedStatusCode, w.Code)
+			}
+		})
+	}
+}
+
+func TestHandlerLookup(t *testing.T) {
+	testCases := []testCase{
+		{
+			name:               "present",
+			httpMethod:         "POST",
+			path:               "/Lookup",
+			body:               "\"foo\"",
+			result:             map[string]string{"foo": "bar"},
+			expectedStatusCode: 200,
+			expectedBody:       "\"bar\"\n",
+		},
+		{
+			name:               "absent",
+			httpMethod:         "POST",
+			path:               "/Lookup",
+			body:               "\"baz\"",
+			result:             map[string]string{"foo": "bar"},
+			expectedStatusCode: 404,
+			expectedBody:       "{\"error\":\"not found\"}\n",
+		},
+		{
+			name:               "with error",
+			httpMethod:         "POST",
+			path:               "/Lookup",
+			body:               "\"foo\"",
+			result:             map[string]string{"foo": "bar"},
+			err:                errors.New("test error"),
+			expectedStatusCode: 500,
+		},
+		{
+			name:               "non-bool middle result, no match",
+			httpMethod:         "POST",
+			path:               "/ThreeResults",
+			expectedStatusCode: 404,
+			expectedBody:       "404 page not found\n",
+		},
+	}
+
+	runTests(t, testCases)
+
+	testCases = []testCase{
+		{
+			name:               "absent, custom status",
+			httpMethod:         "POST",
+			path:               "/Lookup",
+			body:               "\"baz\"",
+			result:             map[string]string{},
+			expectedStatusCode: 204,
+		},
+	}
+
+	runTests(t, testCases, WithNilResultStatus(204))
+}
+
+func TestHandlerMatcherFuncs(t *testing.T) {
+	errMatcher := func(r *http.Request, methodName string, methodArgs ...reflect.Type) ([]any, bool, error) {
+		if r.Header.Get("X-Fail") != "" {
+			return nil, false, NewError(http.StatusTeapot, errors.New("matcher failed"))
+		}
+		return nil, false, nil
+	}
+	getOnly := func(r *http.Request, methodName string, methodArgs ...reflect.Type) ([]any, bool, error) {
+		if r.Method != http.MethodGet || r.URL.Path != "/thing/1" || methodName != "GetThing" {
+			return nil, false, nil
+		}
+		return nil, true, nil
+	}
+
+	testCases := []testCase{
+		{
+			name:               "first matcher",
+			httpMethod:         "GET",
+			path:               "/thing/1",
+			result:             "thing",
+			expectedStatusCode: 200,
+			expectedBody:       "\"thing\"\n",
+		},
+		{
+			name:               "second matcher",
+			httpMethod:         "POST",
+			path:               "/GetThing",
+			result:             "thing",
+			expectedStatusCode: 200,
+			expectedBody:       "\"thing\"\n",
+		},
+		{
+			name:               "error stops chain",
+			httpMethod:         "GET",
+			path:               "/thing/1",
+			headers:            map[string]string{"X-Fail": "1"},
+			expectedStatusCode: 418,
+			expectedBody:       "{\"error\":\"matcher failed\"}\n",
+		},
+		{
+			name:               "no match",
+			httpMethod:         "GET",
+			path:               "/GetThing",
+			expectedStatusCode: 405,
+			expectedBody:       "Method Not Allowed\n",
+		},
+	}
+
+	runTests(t, testCases, WithMatcherFuncs(errMatcher, getOnly, DefaultMatcherFunc))
+}
+
+func TestHandlerMatcherArgumentTypes(t *testing.T) {
+	testCases := []testCase{
+		{
+			name:               "wrong type",
+			httpMethod:         "POST",
+			path:               "/Inputs",
+			expectedStatusCode: 500,
+			expectedBody:       "{\"error\":\"method Inputs: argument 1 expected *structhttp.testArgs, got string\"}\n",
+		},
+		{
+			name:               "nil pointer",
+			httpMethod:         "POST",
+			path:               "/Headers",
+			expectedStatusCode: 200,
+			expectedBody:       "null\n",
+		},
+		{
+			name:               "nil non-pointer",
+			httpMethod:         "POST",
+			path:               "/Lookup",
+			expectedStatusCode: 500,
+			expectedBody:       "{\"error\":\"method Lookup: argument 0 expected string, got nil\"}\n",
+		},
+		{
+			name:               "wrong variadic element type",
+			httpMethod:         "POST",
+			path:               "/Sum",
+			expectedStatusCode: 500,
+			expectedBody:       "{\"error\":\"method Sum: argument 1 expected int, got string\"}\n",
+		},
+	}
+
+	matcherFunc := func(r *http.Request, methodName string, methodArgs ...reflect.Type) ([]any, bool, error) {
+		if r.URL.Path != "/"+methodName {
+			return nil, false, nil
+		}
+		switch methodName {
+		case "Inputs":
+			return []any{"wrong"}, true, nil
+		case "Sum":
+			return []any{1, "two"}, true, nil
+		default:
+			return []any{nil}, true, nil
+		}
+	}
+
+	runTests(t, testCases, WithMatcherFunc(matcherFunc))
+}
+
+func TestHandlerMatchers(t *testing.T) {
+	getMatcher := func(r *http.Request, methodName string, methodArgs ...reflect.Type) ([]any, bool, error) {
+		if r.Method != http.MethodGet || r.URL.Path != "/thing" || methodName != "GetThing" {
+			return nil, false, nil
+		}
+		return nil, true, nil
+	}
+	versionMatcher := func(r *http.Request, methodName string, methodArgs ...reflect.Type) ([]any, bool, error) {
+		if r.Method != http.MethodGet || r.URL.Path != "/v1/"+methodName || len(methodArgs) > 0 {
+			return nil, false, nil
+		}
+		return nil, true, nil
+	}
+
+	testCases := []testCase{
+		{
+			name:               "first matcher",
+			httpMethod:         "GET",
+			path:               "/thing",
+			result:             "thing",
+			expectedStatusCode: 200,
+			expectedBody:       "\"thing\"\n",
+		},
+		{
+			name:               "second matcher",
+			httpMethod:         "GET",
+			path:               "/v1/OnlyResult",
+			result:             "bar",
+			expectedStatusCode: 200,
+			expectedBody:       "\"bar\"\n",
+		},
+		{
+			name:               "default matcher",
+			httpMethod:         "POST",
+			path:               "/OnlyResult",
+			result:             "foo",
+			expectedStatusCode: 200,
+			expectedBody:       "\"foo\"\n",
+		},
+	}
+
+	runTests(t, testCases, WithMatchers(getMatcher, versionMatcher))
+}
+
+// versionMatcher is a Matcher serving methods without arguments at
+// GET /{version}/MethodName.
+type versionMatcher struct {
+	version string
+}
+
+func (m versionMatcher) Match(r *http.Request, method Method) ([]any, bool, error) {
+	if r.Method != http.MethodGet || r.URL.Path != "/"+m.version+"/"+method.Name || len(method.Args) > 0 {
+		return nil, false, nil
+	}
+	return nil, true, nil
+}
+
+func TestHandlerMatcher(t *testing.T) {
+	testCases := []testCase{
+		{
+			name:               "match",
+			httpMethod:         "GET",
+			path:               "/v2/OnlyResult",
+			result:             "foo",
+			expectedStatusCode: 200,
+			expectedBody:       "\"foo\"\n",
+		},
+		{
+			name:               "method with arguments",
+			httpMethod:         "GET",
+			path:               "/v2/Inputs",
+			expectedStatusCode: 404,
+			expectedBody:       "404 page not found\n",
+		},
+		{
+			name:               "default route",
+			httpMethod:         "POST",
+			path:               "/OnlyResult",
+			expectedStatusCode: 404,
+			expectedBody:       "404 page not found\n",
+		},
+	}
+
+	runTests(t, testCases, WithMatcher(versionMatcher{version: "v2"}))
+}
+
+type annotatedService struct {
+	Routes struct {
+		Transfer string `route:"/transfer" auth:"admin"`
+	}
+	Docs struct {
+		Transfer string `doc:"Moves funds."`
+	}
+}
+
+func (annotatedService) Transfer(ctx context.Context, from, to string) string {
+	return from + " to " + to
+}
+
+// annotationMatcher is a Matcher serving methods annotated with an
+// auth tag at GET /MethodName, binding their arguments from the query
+// parameters named after them.
+type annotationMatcher struct {
+	methods map[string]Method
+}
+
+func (m annotationMatcher) Match(r *http.Request, method Method) ([]any, bool, error) {
+	if _, ok := method.Tag.Lookup("auth"); !ok || r.Method != http.MethodGet || r.URL.Path != "/"+method.ReflectMethod.Name {
+		return nil, false, nil
+	}
+	m.methods[method.Name] = method
+	args := make([]any, len(method.ArgNames))
+	for i, name := range method.ArgNames {
+		args[i] = r.URL.Query().Get(name)
+	}
+	return args, true, nil
+}
+
+func TestHandlerMethodMetadata(t *testing.T) {
+	m := annotationMatcher{methods: make(map[string]Method)}
+	handler := Handler(annotatedService{},
+		WithMatcher(m),
+		WithParamNames(map[string][]string{"Transfer": {"ctx", "from", "to"}}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/Transfer?from=a&to=b", nil))
+	if w.Code != 200 || w.Body.String() != "\"a to b\"\n" {
+		t.Errorf("expected 200 with body %q, got %d with body %q", "\"a to b\"\n", w.Code, w.Body.String())
+	}
+
+	method := m.methods["Transfer"]
+	if got := method.Tag.Get("doc"); got != "Moves funds." {
+		t.Errorf("expected doc tag %q, got %q", "Moves funds.", got)
+	}
+	if method.ReflectMethod.Type.NumIn() != 4 {
+		t.Errorf("expected reflect.Method with 4 inputs, got %s", method.ReflectMethod.Type)
+	}
+
+	_, err := NewHandler(annotatedService{}, WithParamNames(map[string][]string{"Transfer": {"from", "to"}, "Missing": nil}))
+	want := "WithParamNames: method Transfer has 3 parameters, got 2 names\nWithParamNames: no such method Missing"
+	if err == nil || err.Error() != want {
+		t.Errorf("expected error %q, got %v", want, err)
+	}
+}
+
+func TestHandlerEmptyObjectResponse(t *testing.T) {
+	testCases := []testCase{
+		{
+			name:               "no result",
+			httpMethod:         "POST",
+			path:               "/NoResult",
+			expectedStatusCode: 200,
+			expectedBody:       "{}\n",
+		},
+		{
+			name:               "only error, no error",
+			httpMethod:         "POST",
+			path:               "/OnlyError",
+			expectedStatusCode: 202,
+			expectedBody:       "{}\n",
+		},
+		{
+			name:               "only error, with error",
+			httpMethod:         "POST",
+			path:               "/OnlyError",
+			err:                errors.New("test error"),
+			expectedStatusCode: 500,
+		},
+		{
+			name:               "method with result unaffected",
+			httpMethod:         "POST",
+			path:               "/Baggage",
+			expectedStatusCode: 200,
+			expectedBody:       "null\n",
+		},
+	}
+
+	runTests(t, testCases, WithEmptyObjectResponse(true), WithSuccessStatus(map[string]int{"OnlyError": 202}))
+}
+
+func TestHandlerInjectable(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, nil))
+
+	testCases := []testCase{
+		{
+			name:               "injected",
+			httpMethod:         "POST",
+			path:               "/Injected",
+			headers:            map[string]string{"X-User": "alice"},
+			expectedStatusCode: 200,
+			expectedBody:       "\"alice\"\n",
+		},
+		{
+			name:               "injector error",
+			httpMethod:         "POST",
+			path:               "/Injected",
+			expectedStatusCode: 401,
+			expectedBody:       "{\"error\":\"unauthenticated\"}\n",
+		},
+		{
+			name:               "wrong injected type",
+			httpMethod:         "POST",
+			path:               "/Injected",
+			headers:            map[string]string{"X-User": "mallory"},
+			expectedStatusCode: 500,
+			expectedBody:       "{\"error\":\"method Injected: argument 1 expected *structhttp.testUser, got string\"}\n",
+		},
+	}
+
+	injectLogger := func(r *http.Request) (reflect.Value, error) {
+		return reflect.ValueOf(logger), nil
+	}
+	injectUser := func(r *http.Request) (reflect.Value, error) {
+		switch name := r.Header.Get("X-User"); name {
+		case "":
+			return reflect.Value{}, NewError(http.StatusUnauthorized, errors.New("unauthenticated"))
+		case "mallory":
+			return reflect.ValueOf(name), nil
+		default:
+			return reflect.ValueOf(&testUser{Name: name}), nil
+		}
+	}
+
+	runTests(t, testCases,
+		WithInjectable(reflect.TypeOf(logger), injectLogger),
+		WithInjectable(reflect.TypeOf(&testUser{}), injectUser),
+	)
+
+	if !strings.Contains(buf.String(), "msg=injected") {
+		t.Errorf("expected injected logger to be used, got %q", buf.String())
+	}
+}
+
+func TestHandlerInjectedArgumentsFiltered(t *testing.T) {
+	var gotArgs []reflect.Type
+	matcherFunc := func(r *http.Request, methodName string, methodArgs ...reflect.Type) ([]any, bool, error) {
+		if methodName == "Combined" {
+			gotArgs = methodArgs
+		}
+		return DefaultMatcherFunc(r, methodName, methodArgs...)
+	}
+
+	testCases := []testCase{
+		{
+			name:               "context, body, and request",
+			httpMethod:         "POST",
+			path:               "/Combined",
+			body:               "{\"ID\":1,\"Name\":\"foo\"}",
+			expectedStatusCode: 200,
+			expectedBody:       "{\"ID\":1,\"Name\":\"foo via POST\"}\n",
+		},
+	}
+
+	runTests(t, testCases, WithMatcherFunc(matcherFunc))
+
+	if len(gotArgs) != 1 || gotArgs[0] != reflect.TypeOf(&testArgs{}) {
+		t.Errorf("expected matcher to receive only *testArgs, got %v", gotArgs)
+	}
+}
+
+func TestHandlerCORS(t *testing.T) {
+	testCases := []testCase{
+		{
+			name:       "preflight",
+			httpMethod: "OPTIONS",
+			path:       "/thing/1",
+			headers: map[string]string{
+				"Origin":                         "https://example.com",
+				"Access-Control-Request-Method":  "GET",
+				"Access-Control-Request-Headers": "X-Custom",
+			},
+			expectedStatusCode: 204,
+			expectedHeaders: map[string]string{
+				"Access-Control-Allow-Origin":  "https://example.com",
+				"Access-Control-Allow-Methods": "GET, HEAD",
+				"Access-Control-Allow-Headers": "Authorization",
+				"Access-Control-Max-Age":       "600",
+			},
+		},
+		{
+			name:       "preflight, POST route",
+			httpMethod: "OPTIONS",
+			path:       "/NoResult",
+			headers: map[string]string{
+				"Origin":                        "https://example.com",
+				"Access-Control-Request-Method": "POST",
+			},
+			expectedStatusCode: 204,
+			expectedHeaders: map[string]string{
+				"Access-Control-Allow-Methods": "GET, HEAD, POST",
+			},
+		},
+		{
+			name:       "actual request",
+			httpMethod: "GET",
+			path:       "/thing/1",
+			headers: map[string]string{
+				"Origin": "https://example.com",
+			},
+			result:             "thing",
+			expectedStatusCode: 200,
+			expectedBody:       "\"thing\"\n",
+			expectedHeaders: map[string]string{
+				"Access-Control-Allow-Origin": "https://example.com",
+				"Vary":                        "Origin",
+			},
+		},
+		{
+			name:       "disallowed origin",
+			httpMethod: "GET",
+			path:       "/thing/1",
+			headers: map[string]string{
+				"Origin": "https://evil.example",
+			},
+			result:             "thing",
+			expectedStatusCode: 200,
+			expectedBody:       "\"thing\"\n",
+			expectedHeaders: map[string]string{
+				"Access-Control-Allow-Origin": "",
+			},
+		},
+	}
+
+	runTests(t, testCases, WithMatcherFunc(restMatcherFunc), WithMatcherProbing(true), WithCORS(CORSOptions{
+		AllowedOrigins: []string{"https://example.com"},
+		AllowedHeaders: []string{"Authorization"},
+		MaxAge:         10 * time.Minute,
+	}))
+}
+
+func TestHandlerCORSCredentials(t *testing.T) {
+	testCases := []testCase{
+		{
+			name:               "listed origin",
+			httpMethod:         "GET",
+			path:               "/thing/1",
+			headers:            map[string]string{"Origin": "https://example.com"},
+			result:             "thing",
+			expectedStatusCode: 200,
+			expectedBody:       "\"thing\"\n",
+			expectedHeaders: map[string]string{
+				"Access-Control-Allow-Origin":      "https://example.com",
+				"Access-Control-Allow-Credentials": "true",
+			},
+		},
+		{
+			name:               "wildcard ignored",
+			httpMethod:         "GET",
+			path:               "/thing/1",
+			headers:            map[string]string{"Origin": "https://evil.example"},
+			result:             "thing",
+			expectedStatusCode: 200,
+			expectedBody:       "\"thing\"\n",
+			expectedHeaders: map[string]string{
+				"Access-Control-Allow-Origin":      "",
+				"Access-Control-Allow-Credentials": "",
+			},
+		},
+	}
+
+	cors := WithCORS(CORSOptions{
+		AllowedOrigins:   []string{"*", "https://example.com"},
+		AllowCredentials: true,
+	})
+	runTests(t, testCases, WithMatcherFunc(restMatcherFunc), cors)
+
+	_, err := NewHandler(&app{}, cors)
+	if err == nil || !strings.Contains(err.Error(), "WithCORS: AllowCredentials requires explicit AllowedOrigins") {
+		t.Errorf("expected an error for credentials allowed from any origin, got %v", err)
+	}
+}
+
+func TestHandlerMultipart(t *testing.T) {
+	const form = "--xyz\r\n" +
+		"Content-Disposition: form-data; name=\"title\"\r\n\r\n" +
+		"report\r\n" +
+		"--xyz\r\n" +
+		"Content-Disposition: form-data; name=\"file\"; filename=\"report.txt\"\r\n" +
+		"Content-Type: text/plain\r\n\r\n" +
+		"file contents\r\n" +
+		"--xyz--\r\n"
+	contentType := map[string]string{"Content-Type": "multipart/form-data; boundary=xyz"}
+
+	testCases := []testCase{
+		{
+			name:               "file argument",
+			httpMethod:         "POST",
+			path:               "/Upload",
+			body:               form,
+			headers:            contentType,
+			expectedStatusCode: 200,
+			expectedBody:       "\"file contents\"\n",
+		},
+		{
+			name:               "struct argument",
+			httpMethod:         "POST",
+			path:               "/UploadForm",
+			body:               form,
+			headers:            contentType,
+			expectedStatusCode: 200,
+			expectedBody:       "\"report: report.txt\"\n",
+		},
+		{
+			name:               "missing required file",
+			httpMethod:         "POST",
+			path:               "/UploadForm",
+			body:               "--xyz\r\nContent-Disposition: form-data; name=\"title\"\r\n\r\nreport\r\n--xyz--\r\n",
+			headers:            contentType,
+			expectedStatusCode: 400,
+			expectedBody:       "{\"error\":\"missing required file \\\"file\\\"\"}\n",
+		},
+		{
+			name:               "malformed form",
+			httpMethod:         "POST",
+			path:               "/Upload",
+			body:               "garbage",
+			headers:            contentType,
+			expectedStatusCode: 400,
+			expectedBody:       "{\"error\":\"failed to parse multipart form: multipart: NextPart: EOF\"}\n",
+		},
+	}
+
+	runTests(t, testCases)
+}
+
+func TestHandlerURLEncodedForm(t *testing.T) {
+	contentType := map[string]string{"Content-Type": "application/x-www-form-urlencoded"}
+
+	testCases := []testCase{
+		{
+			name:               "struct argument",
+			httpMethod:         "POST",
+			path:               "/Inputs",
+			body:               "ID=1&name=foo+bar",
+			headers:            contentType,
+			expectedStatusCode: 200,
+			expectedBody:       "{\"ID\":1,\"Name\":\"foo bar\"}\n",
+		},
+		{
+			name:               "query overrides form",
+			httpMethod:         "POST",
+			path:               "/Inputs?ID=2",
+			body:               "ID=1&Name=foo",
+			headers:            contentType,
+			expectedStatusCode: 200,
+			expectedBody:       "{\"ID\":2,\"Name\":\"foo\"}\n",
+		},
+		{
+			name:               "form tags",
+			httpMethod:         "POST",
+			path:               "/UploadForm",
+			body:               "title=report",
+			headers:            contentType,
+			expectedStatusCode: 400,
+			expectedBody:       "{\"error\":\"missing required file \\\"file\\\"\"}\n",
+		},
+		{
+			name:               "invalid value",
+			httpMethod:         "POST",
+			path:               "/Inputs",
+			body:               "ID=one",
+			headers:            contentType,
+			expectedStatusCode: 400,
+			expectedBody:       "{\"error\":\"invalid value for form field \\\"ID\\\": strconv.ParseInt: parsing \\\"one\\\": invalid syntax\"}\n",
+		},
+		{
+			name:               "malformed form",
+			httpMethod:         "POST",
+			path:               "/Inputs",
+			body:               "ID=%zz",
+			headers:            contentType,
+			expectedStatusCode: 400,
+			expectedBody:       "{\"error\":\"failed to parse form: invalid URL escape \\\"%zz\\\"\"}\n",
+		},
+	}
+
+	runTests(t, testCases)
+}
+
+func TestHandlerMaxBodyBytes(t *testing.T) {
+	testCases := []testCase{
+		{
+			name:               "within limit",
+			httpMethod:         "POST",
+			path:               "/Inputs",
+			body:               `{"ID":1,"Name":"foo"}`,
+			expectedStatusCode: 200,
+			expectedBody:       "{\"ID\":1,\"Name\":\"foo\"}\n",
+		},
+		{
+			name:               "over limit",
+			httpMethod:         "POST",
+			path:               "/Inputs",
+			body:               `{"ID":1,"Name":"` + strings.Repeat("x", 64) + `"}`,
+			expectedStatusCode: 413,
+			expectedBody:       "{\"error\":\"failed to decode request body: http: request body too large\"}\n",
+		},
+	}
+
+	runTests(t, testCases, WithMaxBodyBytes(32))
+}
+
+func TestHandlerPlainText(t *testing.T) {
+	testCases := []testCase{
+		{
+			name:               "PlainText result",
+			httpMethod:         "POST",
+			path:               "/Text",
+			result:             "token",
+			expectedStatusCode: 200,
+			expectedHeaders:    map[string]string{"Content-Type": "text/plain; charset=utf-8"},
+			expectedBody:       "token",
+		},
+		{
+			name:               "string result encoded as JSON",
+			httpMethod:         "POST",
+			path:               "/Lookup",
+			body:               `"greeting"`,
+			result:             map[string]string{"greeting": "hello"},
+			expectedStatusCode: 200,
+			expectedBody:       "\"hello\"\n",
+		},
+	}
+	runTests(t, testCases)
+
+	testCases = []testCase{
+		{
+			name:               "string result as text",
+			httpMethod:         "POST",
+			path:               "/Lookup",
+			body:               `"greeting"`,
+			result:             map[string]string{"greeting": "hello"},
+			expectedStatusCode: 200,
+			expectedHeaders:    map[string]string{"Content-Type": "text/plain; charset=utf-8"},
+			expectedBody:       "hello",
+		},
+	}
+	runTests(t, testCases, WithStringAsPlainText(true))
+}
+
+func TestHandlerStream(t *testing.T) {
+	testCases := []testCase{
+		{
+			name:               "reader result",
+			httpMethod:         "POST",
+			path:               "/Stream",
+			result:             "streamed",
+			expectedStatusCode: 200,
+			expectedHeaders:    map[string]string{"Content-Type": "application/octet-stream"},
+			expectedBody:       "streamed",
+		},
+	}
+	runTests(t, testCases)
+}
+
+type pngBytes []byte
+
+func (pngBytes) ContentType() string { return "image/png" }
+
+type csvReader struct {
+	*strings.Reader
+}
+
+func (csvReader) ContentType() string { return "text/csv" }
+
+func TestHandlerContentTyper(t *testing.T) {
+	testCases := []testCase{
+		{
+			name:               "byte slice",
+			httpMethod:         "POST",
+			path:               "/OnlyResult",
+			result:             pngBytes("not really a PNG"),
+			expectedStatusCode: 200,
+			expectedHeaders:    map[string]string{"Content-Type": "image/png"},
+			expectedBody:       "not really a PNG",
+		},
+		{
+			name:               "reader",
+			httpMethod:         "POST",
+			path:               "/OnlyResult",
+			result:             csvReader{strings.NewReader("a,b\r\n")},
+			expectedStatusCode: 200,
+			expectedHeaders:    map[string]string{"Content-Type": "text/csv"},
+			expectedBody:       "a,b\r\n",
+		},
+		{
+			name:               "detected",
+			httpMethod:         "POST",
+			path:               "/Bytes",
+			result:             []byte("\x89PNG\r\n\x1a\n"),
+			expectedStatusCode: 200,
+			expectedHeaders:    map[string]string{"Content-Type": "image/png"},
+			expectedBody:       "\x89PNG\r\n\x1a\n",
+		},
+		{
+			name:               "detected text",
+			httpMethod:         "POST",
+			path:               "/Bytes",
+			result:             []byte("foo"),
+			expectedStatusCode: 200,
+			expectedHeaders:    map[string]string{"Content-Type": "text/plain; charset=utf-8"},
+			expectedBody:       "foo",
+		},
+	}
+	runTests(t, testCases)
+}
+
+func TestHandlerMaxResponseBytes(t *testing.T) {
+	testCases := []testCase{
+		{
+			name:               "within limit",
+			httpMethod:         "POST",
+			path:               "/OnlyResult",
+			result:             []int{1, 2, 3},
+			expectedStatusCode: 200,
+			expectedBody:       "[1,2,3]\n",
+		},
+		{
+			name:               "over limit",
+			httpMethod:         "POST",
+			path:               "/OnlyResult",
+			result:             make([]int, 100),
+			expectedStatusCode: 500,
+			expectedBody:       "{\"error\":\"response too large\"}\n",
+		},
+		{
+			name:               "stream truncated",
+			httpMethod:         "POST",
+			path:               "/Stream",
+			result:             strings.Repeat("x", 100),
+			expectedStatusCode: 200,
+			expectedBody:       strings.Repeat("x", 16),
+		},
+	}
+	runTests(t, testCases, WithMaxResponseBytes(16))
+}
+
+func TestHandlerObserver(t *testing.T) {
+	var got []Observation
+	handler := Handler(&app{}, WithObserver(func(r *http.Request, o Observation) {
+		got = append(got, o)
+	}))
+
+	for _, path := range []string{"/Inputs", "/Missing"} {
+		req := httptest.NewRequest("POST", path, strings.NewReader(`{"ID":1,"Name":"foo"}`))
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	want := []Observation{
+		{Method: "Inputs", StatusCode: 200, RequestBytes: 21, ResponseBytes: 22},
+		{Method: "", StatusCode: 404, RequestBytes: 0, ResponseBytes: 19},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("expected %d observations, got %d", len(want), len(got))
+	}
+	for i := range want {
+		got[i].Duration = 0
+		if got[i] != want[i] {
+			t.Errorf("expected observation %+v, got %+v", want[i], got[i])
+		}
+	}
+}
+
+func TestHandlerInterfaceResults(t *testing.T) {
+	testCases := []testCase{
+		{
+			name:               "nil interface",
+			httpMethod:         "POST",
+			path:               "/Label",
+			expectedStatusCode: 200,
+			expectedBody:       "null\n",
+		},
+		{
+			name:               "typed nil pointer",
+			httpMethod:         "POST",
+			path:               "/Label",
+			result:             (*testStringer)(nil),
+			expectedStatusCode: 200,
+			expectedBody:       "null\n",
+		},
+		{
+			name:               "stringer encoded as JSON",
+			httpMethod:         "POST",
+			path:               "/Label",
+			result:             &testStringer{Name: "foo"},
+			expectedStatusCode: 200,
+			expectedBody:       "{\"Name\":\"foo\"}\n",
+		},
+		{
+			name:               "nil any",
+			httpMethod:         "POST",
+			path:               "/OnlyResult",
+			expectedStatusCode: 200,
+			expectedBody:       "null\n",
+		},
+	}
+	runTests(t, testCases)
+
+	testCases = []testCase{
+		{
+			name:               "stringer as text",
+			httpMethod:         "POST",
+			path:               "/Label",
+			result:             &testStringer{Name: "foo"},
+			expectedStatusCode: 200,
+			expectedHeaders:    map[string]string{"Content-Type": "text/plain; charset=utf-8"},
+			expectedBody:       "stringer foo",
+		},
+		{
+			name:               "typed nil stringer",
+			httpMethod:         "POST",
+			path:               "/Label",
+			result:             (*testStringer)(nil),
+			expectedStatusCode: 200,
+			expectedBody:       "null\n",
+		},
+	}
+	runTests(t, testCases, WithStringerAsText(true))
+}
+
+func TestHandlerNotFound(t *testing.T) {
+	testCases := []testCase{
+		{
+			name:               "default",
+			httpMethod:         "POST",
+			path:               "/Missing",
+			expectedStatusCode: 404,
+			expectedBody:       "404 page not found\n",
+		},
+	}
+	runTests(t, testCases)
+
+	testCases = []testCase{
+		{
+			name:               "error encoder",
+			httpMethod:         "POST",
+			path:               "/Missing",
+			expectedStatusCode: 404,
+			expectedBody:       "{\"error\":\"not found\"}\n",
+		},
+	}
+	runTests(t, testCases, WithErrorEncoder(JSONErrorEncoder))
+
+	notFound := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = io.WriteString(w, "no such method")
+	})
+	testCases = []testCase{
+		{
+			name:               "handler takes precedence",
+			httpMethod:         "POST",
+			path:               "/Missing",
+			expectedStatusCode: 404,
+			expectedBody:       "no such method",
+		},
+	}
+	runTests(t, testCases, WithErrorEncoder(JSONErrorEncoder), WithNotFoundHandler(notFound))
+}
+
+type counter struct {
+	mu sync.Mutex
+	n  int
+}
+
+func (c *counter) Increment() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.n++
+	return c.n
+}
+
+// largeCounter also serves responses too large to store for replay.
+type largeCounter struct {
+	counter
+}
+
+func (c *largeCounter) Large() string {
+	return strings.Repeat("x", c.Increment()<<20)
+}
+
+func TestHandlerIdempotency(t *testing.T) {
+	c := &largeCounter{}
+	scope := func(r *http.Request) string { return r.Header.Get("X-User") }
+	handler := Handler(c, WithIdempotency(NewMemoryIdempotencyStore(), time.Minute, scope))
+
+	serveAs := func(user, path, key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", path, nil)
+		req.Header.Set("X-User", user)
+		if key != "" {
+			req.Header.Set("Idempotency-Key", key)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+	serve := func(key string) *httptest.ResponseRecorder {
+		return serveAs("alice", "/Increment", key)
+	}
+
+	if w := serve("a"); w.Body.String() != "1\n" || w.Header().Get("Idempotent-Replayed") != "" {
+		t.Errorf("expected fresh response 1, got %q", w.Body.String())
+	}
+	if w := serve("a"); w.Body.String() != "1\n" || w.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("expected replayed response 1, got %q", w.Body.String())
+	}
+	if w := serve("b"); w.Body.String() != "2\n" {
+		t.Errorf("expected response 2 for new key, got %q", w.Body.String())
+	}
+	if w := serve(""); w.Body.String() != "3\n" {
+		t.Errorf("expected response 3 without key, got %q", w.Body.String())
+	}
+	if w := serveAs("mallory", "/Increment", "a"); w.Body.String() != "4\n" || w.Header().Get("Idempotent-Replayed") != "" {
+		t.Errorf("expected fresh response 4 for another caller's key, got %q", w.Body.String())
+	}
+	if w := serveAs("", "/Increment", "a"); w.Body.String() != "5\n" {
+		t.Errorf("expected response 5 without a scope, got %q", w.Body.String())
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if w := serve("c"); w.Body.String() != "6\n" {
+				t.Errorf("expected response 6 for concurrent key, got %q", w.Body.String())
+			}
+		}()
+	}
+	wg.Wait()
+
+	for i := 0; i < 2; i++ {
+		if w := serveAs("alice", "/Large", "d"); w.Header().Get("Idempotent-Replayed") != "" {
+			t.Errorf("expected a response over the limit not to be replayed")
+		}
+	}
+	if c.n != 8 {
+		t.Errorf("expected 8 calls, got %d", c.n)
+	}
+
+	if _, err := NewHandler(c, WithIdempotency(NewMemoryIdempotencyStore(), time.Minute, nil)); err == nil || !strings.Contains(err.Error(), "WithIdempotency: scope is required") {
+		t.Errorf("expected an error for a missing scope, got %v", err)
+	}
+}
+
+type (
+	undecodable struct{}
+
+	undecodableArgs struct {
+		Name     string
+		Callback func()
+	}
+
+	customChan chan int
+)
+
+func (undecodable) Run(args undecodableArgs) error { return nil }
+
+func (c *customChan) UnmarshalJSON([]byte) error { return nil }
+
+func (undecodable) Custom(c customChan) error { return nil }
+
+func TestHandlerWithError(t *testing.T) {
+	if _, err := HandlerWithError(&app{}); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+
+	_, err := HandlerWithError(undecodable{})
+	want := "method Run: argument type structhttp.undecodableArgs cannot be decoded from JSON: field Callback: unsupported type func()"
+	if err == nil || err.Error() != want {
+		t.Errorf("expected error %q, got %v", want, err)
+	}
+
+	if _, err := HandlerWithError(undecodable{}, WithMatcherFunc(restMatcherFunc)); err != nil {
+		t.Errorf("expected custom matcher to skip check, got %v", err)
+	}
+}
+
+func TestHandlerJSONIndent(t *testing.T) {
+	testCases := []testCase{
+		{
+			name:               "indented",
+			httpMethod:         "POST",
+			path:               "/Inputs",
+			body:               `{"ID":1,"Name":"foo"}`,
+			expectedStatusCode: 200,
+			expectedBody:       "{\n  \"ID\": 1,\n  \"Name\": \"foo\"\n}\n",
+		},
+		{
+			name:               "over limit after indenting",
+			httpMethod:         "POST",
+			path:               "/OnlyResult",
+			result:             []int{1, 2, 3, 4, 5, 6, 7, 8},
+			expectedStatusCode: 500,
+			expectedBody:       "{\"error\":\"response too large\"}\n",
+		},
+	}
+	runTests(t, testCases, WithJSONIndent("", "  "), WithMaxResponseBytes(40))
+}
+
+func TestHandlerJSONEscapeHTML(t *testing.T) {
+	testCases := []testCase{
+		{
+			name:               "result",
+			httpMethod:         "POST",
+			path:               "/OnlyResult",
+			result:             map[string]string{"html": "<a href=\"?x=1&y=2\">"},
+			expectedStatusCode: 200,
+			expectedBody:       "{\"html\":\"<a href=\\\"?x=1&y=2\\\">\"}\n",
+		},
+		{
+			name:               "events",
+			httpMethod:         "POST",
+			path:               "/Events",
+			result:             []any{[]string{"<b>"}},
+			expectedStatusCode: 200,
+			expectedBody:       "data: [\"<b>\"]\n\n",
+		},
+	}
+	runTests(t, testCases, WithJSONEscapeHTML(false), WithSSE(true))
+
+	runTests(t, []testCase{
+		{
+			name:               "escaped by default",
+			httpMethod:         "POST",
+			path:               "/OnlyResult",
+			result:             "<b>",
+			expectedStatusCode: 200,
+			expectedBody:       "\"\\u003cb\\u003e\"\n",
+		},
+	})
+}
+
+func TestHandlerJSONTrailingNewline(t *testing.T) {
+	testCases := []testCase{
+		{
+			name:               "compact",
+			httpMethod:         "POST",
+			path:               "/Inputs",
+			body:               `{"ID":1,"Name":"<foo>"}`,
+			expectedStatusCode: 200,
+			expectedBody:       "{\"ID\":1,\"Name\":\"\\u003cfoo\\u003e\"}",
+		},
+	}
+	runTests(t, testCases, WithJSONTrailingNewline(false))
+
+	testCases = []testCase{
+		{
+			name:               "indented",
+			httpMethod:         "POST",
+			path:               "/Inputs",
+			body:               `{"ID":1,"Name":"<foo>"}`,
+			expectedStatusCode: 200,
+			expectedBody:       "{\n  \"ID\": 1,\n  \"Name\": \"<foo>\"\n}",
+		},
+		{
+			name:               "error unaffected",
+			httpMethod:         "POST",
+			path:               "/OnlyError",
+			err:                errors.New("test error"),
+			expectedStatusCode: 500,
+		},
+	}
+	runTests(t, testCases, WithJSONTrailingNewline(false), WithJSONIndent("", "  "), WithJSONEscapeHTML(false))
+}
+
+// countingEngine is a JSONEngine that counts its calls, delegating to
+// encoding/json.
+type countingEngine struct {
+	marshals, unmarshals int
+}
+
+func (e *countingEngine) Marshal(v any) ([]byte, error) {
+	e.marshals++
+	return json.Marshal(v)
+}
+
+func (e *countingEngine) Unmarshal(data []byte, v any) error {
+	e.unmarshals++
+	return json.Unmarshal(data, v)
+}
+
+func TestHandlerJSONEngine(t *testing.T) {
+	engine := &countingEngine{}
+	testCases := []testCase{
+		{
+			name:               "request and response",
+			httpMethod:         "POST",
+			path:               "/Inputs",
+			body:               `{"ID":1,"Name":"foo"}`,
+			expectedStatusCode: 200,
+			expectedHeaders:    map[string]string{"Content-Type": "application/json"},
+			expectedBody:       "{\n  \"ID\": 1,\n  \"Name\": \"foo\"\n}\n",
+		},
+		{
+			name:               "empty body",
+			httpMethod:         "POST",
+			path:               "/Inputs",
+			body:               " ",
+			expectedStatusCode: 200,
+			expectedBody:       "null\n",
+		},
+		{
+			name:               "trailing data",
+			httpMethod:         "POST",
+			path:               "/Inputs",
+			body:               `{"ID":1}{"ID":2}`,
+			expectedStatusCode: 400,
+			expectedBody:       "{\"error\":\"failed to decode request body: invalid character '{' after top-level value\"}\n",
+		},
+		{
+			name:               "events",
+			httpMethod:         "POST",
+			path:               "/Events",
+			result:             []any{map[string]int{"one": 1}},
+			expectedStatusCode: 200,
+			expectedBody:       "data: {\"one\":1}\n\n",
+		},
+	}
+	runTests(t, testCases, WithJSONEngine(engine), WithJSONIndent("", "  "), WithSSE(true))
+	if engine.marshals != 3 || engine.unmarshals != 2 {
+		t.Errorf("expected 3 calls to Marshal and 2 to Unmarshal, got %d and %d", engine.marshals, engine.unmarshals)
+	}
+
+	engine = &countingEngine{}
+	runTests(t, []testCase{
+		{
+			name:               "strict",
+			httpMethod:         "POST",
+			path:               "/Inputs",
+			body:               `{"ID":1,"Nmae":"foo"}`,
+			expectedStatusCode: 400,
+			expectedBody:       "{\"error\":\"failed to decode request body: json: unknown field \\\"Nmae\\\"\"}\n",
+		},
+	}, WithJSONEngine(engine), WithStrictJSON(true))
+	if engine.unmarshals != 0 {
+		t.Errorf("expected strict bodies to be decoded by encoding/json, got %d calls to Unmarshal", engine.unmarshals)
+	}
+}
+
+func TestHandlerPreInvoke(t *testing.T) {
+	var gotArgs []any
+	preInvoke := func(ctx context.Context, r *http.Request, methodName string, args []any) error {
+		if methodName != "Inputs" {
+			return nil
+		}
+		gotArgs = args
+		switch args[1].(*testArgs).Name {
+		case "intruder":
+			return errors.New("not the owner")
+		case "missing":
+			return NewError(http.StatusNotFound, errors.New("no such record"))
+		}
+		return nil
+	}
+
+	testCases := []testCase{
+		{
+			name:               "allowed",
+			httpMethod:         "POST",
+			path:               "/Inputs",
+			body:               `{"ID":1,"Name":"owner"}`,
+			expectedStatusCode: 200,
+			expectedBody:       "{\"ID\":1,\"Name\":\"owner\"}\n",
+		},
+		{
+			name:               "forbidden",
+			httpMethod:         "POST",
+			path:               "/Inputs",
+			body:               `{"ID":1,"Name":"intruder"}`,
+			expectedStatusCode: 403,
+			expectedBody:       "{\"error\":\"not the owner\"}\n",
+		},
+		{
+			name:               "status from error",
+			httpMethod:         "POST",
+			path:               "/Inputs",
+			body:               `{"ID":1,"Name":"missing"}`,
+			expectedStatusCode: 404,
+			expectedBody:       "{\"error\":\"no such record\"}\n",
+		},
+		{
+			name:               "other method",
+			httpMethod:         "POST",
+			path:               "/NoResult",
+			expectedStatusCode: 204,
+		},
+	}
+	runTests(t, testCases, WithPreInvoke(preInvoke))
+
+	if len(gotArgs) != 2 {
+		t.Fatalf("expected 2 arguments, got %d", len(gotArgs))
+	}
+	if _, ok := gotArgs[0].(context.Context); !ok {
+		t.Errorf("expected context argument, got %T", gotArgs[0])
+	}
+}
+
+func TestHandlerQueryOverridesBody(t *testing.T) {
+	testCases := []testCase{
+		{
+			name:               "query wins over body",
+			httpMethod:         "POST",
+			path:               "/Inputs?Name=query",
+			body:               `{"ID":1,"Name":"body"}`,
+			expectedStatusCode: 200,
+			expectedBody:       "{\"ID\":1,\"Name\":\"query\"}\n",
+		},
+		{
+			name:               "header wins over query",
+			httpMethod:         "POST",
+			path:               "/Headers?RequestID=query",
+			body:               `{"Name":"foo"}`,
+			headers:            map[string]string{"Authorization": "token", "X-Request-ID": "header"},
+			expectedStatusCode: 200,
+			expectedBody:       "{\"Name\":\"foo\",\"RequestID\":\"header\",\"Count\":0,\"Token\":\"token\"}\n",
+		},
+		{
+			name:               "required query satisfied by body",
+			httpMethod:         "POST",
+			path:               "/Search?name=x",
+			body:               `{"ID":7}`,
+			expectedStatusCode: 200,
+			expectedBody:       "{\"ID\":7,\"name\":\"x\",\"Active\":false,\"Limit\":null,\"Secret\":\"\"}\n",
+		},
+		{
+			name:               "invalid query value",
+			httpMethod:         "POST",
+			path:               "/Inputs?ID=abc",
+			body:               `{"ID":1}`,
+			expectedStatusCode: 400,
+			expectedBody:       "{\"error\":\"invalid value for query parameter \\\"ID\\\": strconv.ParseInt: parsing \\\"abc\\\": invalid syntax\"}\n",
+		},
+	}
+	runTests(t, testCases)
+}
+
+type waiter struct{}
+
+func (waiter) Wait(ctx context.Context) error {
+	<-ctx.Done()
+	return NewError(http.StatusServiceUnavailable, context.Cause(ctx))
+}
+
+func TestHandlerBaseContext(t *testing.T) {
+	base, cancel := context.WithCancelCause(context.Background())
+	handler := Handler(waiter{}, WithBaseContext(base))
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("POST", "/Wait", nil))
+		done <- w
+	}()
+	cancel(errors.New("shutting down"))
+
+	select {
+	case w := <-done:
+		if w.Code != http.StatusServiceUnavailable || w.Body.String() != "{\"error\":\"shutting down\"}\n" {
+			t.Errorf("expected 503 shutting down, got %d %q", w.Code, w.Body.String())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("method did not observe cancellation of the base context")
+	}
+}
+
+type (
+	userService struct{}
+
+	updateUserArgs struct {
+		ID   int `path:"id"`
+		Name string
+	}
+)
+
+func (userService) ListUsers() []string { return []string{"alice", "bob"} }
+
+func (userService) GetUser(id int) (string, error) {
+	return fmt.Sprintf("user %d", id), nil
+}
+
+func (userService) GetUserProfile(args struct{ ID string }) string {
+	return "profile " + args.ID
+}
+
+func (userService) CreateUser(u testUser) testUser { return u }
+
+func (userService) UpdateUser(args updateUserArgs) updateUserArgs { return args }
+
+func (userService) DeleteUser(id int) error { return nil }
+
+func (userService) Ping() string { return "pong" }
+
+func TestHandlerRESTRouting(t *testing.T) {
+	handler := Handler(userService{}, WithRESTRouting(true))
+
+	testCases := []struct {
+		method, path, body string
+		expectedStatusCode int
+		expectedBody       string
+	}{
+		{"GET", "/users", "", 200, "[\"alice\",\"bob\"]\n"},
+		{"GET", "/user/7", "", 200, "\"user 7\"\n"},
+		{"GET", "/user/abc", "", 400, "{\"error\":\"invalid value for path parameter \\\"id\\\": strconv.ParseInt: parsing \\\"abc\\\": invalid syntax\"}\n"},
+		{"GET", "/user-profile/x1", "", 200, "\"profile x1\"\n"},
+		{"POST", "/user", `{"Name":"carol"}`, 200, "{\"Name\":\"carol\"}\n"},
+		{"PUT", "/user/3", `{"ID":9,"Name":"dave"}`, 200, "{\"ID\":3,\"Name\":\"dave\"}\n"},
+		{"DELETE", "/user/3", "", 204, ""},
+		{"POST", "/Ping", "", 200, "\"pong\"\n"},
+		{"POST", "/GetUser", "", 404, "404 page not found\n"},
+		{"GET", "/user", "", 405, "Method Not Allowed\n"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.method+" "+tc.path, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if w.Code != tc.expectedStatusCode {
+				t.Errorf("expected status code %d, got %d", tc.expectedStatusCode, w.Code)
+			}
+			if w.Body.String() != tc.expectedBody {
+				t.Errorf("expected body %q, got %q", tc.expectedBody, w.Body.String())
+			}
+		})
+	}
+}
+
+type taggedService struct {
+	userService
+
+	Routes struct {
+		GetUser    string `route:"GET /users/{id}"`
+		CreateUser string `route:"/users"`
+		Ping       string `route:"get /health"`
+	}
+}
+
+func TestHandlerRouteTags(t *testing.T) {
+	handler := Handler(&taggedService{}, WithRESTRouting(true))
+
+	testCases := []struct {
+		method, path, body string
+		expectedStatusCode int
+		expectedBody       string
+	}{
+		{"GET", "/users/7", "", 200, "\"user 7\"\n"},
+		{"GET", "/user/7", "", 405, "Method Not Allowed\n"},
+		{"POST", "/users", `{"Name":"carol"}`, 200, "{\"Name\":\"carol\"}\n"},
+		{"GET", "/health", "", 200, "\"pong\"\n"},
+		{"GET", "/users", "", 200, "[\"alice\",\"bob\"]\n"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.method+" "+tc.path, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if w.Code != tc.expectedStatusCode {
+				t.Errorf("expected status code %d, got %d", tc.expectedStatusCode, w.Code)
+			}
+			if w.Body.String() != tc.expectedBody {
+				t.Errorf("expected body %q, got %q", tc.expectedBody, w.Body.String())
+			}
+		})
+	}
+}
+
+type badRoutes struct {
+	Routes struct {
+		Ping    string `route:"GET"`
+		Missing string `route:"GET /missing"`
+	}
+}
+
+func (badRoutes) Ping() string { return "pong" }
+
+func TestHandlerRouteTagErrors(t *testing.T) {
+	_, err := HandlerWithError(badRoutes{})
+	want := "route tag for Ping: invalid route \"GET\": path must begin with /\nroute tag for Missing: no such method"
+	if err == nil || err.Error() != want {
+		t.Errorf("expected error %q, got %v", want, err)
+	}
+}
+
+type badSignatures struct{}
+
+func (badSignatures) Ping() string                       { return "pong" }
+func (badSignatures) Add(a, b int) int                   { return a + b }
+func (badSignatures) Pair() (string, string)             { return "a", "b" }
+func (badSignatures) Lookup(r *http.Request, id int) int { return id }
+
+func TestNewHandlerStrictSignatures(t *testing.T) {
+	if _, err := NewHandler(badSignatures{}); err != nil {
+		t.Errorf("expected no error without strict signatures, got %v", err)
+	}
+
+	_, err := NewHandler(badSignatures{}, WithStrictSignatures(true))
+	want := "method Add: takes 2 arguments, but DefaultMatcherFunc supplies at most one\n" +
+		"method Pair: unsupported results; want (), (error), (T), (T, error), or (T, bool, error)"
+	if err == nil || err.Error() != want {
+		t.Errorf("expected error %q, got %v", want, err)
+	}
+
+	if _, err := NewHandler(badSignatures{}, WithStrictSignatures(true), WithExcludeMethods("Pair"), WithMatcherFunc(restMatcherFunc)); err != nil {
+		t.Errorf("expected no error with a custom matcher, got %v", err)
+	}
+}
+
+type meService struct {
+	userService
+
+	Routes struct {
+		GetMe string `route:"GET /user/{name}"`
+	}
+}
+
+func (meService) GetMe(args struct{ Name string }) string { return "me " + args.Name }
+
+func TestHandlerDispatchOrder(t *testing.T) {
+	var got []string
+	for _, route := range Describe(meService{}, WithRESTRouting(true), WithRoutePriority(map[string]int{"GetMe": 1, "Ping": -1})) {
+		got = append(got, route.HTTPMethod+" "+route.Pattern+" "+route.Name)
+	}
+	want := []string{
+		"GET /user/{name} GetMe",
+		"DELETE /user/{id} DeleteUser",
+		"GET /user/{id} GetUser",
+		"GET /user-profile/{id} GetUserProfile",
+		"PUT /user/{id} UpdateUser",
+		"POST /user CreateUser",
+		"GET /users ListUsers",
+		"POST /Ping Ping",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected routes:\n%s\ngot:\n%s", strings.Join(want, "\n"), strings.Join(got, "\n"))
+	}
+
+	for _, priority := range []int{1, -1} {
+		handler := Handler(meService{}, WithRESTRouting(true), WithRoutePriority(map[string]int{"GetMe": priority}))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/user/7", nil))
+		want := "\"me 7\"\n"
+		if priority < 0 {
+			want = "\"user 7\"\n"
+		}
+		if w.Body.String() != want {
+			t.Errorf("priority %d: expected body %q, got %q", priority, want, w.Body.String())
+		}
+	}
+}
+
+type conflictingRoutes struct {
+	userService
+
+	Routes struct {
+		Ping string `route:"GET /user/{name}"`
+	}
+}
+
+func TestHandlerRouteConflicts(t *testing.T) {
+	_, err := HandlerWithError(&conflictingRoutes{}, WithRESTRouting(true))
+	want := "route GET /user/{name} of method Ping conflicts with method GetUser"
+	if err == nil || err.Error() != want {
+		t.Errorf("expected error %q, got %v", want, err)
+	}
+
+	if _, err := HandlerWithError(&conflictingRoutes{}); err != nil {
+		t.Errorf("expected no error without REST routing, got %v", err)
+	}
+}
+
+func TestRegisterRoutes(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /other", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "other")
+	})
+	RegisterRoutes(mux, &taggedService{}, WithRESTRouting(true))
+
+	testCases := []struct {
+		method, path, body string
+		expectedStatusCode int
+		expectedBody       string
+	}{
+		{"GET", "/users/7", "", 200, "\"user 7\"\n"},
+		{"HEAD", "/users/7", "", 200, ""},
+		{"PUT", "/user/3", `{"Name":"dave"}`, 200, "{\"ID\":3,\"Name\":\"dave\"}\n"},
+		{"POST", "/users", `{"Name":"carol"}`, 200, "{\"Name\":\"carol\"}\n"},
+		{"GET", "/other", "", 200, "other"},
+		{"GET", "/missing", "", 404, "404 page not found\n"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.method+" "+tc.path, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, req)
+
+			if w.Code != tc.expectedStatusCode {
+				t.Errorf("expected status code %d, got %d", tc.expectedStatusCode, w.Code)
+			}
+			if w.Body.String() != tc.expectedBody {
+				t.Errorf("expected body %q, got %q", tc.expectedBody, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestDescribeEndpoints(t *testing.T) {
+	var got []string
+	for _, route := range Describe(&taggedService{}, WithRESTRouting(true)) {
+		got = append(got, route.HTTPMethod+" "+route.Pattern+" "+route.String())
+	}
+
+	want := []string{
+		"DELETE /user/{id} DeleteUser(int) error",
+		"GET /users/{id} GetUser(int) (string, error)",
+		"GET /user-profile/{id} GetUserProfile(struct { ID string }) string",
+		"PUT /user/{id} UpdateUser(structhttp.updateUserArgs) structhttp.updateUserArgs",
+		"POST /users CreateUser(structhttp.testUser) structhttp.testUser",
+		"GET /users ListUsers() []string",
+		"GET /health Ping() string",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected routes:\n%s\ngot:\n%s", strings.Join(want, "\n"), strings.Join(got, "\n"))
+	}
+}
+
+func TestMount(t *testing.T) {
+	routes := make(map[string]http.Handler)
+	register := func(method, pattern string, h http.Handler) {
+		routes[method+" "+pattern] = h
+	}
+	pathParam := func(r *http.Request, name string) string {
+		return r.Header.Get("X-Param-" + name)
+	}
+	Mount(register, pathParam, userService{}, WithRESTRouting(true))
+
+	var patterns []string
+	for pattern := range routes {
+		patterns = append(patterns, pattern)
+	}
+	sort.Strings(patterns)
+	want := []string{
+		"DELETE /user/{id}",
+		"GET /user-profile/{id}",
+		"GET /user/{id}",
+		"GET /users",
+		"POST /Ping",
+		"POST /user",
+		"PUT /user/{id}",
+	}
+	if !reflect.DeepEqual(patterns, want) {
+		t.Errorf("expected patterns %v, got %v", want, patterns)
+	}
+
+	req := httptest.NewRequest("GET", "/users/by-id", nil)
+	req.Header.Set("X-Param-id", "7")
+	w := httptest.NewRecorder()
+	routes["GET /user/{id}"].ServeHTTP(w, req)
+	if w.Code != 200 || w.Body.String() != "\"user 7\"\n" {
+		t.Errorf("expected 200 \"user 7\", got %d %q", w.Code, w.Body.String())
+	}
+}
+
+func TestHandlerNamingStrategy(t *testing.T) {
+	testCases := []testCase{
+		{
+			name:               "kebab case",
+			httpMethod:         "POST",
+			path:               "/only-result",
+			result:             "foo",
+			expectedStatusCode: 200,
+			expectedBody:       "\"foo\"\n",
+		},
+		{
+			name:               "method name",
+			httpMethod:         "POST",
+			path:               "/OnlyResult",
+			expectedStatusCode: 404,
+			expectedBody:       "404 page not found\n",
+		},
+	}
+	runTests(t, testCases, WithNamingStrategy(KebabCase))
+
+	var got []string
+	for _, route := range Describe(userService{}, WithRESTRouting(true), WithNamingStrategy(SnakeCase)) {
+		got = append(got, route.HTTPMethod+" "+route.Pattern)
+	}
+	want := []string{"DELETE /user/{id}", "GET /user/{id}", "GET /user_profile/{id}", "PUT /user/{id}", "POST /user", "GET /users", "POST /ping"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected routes %v, got %v", want, got)
+	}
+}
+
+func TestHandlerMethodFilters(t *testing.T) {
+	routeNames := func(opts ...Option) []string {
+		var names []string
+		for _, route := range Describe(userService{}, opts...) {
+			names = append(names, route.Name)
+		}
+		return names
+	}
+
+	if got, want := routeNames(WithMethods("Ping", "GetUser")), []string{"GetUser", "Ping"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected routes %v, got %v", want, got)
+	}
+	if got, want := routeNames(WithMethods("Ping", "GetUser"), WithExcludeMethods("GetUser")), []string{"Ping"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected routes %v, got %v", want, got)
+	}
+	if got, want := len(routeNames(WithExcludeMethods("Ping"))), 6; got != want {
+		t.Errorf("expected %d routes, got %d", want, got)
+	}
+
+	testCases := []testCase{
+		{
+			name:               "excluded method",
+			httpMethod:         "POST",
+			path:               "/NoResult",
+			expectedStatusCode: 404,
+			expectedBody:       "404 page not found\n",
+		},
+		{
+			name:               "other method",
+			httpMethod:         "POST",
+			path:               "/OnlyError",
+			expectedStatusCode: 204,
+		},
+	}
+	runTests(t, testCases, WithExcludeMethods("NoResult"))
+
+	if _, err := HandlerWithError(userService{}, WithMethods("Ping", "Pong")); err == nil || err.Error() != "WithMethods: no such method Pong" {
+		t.Errorf("expected error for unknown method, got %v", err)
+	}
+}
+
+type (
+	apiService struct {
+		Users    userService `mount:"/users"`
+		Counters *counter    `mount:""`
+		Missing  *counter    `mount:"/missing"`
+	}
+)
+
+func (apiService) Version() string { return "v1" }
+
+func TestHandlerMount(t *testing.T) {
+	api := &apiService{Counters: &counter{}}
+
+	var got []string
+	for _, route := range Describe(api) {
+		got = append(got, route.HTTPMethod+" "+route.Pattern+" "+route.Name)
+	}
+	want := []string{
+		"POST /counters/Increment Counters.Increment",
+		"POST /users/CreateUser Users.CreateUser",
+		"POST /users/DeleteUser Users.DeleteUser",
+		"POST /users/GetUser Users.GetUser",
+		"POST /users/GetUserProfile Users.GetUserProfile",
+		"POST /users/ListUsers Users.ListUsers",
+		"POST /users/Ping Users.Ping",
+		"POST /users/UpdateUser Users.UpdateUser",
+		"POST /Version Version",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected routes:\n%s\ngot:\n%s", strings.Join(want, "\n"), strings.Join(got, "\n"))
+	}
+
+	handler := Handler(api, WithRESTRouting(true), WithSuccessStatus(map[string]int{"Users.CreateUser": http.StatusCreated}))
+	testCases := []struct {
+		method, path, body string
+		expectedStatusCode int
+		expectedBody       string
+	}{
+		{"POST", "/Version", "", 200, "\"v1\"\n"},
+		{"GET", "/users/user/7", "", 200, "\"user 7\"\n"},
+		{"POST", "/users/user", `{"Name":"carol"}`, 201, "{\"Name\":\"carol\"}\n"},
+		{"POST", "/users/Ping", "", 200, "\"pong\"\n"},
+		{"POST", "/counters/Increment", "", 200, "1\n"},
+		{"POST", "/Ping", "", 404, "404 page not found\n"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.method+" "+tc.path, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if w.Code != tc.expectedStatusCode {
+				t.Errorf("expected status code %d, got %d", tc.expectedStatusCode, w.Code)
+			}
+			if w.Body.String() != tc.expectedBody {
+				t.Errorf("expected body %q, got %q", tc.expectedBody, w.Body.String())
+			}
+		})
+	}
+
+	if _, err := HandlerWithError(api); err == nil || err.Error() != "mount Missing: field is nil" {
+		t.Errorf("expected error for nil mount, got %v", err)
+	}
+}
+
+func TestHandlerGroup(t *testing.T) {
+	handler := HandlerGroup(map[string]any{
+		"/users":         userService{},
+		"/users/counter": Service{Struct: &counter{}, Options: []Option{WithNamingStrategy(KebabCase)}},
+		"/app/":          &app{},
+	}, WithErrorEncoder(JSONErrorEncoder))
+
+	testCases := []struct {
+		method, path       string
+		expectedStatusCode int
+		expectedBody       string
+	}{
+		{"POST", "/users/Ping", 200, "\"pong\"\n"},
+		{"POST", "/users/counter/increment", 200, "1\n"},
+		{"POST", "/app/NoResult", 204, ""},
+		{"POST", "/appx/NoResult", 404, "{\"error\":\"not found\"}\n"},
+		{"POST", "/Ping", 404, "{\"error\":\"not found\"}\n"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.method+" "+tc.path, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, tc.path, nil)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if w.Code != tc.expectedStatusCode {
+				t.Errorf("expected status code %d, got %d", tc.expectedStatusCode, w.Code)
+			}
+			if w.Body.String() != tc.expectedBody {
+				t.Errorf("expected body %q, got %q", tc.expectedBody, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestHandlerMethodNotAllowed(t *testing.T) {
+	testCases := []testCase{
+		{
+			name:               "wrong method",
+			httpMethod:         "GET",
+			path:               "/NoResult",
+			expectedStatusCode: 405,
+			expectedHeaders:    map[string]string{"Allow": "POST"},
+			expectedBody:       "Method Not Allowed\n",
+		},
+		{
+			name:               "unknown path",
+			httpMethod:         "GET",
+			path:               "/Missing",
+			expectedStatusCode: 404,
+			expectedHeaders:    map[string]string{"Allow": ""},
+			expectedBody:       "404 page not found\n",
+		},
+	}
+	runTests(t, testCases)
+
+	testCases = []testCase{
+		{
+			name:               "error encoder",
+			httpMethod:         "DELETE",
+			path:               "/NoResult",
+			expectedStatusCode: 405,
+			expectedHeaders:    map[string]string{"Allow": "POST"},
+			expectedBody:       "{\"error\":\"method not allowed\"}\n",
+		},
+	}
+	runTests(t, testCases, WithErrorEncoder(JSONErrorEncoder))
+}
+
+func TestHandlerFallbackHandler(t *testing.T) {
+	fallback := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+		fmt.Fprintf(w, "legacy %s %s", r.Method, r.URL.Path)
+	})
+
+	testCases := []testCase{
+		{
+			name:               "matched",
+			httpMethod:         "POST",
+			path:               "/NoResult",
+			expectedStatusCode: 204,
+		},
+		{
+			name:               "unknown path",
+			httpMethod:         "GET",
+			path:               "/legacy/orders",
+			expectedStatusCode: 418,
+			expectedBody:       "legacy GET /legacy/orders",
+		},
+		{
+			name:               "other HTTP method",
+			httpMethod:         "GET",
+			path:               "/NoResult",
+			expectedStatusCode: 418,
+			expectedHeaders:    map[string]string{"Allow": ""},
+			expectedBody:       "legacy GET /NoResult",
+		},
+		{
+			name:               "OPTIONS",
+			httpMethod:         "OPTIONS",
+			path:               "/NoResult",
+			expectedStatusCode: 418,
+			expectedBody:       "legacy OPTIONS /NoResult",
+		},
+	}
+
+	runTests(t, testCases, WithFallbackHandler(fallback), WithNotFoundHandler(http.NotFoundHandler()))
+}
+
+func TestHandlerTrailingSlashPolicy(t *testing.T) {
+	testCases := []testCase{
+		{
+			name:               "strict",
+			httpMethod:         "POST",
+			path:               "/OnlyResult/",
+			expectedStatusCode: 404,
+			expectedBody:       "404 page not found\n",
+		},
+	}
+	runTests(t, testCases, WithTrailingSlashPolicy(TrailingSlashStrict))
+
+	testCases = []testCase{
+		{
+			name:               "lenient",
+			httpMethod:         "POST",
+			path:               "/OnlyResult/",
+			result:             "foo",
+			expectedStatusCode: 200,
+			expectedBody:       "\"foo\"\n",
+		},
+		{
+			name:               "lenient, unknown path",
+			httpMethod:         "POST",
+			path:               "/Missing/",
+			expectedStatusCode: 404,
+			expectedBody:       "404 page not found\n",
+		},
+	}
+	runTests(t, testCases, WithTrailingSlashPolicy(TrailingSlashLenient))
+
+	testCases = []testCase{
+		{
+			name:               "redirect POST",
+			httpMethod:         "POST",
+			path:               "/OnlyResult/?x=1",
+			expectedStatusCode: 308,
+			expectedHeaders:    map[string]string{"Location": "../OnlyResult?x=1"},
+		},
+		{
+			name:               "redirect, other HTTP method",
+			httpMethod:         "PUT",
+			path:               "/OnlyResult/",
+			expectedStatusCode: 404,
+			expectedBody:       "404 page not found\n",
+		},
+	}
+	runTests(t, testCases, WithTrailingSlashPolicy(TrailingSlashRedirect))
+
+	testCases = []testCase{
+		{
+			name:               "redirect GET",
+			httpMethod:         "GET",
+			path:               "/v2/OnlyResult/",
+			expectedStatusCode: 301,
+			expectedHeaders:    map[string]string{"Location": "../OnlyResult"},
+		},
+	}
+	runTests(t, testCases, WithMatcher(versionMatcher{version: "v2"}), WithTrailingSlashPolicy(TrailingSlashRedirect), WithMatcherProbing(true))
+
+	handler := Handler(goService{}, WithTrailingSlashPolicy(TrailingSlashRedirect))
+	for path, want := range map[string]string{
+		"/go/https:evil.com": "./https:evil.com/",
+		"/go/a%3Fb?x=1":      "./a%3Fb/?x=1",
+		"/go/a/":             "",
+	} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if got := w.Header().Get("Location"); got != want {
+			t.Errorf("%s: expected Location %q, got %q", path, want, got)
+		}
+	}
+}
+
+type goService struct {
+	Routes struct {
+		Go string `route:"GET /go/{target}/"`
+	}
+}
+
+func (goService) Go(args struct{ Target string }) string { return args.Target }
+
+func TestHandlerCaseInsensitivePaths(t *testing.T) {
+	testCases := []testCase{
+		{
+			name:               "lower case",
+			httpMethod:         "POST",
+			path:               "/onlyresult",
+			result:             "foo",
+			expectedStatusCode: 200,
+			expectedBody:       "\"foo\"\n",
+		},
+		{
+			name:               "upper case",
+			httpMethod:         "POST",
+			path:               "/ONLYRESULT",
+			result:             "foo",
+			expectedStatusCode: 200,
+			expectedBody:       "\"foo\"\n",
+		},
+	}
+	runTests(t, testCases, WithCaseInsensitivePaths(true))
+
+	testCases = []testCase{
+		{
+			name:               "disabled",
+			httpMethod:         "POST",
+			path:               "/onlyresult",
+			expectedStatusCode: 404,
+			expectedBody:       "404 page not found\n",
+		},
+	}
+	runTests(t, testCases)
+}
+
+func TestHandlerCaseInsensitivePathVariables(t *testing.T) {
+	handler := Handler(&taggedService{}, WithRESTRouting(true), WithCaseInsensitivePaths(true))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/USER-PROFILE/AbC", nil))
+	if w.Code != 200 || w.Body.String() != "\"profile AbC\"\n" {
+		t.Errorf("expected 200 with body %q, got %d with body %q", "\"profile AbC\"\n", w.Code, w.Body.String())
+	}
+}
+
+type pathService struct {
+	Routes struct {
+		GetPost string `route:"GET /users/{user}/posts/{id}"`
+		Raw     string `route:"GET /raw/{name...}"`
+	}
+}
+
+type postArgs struct {
+	User  string `path:"user"`
+	ID    int    `path:"id"`
+	Draft bool   `query:"draft"`
+}
+
+func (pathService) GetPost(args postArgs) postArgs { return args }
+
+func (pathService) Raw(r *http.Request) string { return r.PathValue("name") }
+
+func TestHandlerPathBinding(t *testing.T) {
+	testCases := []struct {
+		path               string
+		expectedStatusCode int
+		expectedBody       string
+	}{
+		{"/users/ann/posts/3?draft=true", 200, "{\"User\":\"ann\",\"ID\":3,\"Draft\":true}\n"},
+		{"/users/ann/posts/x", 400, "{\"error\":\"invalid value for path parameter \\\"id\\\": strconv.ParseInt: parsing \\\"x\\\": invalid syntax\"}\n"},
+		{"/raw/a/b", 200, "\"a/b\"\n"},
+		{"/raw/", 200, "\"\"\n"},
+		{"/raw", 404, "404 page not found\n"},
+	}
+	for _, matcher := range []MatcherFunc{DefaultMatcherFunc, QueryMatcherFunc} {
+		handler := Handler(pathService{}, WithMatcherFunc(matcher))
+		for _, tc := range testCases {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest("GET", tc.path, nil))
+			if w.Code != tc.expectedStatusCode || w.Body.String() != tc.expectedBody {
+				t.Errorf("GET %s: expected %d with body %q, got %d with body %q", tc.path, tc.expectedStatusCode, tc.expectedBody, w.Code, w.Body.String())
+			}
+		}
+	}
+}
+
+type listHeaderArgs struct {
+	Tags  []string `header:"X-Tag"`
+	IDs   []int    `header:"X-ID"`
+	Debug *bool    `header:"X-Debug"`
+}
+
+type headerService struct{}
+
+func (headerService) List(args listHeaderArgs) listHeaderArgs { return args }
+
+func TestHandlerListHeaders(t *testing.T) {
+	handler := Handler(headerService{})
+
+	testCases := []struct {
+		name               string
+		headers            map[string][]string
+		expectedStatusCode int
+		expectedBody       string
+	}{
+		{
+			"repeated and comma-separated",
+			map[string][]string{"X-Tag": {"a, b", "c"}, "X-Id": {"1,2"}, "X-Debug": {"true"}},
+			200,
+			"{\"Tags\":[\"a\",\"b\",\"c\"],\"IDs\":[1,2],\"Debug\":true}\n",
+		},
+		{
+			"absent",
+			nil,
+			200,
+			"{\"Tags\":null,\"IDs\":null,\"Debug\":null}\n",
+		},
+		{
+			"invalid element",
+			map[string][]string{"X-Id": {"1, two"}},
+			400,
+			"{\"error\":\"invalid value for header \\\"X-ID\\\": strconv.ParseInt: parsing \\\"two\\\": invalid syntax\"}\n",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/List", strings.NewReader("{}"))
+			for name, values := range tc.headers {
+				for _, value := range values {
+					req.Header.Add(name, value)
+				}
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if w.Code != tc.expectedStatusCode {
+				t.Errorf("expected status code %d, got %d", tc.expectedStatusCode, w.Code)
+			}
+			if w.Body.String() != tc.expectedBody {
+				t.Errorf("expected body %q, got %q", tc.expectedBody, w.Body.String())
+			}
+		})
+	}
+}
+
+type cookieArgs struct {
+	Session string `cookie:"session_id,required"`
+	Theme   string `cookie:"theme" json:"theme"`
+	Visits  int    `cookie:"visits"`
+}
+
+type cookieService struct{}
+
+func (cookieService) Whoami(args cookieArgs) cookieArgs { return args }
+
+func TestHandlerCookies(t *testing.T) {
+	handler := Handler(cookieService{})
+
+	testCases := []struct {
+		name               string
+		target             string
+		cookies            []*http.Cookie
+		expectedStatusCode int
+		expectedBody       string
+	}{
+		{
+			"all cookies",
+			"/Whoami",
+			[]*http.Cookie{{Name: "session_id", Value: "abc"}, {Name: "theme", Value: "dark"}, {Name: "visits", Value: "3"}},
+			200,
+			"{\"Session\":\"abc\",\"theme\":\"dark\",\"Visits\":3}\n",
+		},
+		{
+			"optional cookies absent",
+			"/Whoami",
+			[]*http.Cookie{{Name: "session_id", Value: "abc"}},
+			200,
+			"{\"Session\":\"abc\",\"theme\":\"light\",\"Visits\":0}\n",
+		},
+		{
+			"cookie overrides query",
+			"/Whoami?theme=blue",
+			[]*http.Cookie{{Name: "session_id", Value: "abc"}, {Name: "theme", Value: "dark"}},
+			200,
+			"{\"Session\":\"abc\",\"theme\":\"dark\",\"Visits\":0}\n",
+		},
+		{
+			"missing required cookie",
+			"/Whoami",
+			nil,
+			400,
+			"{\"error\":\"missing required cookie \\\"session_id\\\"\"}\n",
+		},
+		{
+			"invalid cookie",
+			"/Whoami",
+			[]*http.Cookie{{Name: "session_id", Value: "abc"}, {Name: "visits", Value: "many"}},
+			400,
+			"{\"error\":\"invalid value for cookie \\\"visits\\\": strconv.ParseInt: parsing \\\"many\\\": invalid syntax\"}\n",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", tc.target, strings.NewReader("{\"theme\":\"light\"}"))
+			for _, c := range tc.cookies {
+				req.AddCookie(c)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if w.Code != tc.expectedStatusCode {
+				t.Errorf("expected status code %d, got %d", tc.expectedStatusCode, w.Code)
+			}
+			if w.Body.String() != tc.expectedBody {
+				t.Errorf("expected body %q, got %q", tc.expectedBody, w.Body.String())
+			}
+		})
+	}
+}
+
+type mergeService struct {
+	Routes struct {
+		UpdateItem string `route:"PUT /items/{id}"`
+	}
+}
+
+type mergeArgs struct {
+	ID      string `path:"id" json:"id"`
+	Name    string `json:"name"`
+	Color   string `json:"color"`
+	Session string `cookie:"session" json:"session"`
+	Trace   string `header:"X-Trace" json:"trace"`
+}
+
+func (mergeService) UpdateItem(args mergeArgs) mergeArgs { return args }
+
+func TestHandlerBindingPrecedence(t *testing.T) {
+	handler := Handler(mergeService{})
+	body := `{"id":"body","name":"body","color":"body","session":"body","trace":"body"}`
+
+	testCases := []struct {
+		name         string
+		target       string
+		cookie       string
+		header       string
+		expectedBody string
+	}{
+		{
+			"body and path",
+			"/items/path",
+			"",
+			"",
+			"{\"id\":\"path\",\"name\":\"body\",\"color\":\"body\",\"session\":\"body\",\"trace\":\"body\"}\n",
+		},
+		{
+			"query over path",
+			"/items/path?id=query&name=query",
+			"",
+			"",
+			"{\"id\":\"query\",\"name\":\"query\",\"color\":\"body\",\"session\":\"body\",\"trace\":\"body\"}\n",
+		},
+		{
+			"cookie and header over query",
+			"/items/path?session=query&trace=query",
+			"cookie",
+			"header",
+			"{\"id\":\"path\",\"name\":\"body\",\"color\":\"body\",\"session\":\"cookie\",\"trace\":\"header\"}\n",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("PUT", tc.target, strings.NewReader(body))
+			if tc.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: "session", Value: tc.cookie})
+			}
+			if tc.header != "" {
+				req.Header.Set("X-Trace", tc.header)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if w.Code != 200 {
+				t.Errorf("expected status code 200, got %d", w.Code)
+			}
+			if w.Body.String() != tc.expectedBody {
+				t.Errorf("expected body %q, got %q", tc.expectedBody, w.Body.String())
+			}
+		})
+	}
+}
+
+type calculator struct{}
+
+func (calculator) Add(a, b int) int { return a + b }
+func (calculator) Scale(r *http.Request, x, by float64) string {
+	return fmt.Sprint(x*by, " ", r.PathValue("by"))
+}
+func (calculator) Echo(s string) string { return s }
+func (calculator) Sum(nums ...int) int {
+	total := 0
+	for _, n := range nums {
+		total += n
+	}
+	return total
+}
+
+func TestHandlerPositionalArgs(t *testing.T) {
+	handler, err := NewHandler(calculator{},
+		WithPositionalArgs(true),
+		WithStrictSignatures(true),
+		WithParamNames(map[string][]string{"Scale": {"r", "x", "by"}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		method             string
+		path               string
+		body               string
+		expectedStatusCode int
+		expectedBody       string
+	}{
+		{"GET", "/Add/1/2", "", 200, "3\n"},
+		{"GET", "/Add/1/x", "", 400, "{\"error\":\"invalid value for path parameter \\\"arg1\\\": strconv.ParseInt: parsing \\\"x\\\": invalid syntax\"}\n"},
+		{"GET", "/Add/1", "", 404, "404 page not found\n"},
+		{"POST", "/Add/1/2", "", 405, "Method Not Allowed\n"},
+		{"GET", "/Scale/1.5/2", "", 200, "\"3 2\"\n"},
+		{"GET", "/Echo/hello", "", 200, "\"hello\"\n"},
+		{"POST", "/Sum", "[1,2,3]", 200, "6\n"},
+	}
+	for _, tc := range testCases {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body)))
+		if w.Code != tc.expectedStatusCode || w.Body.String() != tc.expectedBody {
+			t.Errorf("%s %s: expected %d with body %q, got %d with body %q", tc.method, tc.path, tc.expectedStatusCode, tc.expectedBody, w.Code, w.Body.String())
+		}
+	}
+
+	if _, err := NewHandler(calculator{}, WithStrictSignatures(true)); err == nil {
+		t.Error("expected an error for multiple arguments without WithPositionalArgs")
+	}
+}
+
+type pageArgs struct {
+	Limit  int `query:"limit"`
+	Offset int `query:"offset"`
+}
+
+type findArgs struct {
+	pageArgs
+	Filter struct {
+		Status string   `query:"status"`
+		Labels []string `query:"label"`
+		Owner  *struct {
+			Name string `query:"name"`
+		} `query:"owner"`
+	} `query:"filter"`
+	Sort *struct{ Field string } `json:"sort"`
+}
+
+type finder struct{}
+
+func (finder) Find(args findArgs) findArgs { return args }
+
+func TestHandlerNestedQuery(t *testing.T) {
+	handler := Handler(finder{}, WithMatcherFunc(QueryMatcherFunc))
+
+	testCases := []struct {
+		path               string
+		expectedStatusCode int
+		expectedBody       string
+	}{
+		{
+			"/Find?filter.status=open&filter[label]=a,b&filter[owner][name]=ann&sort.field=date&limit=10",
+			200,
+			"{\"Limit\":10,\"Offset\":0,\"Filter\":{\"Status\":\"open\",\"Labels\":[\"a\",\"b\"],\"Owner\":{\"Name\":\"ann\"}},\"sort\":{\"Field\":\"date\"}}\n",
+		},
+		{
+			"/Find?Filter.Status=closed&filter.owner.name=bob",
+			200,
+			"{\"Limit\":0,\"Offset\":0,\"Filter\":{\"Status\":\"closed\",\"Labels\":null,\"Owner\":{\"Name\":\"bob\"}},\"sort\":null}\n",
+		},
+		{
+			"/Find?offset=5",
+			200,
+			"{\"Limit\":0,\"Offset\":5,\"Filter\":{\"Status\":\"\",\"Labels\":null,\"Owner\":null},\"sort\":null}\n",
+		},
+		{
+			"/Find?filter[owner]x=1&filter[=2",
+			200,
+			"{\"Limit\":0,\"Offset\":0,\"Filter\":{\"Status\":\"\",\"Labels\":null,\"Owner\":null},\"sort\":null}\n",
+		},
+	}
+	for _, tc := range testCases {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", tc.path, nil))
+		if w.Code != tc.expectedStatusCode || w.Body.String() != tc.expectedBody {
+			t.Errorf("GET %s: expected %d with body %q, got %d with body %q", tc.path, tc.expectedStatusCode, tc.expectedBody, w.Code, w.Body.String())
+		}
+	}
+}
+
+type defaultArgs struct {
+	Limit  int      `query:"limit" json:"limit" default:"50"`
+	Sort   string   `json:"sort" default:"name"`
+	Desc   *bool    `json:"desc" default:"true"`
+	Fields []string `json:"fields" default:"id, name"`
+	Page   struct {
+		Size int `json:"size" default:"10"`
+	} `json:"page"`
+}
+
+type defaultService struct{}
+
+func (defaultService) List(args defaultArgs) defaultArgs { return args }
+
+type badDefault struct{}
+
+func (badDefault) List(args struct {
+	Limit int `default:"many"`
+}) int {
+	return args.Limit
+}
+
+func TestHandlerDefaults(t *testing.T) {
+	handler := Handler(defaultService{})
+
+	testCases := []struct {
+		name         string
+		target       string
+		body         string
+		expectedBody string
+	}{
+		{
+			"all defaults",
+			"/List",
+			"{}",
+			"{\"limit\":50,\"sort\":\"name\",\"desc\":true,\"fields\":[\"id\",\"name\"],\"page\":{\"size\":10}}\n",
+		},
+		{
+			"zero values in body",
+			"/List",
+			"{\"limit\":0,\"desc\":false,\"fields\":[],\"page\":{\"size\":0}}",
+			"{\"limit\":0,\"sort\":\"name\",\"desc\":false,\"fields\":[],\"page\":{\"size\":0}}\n",
+		},
+		{
+			"query overrides default",
+			"/List?limit=5&sort=date",
+			"{}",
+			"{\"limit\":5,\"sort\":\"date\",\"desc\":true,\"fields\":[\"id\",\"name\"],\"page\":{\"size\":10}}\n",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest("POST", tc.target, strings.NewReader(tc.body)))
+			if w.Code != 200 || w.Body.String() != tc.expectedBody {
+				t.Errorf("expected 200 with body %q, got %d with body %q", tc.expectedBody, w.Code, w.Body.String())
+			}
+		})
+	}
+
+	w := httptest.NewRecorder()
+	Handler(defaultService{}, WithMatcherFunc(QueryMatcherFunc)).ServeHTTP(w, httptest.NewRequest("GET", "/List?page.size=20", nil))
+	if expected := "{\"limit\":50,\"sort\":\"name\",\"desc\":true,\"fields\":[\"id\",\"name\"],\"page\":{\"size\":20}}\n"; w.Body.String() != expected {
+		t.Errorf("expected body %q with QueryMatcherFunc, got %q", expected, w.Body.String())
+	}
+
+	_, err := NewHandler(badDefault{})
+	if err == nil || err.Error() != "method List: invalid default for field Limit: strconv.ParseInt: parsing \"many\": invalid syntax" {
+		t.Errorf("expected an invalid default error, got %v", err)
+	}
+}
+
+type requiredArgs struct {
+	Name  string `json:"name" required:"true"`
+	Count *int   `json:"count" required:"true"`
+	Owner struct {
+		ID string `json:"id" required:"true"`
+	} `json:"owner"`
+	Note string `json:"note"`
+}
+
+type requiredService struct{}
+
+func (requiredService) Create(args requiredArgs) string { return args.Name }
+
+func TestHandlerRequiredFields(t *testing.T) {
+	testCases := []struct {
+		name               string
+		target             string
+		body               string
+		expectedStatusCode int
+		expectedBody       string
+	}{
+		{
+			"all present",
+			"/Create",
+			"{\"name\":\"a\",\"count\":0,\"owner\":{\"id\":\"1\"}}",
+			200,
+			"\"a\"\n",
+		},
+		{
+			"from the query",
+			"/Create?name=a&count=0&owner.id=1",
+			"{}",
+			200,
+			"\"a\"\n",
+		},
+		{
+			"one missing",
+			"/Create",
+			"{\"name\":\"a\",\"count\":1}",
+			400,
+			"{\"error\":\"missing required field \\\"owner.id\\\"\",\"missing\":[\"owner.id\"]}\n",
+		},
+		{
+			"all missing",
+			"/Create",
+			"{\"note\":\"x\"}",
+			400,
+			"{\"error\":\"missing required fields: name, count, owner.id\",\"missing\":[\"name\",\"count\",\"owner.id\"]}\n",
+		},
+	}
+	handler := Handler(requiredService{})
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest("POST", tc.target, strings.NewReader(tc.body)))
+			if w.Code != tc.expectedStatusCode || w.Body.String() != tc.expectedBody {
+				t.Errorf("expected %d with body %q, got %d with body %q", tc.expectedStatusCode, tc.expectedBody, w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+type timeArgs struct {
+	Since   time.Time     `query:"since"`
+	Until   *time.Time    `query:"until"`
+	Timeout time.Duration `query:"timeout"`
+}
+
+type timeService struct{}
+
+func (timeService) Window(args timeArgs) timeArgs { return args }
+
+func (timeService) After(t time.Time, d time.Duration) time.Time { return t.Add(d) }
+
+func TestHandlerTimeFormats(t *testing.T) {
+	testCases := []struct {
+		name               string
+		opts               []Option
+		path               string
+		expectedStatusCode int
+		expectedBody       string
+	}{
+		{
+			"default RFC 3339",
+			nil,
+			"/Window?since=2024-01-02T03:04:05Z&timeout=1m30s",
+			200,
+			"{\"Since\":\"2024-01-02T03:04:05Z\",\"Until\":null,\"Timeout\":90000000000}\n",
+		},
+		{
+			"date only rejected by default",
+			nil,
+			"/Window?since=2024-01-02",
+			400,
+			"{\"error\":\"invalid value for query parameter \\\"since\\\": parsing time \\\"2024-01-02\\\" as \\\"2006-01-02T15:04:05Z07:00\\\": cannot parse \\\"\\\" as \\\"T\\\"\"}\n",
+		},
+		{
+			"configured formats",
+			[]Option{WithTimeFormats(time.RFC3339, time.DateOnly, TimeFormatUnix)},
+			"/Window?since=2024-01-02&until=1704164645",
+			200,
+			"{\"Since\":\"2024-01-02T00:00:00Z\",\"Until\":\"2024-01-02T03:04:05Z\",\"Timeout\":0}\n",
+		},
+		{
+			"no matching format",
+			[]Option{WithTimeFormats(time.DateOnly)},
+			"/Window?since=yesterday",
+			400,
+			"{\"error\":\"invalid value for query parameter \\\"since\\\": parsing time \\\"yesterday\\\": no matching format in [\\\"2006-01-02\\\"]\"}\n",
+		},
+		{
+			"invalid duration",
+			nil,
+			"/Window?timeout=soon",
+			400,
+			"{\"error\":\"invalid value for query parameter \\\"timeout\\\": time: invalid duration \\\"soon\\\"\"}\n",
+		},
+		{
+			"positional arguments",
+			[]Option{WithPositionalArgs(true), WithTimeFormats(TimeFormatUnix)},
+			"/After/1704164645/1h",
+			200,
+			"\"2024-01-02T04:04:05Z\"\n",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			handler := Handler(timeService{}, append([]Option{WithMatcherFunc(QueryMatcherFunc)}, tc.opts...)...)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest("GET", tc.path, nil))
+			if w.Code != tc.expectedStatusCode || w.Body.String() != tc.expectedBody {
+				t.Errorf("expected %d with body %q, got %d with body %q", tc.expectedStatusCode, tc.expectedBody, w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+type priority int
+
+func (p *priority) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "low":
+		*p = 1
+	case "high":
+		*p = 2
+	default:
+		return fmt.Errorf("unknown priority %q", text)
+	}
+	return nil
+}
+
+type labelSet map[string]bool
+
+func (s labelSet) String() string { return fmt.Sprint(map[string]bool(s)) }
+
+func (s *labelSet) Set(value string) error {
+	if *s == nil {
+		*s = make(labelSet)
+	}
+	(*s)[strings.ToLower(value)] = true
+	return nil
+}
+
+type customArgs struct {
+	Priority priority   `query:"priority" header:"X-Priority"`
+	Labels   labelSet   `query:"label"`
+	Levels   []priority `query:"level"`
+}
+
+type customService struct{}
+
+func (customService) Tasks(args customArgs) customArgs { return args }
+
+func TestHandlerCustomTypes(t *testing.T) {
+	handler := Handler(customService{}, WithMatcherFunc(QueryMatcherFunc))
+
+	testCases := []struct {
+		path               string
+		header             string
+		expectedStatusCode int
+		expectedBody       string
+	}{
+		{"/Tasks?priority=high&label=A&label=b&level=low,high", "", 200, "{\"Priority\":2,\"Labels\":{\"a\":true,\"b\":true},\"Levels\":[1,2]}\n"},
+		{"/Tasks", "low", 200, "{\"Priority\":1,\"Labels\":null,\"Levels\":null}\n"},
+		{"/Tasks?priority=urgent", "", 400, "{\"error\":\"invalid value for query parameter \\\"priority\\\": unknown priority \\\"urgent\\\"\"}\n"},
+	}
+	for _, tc := range testCases {
+		req := httptest.NewRequest("GET", tc.path, nil)
+		if tc.header != "" {
+			req.Header.Set("X-Priority", tc.header)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != tc.expectedStatusCode || w.Body.String() != tc.expectedBody {
+			t.Errorf("GET %s: expected %d with body %q, got %d with body %q", tc.path, tc.expectedStatusCode, tc.expectedBody, w.Code, w.Body.String())
+		}
+	}
+}
+
+type testUUID [4]byte
+
+type testDecimal struct{ units, cents int64 }
+
+func (d testDecimal) MarshalText() ([]byte, error) {
+	return []byte(fmt.Sprintf("%d.%02d", d.units, d.cents)), nil
+}
+
+func parseTestUUID(s string) (any, error) {
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) != 4 {
+		return nil, fmt.Errorf("invalid UUID %q", s)
+	}
+	return testUUID(b), nil
+}
+
+type converterArgs struct {
+	ID     testUUID     `query:"id"`
+	Refs   []testUUID   `query:"ref"`
+	Amount *testDecimal `query:"amount"`
+}
+
+type converterService struct{}
+
+func (converterService) Pay(args converterArgs) string {
+	s := fmt.Sprintf("%x %x", args.ID, args.Refs)
+	if args.Amount != nil {
+		text, _ := args.Amount.MarshalText()
+		s += " " + string(text)
+	}
+	return s
+}
+
+func (converterService) Get(id testUUID, n int) string { return fmt.Sprintf("%x %d", id, n) }
+
+func TestHandlerTypeConverters(t *testing.T) {
+	RegisterTypeConverter(reflect.TypeOf(testUUID{}), parseTestUUID)
+	parseDecimal := func(s string) (any, error) {
+		var d testDecimal
+		if _, err := fmt.Sscanf(s, "%d.%d", &d.units, &d.cents); err != nil {
+			return nil, fmt.Errorf("invalid amount %q", s)
+		}
+		return d, nil
+	}
+
+	testCases := []struct {
+		name               string
+		opts               []Option
+		path               string
+		expectedStatusCode int
+		expectedBody       string
+	}{
+		{
+			"registered converter",
+			nil,
+			"/Pay?id=0a0b0c0d&ref=00000001&ref=00000002",
+			200,
+			"\"0a0b0c0d [00000001 00000002]\"\n",
+		},
+		{
+			"handler converter",
+			[]Option{WithTypeConverter(reflect.TypeOf(testDecimal{}), parseDecimal)},
+			"/Pay?amount=12.50",
+			200,
+			"\"00000000 [] 12.50\"\n",
+		},
+		{
+			"handler converter overrides registered one",
+			[]Option{WithTypeConverter(reflect.TypeOf(testUUID{}), func(string) (any, error) { return testUUID{1, 2, 3, 4}, nil })},
+			"/Pay?id=x",
+			200,
+			"\"01020304 []\"\n",
+		},
+		{
+			"conversion error",
+			nil,
+			"/Pay?id=xyz",
+			400,
+			"{\"error\":\"invalid value for query parameter \\\"id\\\": invalid UUID \\\"xyz\\\"\"}\n",
+		},
+		{
+			"wrong result type",
+			[]Option{WithTypeConverter(reflect.TypeOf(testDecimal{}), func(string) (any, error) { return 12.5, nil })},
+			"/Pay?amount=12.50",
+			400,
+			"{\"error\":\"invalid value for query parameter \\\"amount\\\": converter for structhttp.testDecimal returned float64\"}\n",
+		},
+		{
+			"positional arguments",
+			[]Option{WithPositionalArgs(true)},
+			"/Get/0a0b0c0d/3",
+			200,
+			"\"0a0b0c0d 3\"\n",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			handler := Handler(converterService{}, append([]Option{WithMatcherFunc(QueryMatcherFunc)}, tc.opts...)...)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest("GET", tc.path, nil))
+			if w.Code != tc.expectedStatusCode || w.Body.String() != tc.expectedBody {
+				t.Errorf("expected %d with body %q, got %d with body %q", tc.expectedStatusCode, tc.expectedBody, w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+type attachmentArgs struct {
+	Note   string                  `form:"note"`
+	Data   []byte                  `file:"data"`
+	Reader io.Reader               `file:"reader"`
+	Extra  []*multipart.FileHeader `file:"extra"`
+}
+
+type uploadService struct{}
+
+func (uploadService) Attach(args attachmentArgs) string {
+	s := args.Note + " " + string(args.Data)
+	if args.Reader != nil {
+		b, _ := io.ReadAll(args.Reader)
+		s += " " + string(b)
+	}
+	return fmt.Sprintf("%s %d", s, len(args.Extra))
+}
+
+func (uploadService) Bytes(data []byte) string { return string(data) }
+
+func (uploadService) Read(r io.Reader) (string, error) {
+	b, err := io.ReadAll(r)
+	return string(b), err
+}
+
+func multipartBody(files map[string]string, values map[string]string) (string, string) {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	for _, name := range sortedKeys(values) {
+		_ = mw.WriteField(name, values[name])
+	}
+	for _, name := range sortedKeys(files) {
+		w, _ := mw.CreateFormFile(name, name+".txt")
+		_, _ = io.WriteString(w, files[name])
+	}
+	_ = mw.Close()
+	return buf.String(), mw.FormDataContentType()
+}
+
+func TestHandlerMultipartFiles(t *testing.T) {
+	testCases := []struct {
+		name               string
+		path               string
+		files              map[string]string
+		values             map[string]string
+		expectedStatusCode int
+		expectedBody       string
+	}{
+		{"struct fields", "/Attach", map[string]string{"data": "abc", "reader": "def", "extra": "x"}, map[string]string{"note": "hi"}, 200, "\"hi abc def 1\"\n"},
+		{"bytes argument", "/Bytes", map[string]string{"data": "abc"}, nil, 200, "\"abc\"\n"},
+		{"reader argument", "/Read", map[string]string{"data": "streamed"}, nil, 200, "\"streamed\"\n"},
+		{"too many parts", "/Attach", map[string]string{"data": "abc"}, map[string]string{"a": "1", "b": "2", "c": "3", "note": "hi"}, 400, "{\"error\":\"too many parts in multipart form: limit is 4\"}\n"},
+		{"file too large", "/Attach", map[string]string{"data": "0123456789abcdef"}, nil, 413, "{\"error\":\"file \\\"data.txt\\\" is too large: limit is 8 bytes\"}\n"},
+	}
+	handler := Handler(uploadService{}, WithMaxMultipartParts(4), WithMaxFileBytes(8))
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			body, contentType := multipartBody(tc.files, tc.values)
+			req := httptest.NewRequest("POST", tc.path, strings.NewReader(body))
+			req.Header.Set("Content-Type", contentType)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			if w.Code != tc.expectedStatusCode || w.Body.String() != tc.expectedBody {
+				t.Errorf("expected %d with body %q, got %d with body %q", tc.expectedStatusCode, tc.expectedBody, w.Code, w.Body.String())
+			}
+		})
+	}
+
+	// An oversized file is rejected before the rest of the body is read.
+	body, contentType := multipartBody(map[string]string{"data": strings.Repeat("x", 8<<20)}, nil)
+	r := &countingReader{ReadCloser: io.NopCloser(strings.NewReader(body))}
+	req := httptest.NewRequest("POST", "/Attach", r)
+	req.Header.Set("Content-Type", contentType)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != 413 || r.n > 1<<20 {
+		t.Errorf("expected 413 after reading at most 1 MiB, got %d after reading %d bytes", w.Code, r.n)
+	}
+}
+
+type streamService struct {
+	Routes struct {
+		Store string `route:"PUT /blobs/{name}"`
+	}
+}
+
+func (streamService) Ingest(body io.Reader) (int64, error) {
+	return io.Copy(io.Discard, body)
+}
+
+func (streamService) Store(args struct {
+	Name string `path:"name"`
+	Kind string `query:"kind"`
+}, body io.ReadCloser) (string, error) {
+	b, err := io.ReadAll(body)
+	return args.Name + " " + args.Kind + " " + string(b), err
+}
+
+func TestHandlerStreamingBody(t *testing.T) {
+	handler, err := NewHandler(streamService{}, WithStrictSignatures(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		method       string
+		path         string
+		body         string
+		expectedBody string
+	}{
+		{"POST", "/Ingest", strings.Repeat("x", 1<<16), "65536\n"},
+		{"POST", "/Ingest", "not JSON", "8\n"},
+		{"PUT", "/blobs/a?kind=raw", "{\"Name\":\"b\"}", "\"a raw {\\\"Name\\\":\\\"b\\\"}\"\n"},
+	}
+	for _, tc := range testCases {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body)))
+		if w.Code != 200 || w.Body.String() != tc.expectedBody {
+			t.Errorf("%s %s: expected 200 with body %q, got %d with body %q", tc.method, tc.path, tc.expectedBody, w.Code, w.Body.String())
+		}
+	}
+}
+
+type writerService struct{}
+
+func (writerService) Download(w http.ResponseWriter, args struct{ Name string }) error {
+	w.Header().Set("Content-Type", "text/csv")
+	w.WriteHeader(http.StatusCreated)
+	_, _ = io.WriteString(w, "name\n"+args.Name+"\n")
+	return errors.New("ignored")
+}
+
+func (writerService) Tagged(w http.ResponseWriter) string {
+	w.Header().Set("X-Tag", "v1")
+	return "body"
+}
+
+func (writerService) Empty(w http.ResponseWriter) error {
+	return NewError(http.StatusTeapot, errors.New("no content"))
+}
+
+func (writerService) Progress(w http.ResponseWriter) error {
+	f, ok := w.(http.Flusher)
+	if !ok {
+		return errors.New("not an http.Flusher")
+	}
+	_, _ = io.WriteString(w, "50%\n")
+	f.Flush()
+	return nil
+}
+
+func (writerService) Upgrade(w http.ResponseWriter) error {
+	h, ok := w.(http.Hijacker)
+	if !ok {
+		return errors.New("not an http.Hijacker")
+	}
+	conn, brw, err := h.Hijack()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, _ = brw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: echo\r\nConnection: Upgrade\r\n\r\nhello")
+	return brw.Flush()
+}
+
+func TestHandlerResponseWriterArgument(t *testing.T) {
+	testCases := []testCase{
+		{
+			name:               "method writes the response",
+			httpMethod:         "POST",
+			path:               "/Download",
+			body:               `{"Name":"ann"}`,
+			expectedStatusCode: 201,
+			expectedHeaders:    map[string]string{"Content-Type": "text/csv"},
+			expectedBody:       "name\nann\n",
+		},
+		{
+			name:               "method sets headers only",
+			httpMethod:         "POST",
+			path:               "/Tagged",
+			expectedStatusCode: 200,
+			expectedHeaders:    map[string]string{"X-Tag": "v1"},
+			expectedBody:       "\"body\"\n",
+		},
+		{
+			name:               "method writes nothing",
+			httpMethod:         "POST",
+			path:               "/Empty",
+			expectedStatusCode: 418,
+			expectedBody:       "{\"error\":\"no content\"}\n",
+		},
+		{
+			name:               "method flushes",
+			httpMethod:         "POST",
+			path:               "/Progress",
+			expectedStatusCode: 200,
+			expectedBody:       "50%\n",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			Handler(writerService{}).ServeHTTP(w, httptest.NewRequest(tc.httpMethod, tc.path, strings.NewReader(tc.body)))
+			if tc.path == "/Progress" && !w.Flushed {
+				t.Error("expected the response to be flushed")
+			}
+			if w.Code != tc.expectedStatusCode || w.Body.String() != tc.expectedBody {
+				t.Errorf("expected %d with body %q, got %d with body %q", tc.expectedStatusCode, tc.expectedBody, w.Code, w.Body.String())
+			}
+			for name, value := range tc.expectedHeaders {
+				if got := w.Header().Get(name); got != value {
+					t.Errorf("expected header %s %q, got %q", name, value, got)
+				}
+			}
+		})
+	}
+}
+
+func TestHandlerResponseWriterHijack(t *testing.T) {
+	server := httptest.NewServer(Handler(writerService{}))
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_, _ = io.WriteString(conn, "POST /Upgrade HTTP/1.1\r\nHost: example.com\r\nContent-Length: 0\r\n\r\n")
+	b, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(b), "HTTP/1.1 101 ") || !strings.HasSuffix(string(b), "\r\n\r\nhello") {
+		t.Errorf("expected the hijacked connection's response, got %q", b)
+	}
+}
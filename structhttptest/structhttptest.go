@@ -0,0 +1,54 @@
+// Package structhttptest provides utilities for testing handlers
+// created with structhttp.
+package structhttptest
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/jfhamlin/structhttp"
+)
+
+// AssertRoutes fails the test if the routes structhttp.Handler would
+// expose for s, configured with opts, differ from want. Routes are
+// compared by method name and signature, and also by HTTP method and
+// pattern if the wanted route sets a pattern. The failure message
+// lists routes that are missing (-), unexpected (+), or have a
+// different signature or endpoint (~).
+func AssertRoutes(t testing.TB, s any, want []structhttp.Route, opts ...structhttp.Option) {
+	t.Helper()
+
+	got := structhttp.Describe(s, opts...)
+
+	gotByName := make(map[string]structhttp.Route, len(got))
+	for _, route := range got {
+		gotByName[route.Name] = route
+	}
+	wantByName := make(map[string]structhttp.Route, len(want))
+	for _, route := range want {
+		wantByName[route.Name] = route
+	}
+
+	var diff []string
+	for _, w := range want {
+		g, ok := gotByName[w.Name]
+		switch {
+		case !ok:
+			diff = append(diff, fmt.Sprintf("- %s", w))
+		case g.String() != w.String():
+			diff = append(diff, fmt.Sprintf("~ %s: want %s, got %s", w.Name, w, g))
+		case w.Pattern != "" && (g.HTTPMethod != w.HTTPMethod || g.Pattern != w.Pattern):
+			diff = append(diff, fmt.Sprintf("~ %s: want %s %s, got %s %s", w.Name, w.HTTPMethod, w.Pattern, g.HTTPMethod, g.Pattern))
+		}
+	}
+	for _, g := range got {
+		if _, ok := wantByName[g.Name]; !ok {
+			diff = append(diff, fmt.Sprintf("+ %s", g))
+		}
+	}
+
+	if len(diff) > 0 {
+		t.Errorf("routes differ (-missing +unexpected ~changed):\n%s", strings.Join(diff, "\n"))
+	}
+}
@@ -0,0 +1,91 @@
+package structhttptest
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/jfhamlin/structhttp"
+)
+
+type (
+	service struct{}
+
+	item struct {
+		Name string
+	}
+
+	recordingTB struct {
+		testing.TB
+		errors []string
+	}
+)
+
+func (s *service) Create(ctx context.Context, it *item) (*item, error) {
+	return it, nil
+}
+
+func (s *service) Delete(name string) error {
+	return nil
+}
+
+func (s *service) Ping() {}
+
+func (tb *recordingTB) Helper() {}
+
+func (tb *recordingTB) Errorf(format string, args ...any) {
+	tb.errors = append(tb.errors, fmt.Sprintf(format, args...))
+}
+
+var (
+	itemType   = reflect.TypeOf(&item{})
+	errorType  = reflect.TypeOf((*error)(nil)).Elem()
+	stringType = reflect.TypeOf("")
+)
+
+func TestAssertRoutes(t *testing.T) {
+	AssertRoutes(t, &service{}, []structhttp.Route{
+		{Name: "Create", Args: []reflect.Type{itemType}, Results: []reflect.Type{itemType, errorType}},
+		{Name: "Delete", Args: []reflect.Type{stringType}, Results: []reflect.Type{errorType}},
+		{Name: "Ping"},
+	})
+}
+
+func TestAssertRoutesDiff(t *testing.T) {
+	tb := &recordingTB{TB: t}
+	AssertRoutes(tb, &service{}, []structhttp.Route{
+		{Name: "Create", Args: []reflect.Type{stringType}, Results: []reflect.Type{itemType, errorType}},
+		{Name: "Delete", Args: []reflect.Type{stringType}, Results: []reflect.Type{errorType}},
+		{Name: "Update", Args: []reflect.Type{itemType}, Results: []reflect.Type{errorType}},
+	})
+
+	expected := "routes differ (-missing +unexpected ~changed):\n" +
+		"~ Create: want Create(string) (*structhttptest.item, error), got Create(*structhttptest.item) (*structhttptest.item, error)\n" +
+		"- Update(*structhttptest.item) error\n" +
+		"+ Ping()"
+	if len(tb.errors) != 1 || tb.errors[0] != expected {
+		t.Errorf("expected error %q, got %q", expected, tb.errors)
+	}
+}
+
+func TestAssertRoutesEndpoints(t *testing.T) {
+	AssertRoutes(t, &service{}, []structhttp.Route{
+		{Name: "Create", HTTPMethod: "POST", Pattern: "/Create", Args: []reflect.Type{itemType}, Results: []reflect.Type{itemType, errorType}},
+		{Name: "Delete", HTTPMethod: "POST", Pattern: "/Delete", Args: []reflect.Type{stringType}, Results: []reflect.Type{errorType}},
+		{Name: "Ping"},
+	})
+
+	tb := &recordingTB{TB: t}
+	AssertRoutes(tb, &service{}, []structhttp.Route{
+		{Name: "Create", Args: []reflect.Type{itemType}, Results: []reflect.Type{itemType, errorType}},
+		{Name: "Delete", HTTPMethod: "DELETE", Pattern: "/Delete", Args: []reflect.Type{stringType}, Results: []reflect.Type{errorType}},
+		{Name: "Ping"},
+	})
+
+	expected := "routes differ (-missing +unexpected ~changed):\n" +
+		"~ Delete: want DELETE /Delete, got POST /Delete"
+	if len(tb.errors) != 1 || tb.errors[0] != expected {
+		t.Errorf("expected error %q, got %q", expected, tb.errors)
+	}
+}
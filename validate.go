@@ -0,0 +1,133 @@
+package structhttp
+
+import (
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+var (
+	jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// validate reports the problems found while building the handler,
+// such as invalid route tags, and the methods whose body-bound
+// argument clearly cannot be decoded from JSON. Arguments are only
+// checked for handlers that decode bodies with DefaultMatcherFunc or
+// QueryMatcherFunc; the arguments of custom matchers are unknown.
+func (sh *structHandler) validate() error {
+	errs := sh.errs
+	if !sh.decodesJSON() {
+		return errors.Join(errs...)
+	}
+
+	for i := range sh.methods {
+		method := &sh.methods[i]
+		if len(method.argTypes) != 1 {
+			continue
+		}
+		argType := method.argTypes[0]
+		if err := checkDecodable(argType, make(map[reflect.Type]bool)); err != nil {
+			errs = append(errs, fmt.Errorf("method %s: argument type %s cannot be decoded from JSON: %w", method.Name, argType, err))
+		}
+		if err := sh.applyDefaults(reflect.New(argType).Elem()); err != nil {
+			errs = append(errs, fmt.Errorf("method %s: %w", method.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// decodesJSON reports whether any matcher is DefaultMatcherFunc or
+// QueryMatcherFunc, which decode non-GET request bodies as JSON.
+func (sh *structHandler) decodesJSON() bool {
+	for _, matcher := range sh.matchers {
+		if isDefaultMatcher(matcher) {
+			return true
+		}
+	}
+	return false
+}
+
+// onlyDefaultMatchers reports whether every matcher is
+// DefaultMatcherFunc or QueryMatcherFunc, which match only methods
+// taking at most one argument.
+func (sh *structHandler) onlyDefaultMatchers() bool {
+	for _, matcher := range sh.matchers {
+		if !isDefaultMatcher(matcher) {
+			return false
+		}
+	}
+	return true
+}
+
+// isDefaultMatcher reports whether m is DefaultMatcherFunc or
+// QueryMatcherFunc.
+func isDefaultMatcher(m Matcher) bool {
+	f, ok := m.(MatcherFunc)
+	if !ok {
+		return false
+	}
+	switch reflect.ValueOf(f).Pointer() {
+	case reflect.ValueOf(DefaultMatcherFunc).Pointer(), reflect.ValueOf(QueryMatcherFunc).Pointer():
+		return true
+	}
+	return false
+}
+
+// checkDecodable returns an error if t, or a type it contains, is of
+// a kind encoding/json cannot decode into, such as a channel or
+// function. Types implementing json.Unmarshaler or
+// encoding.TextUnmarshaler are assumed to be decodable.
+func checkDecodable(t reflect.Type, seen map[reflect.Type]bool) error {
+	if seen[t] {
+		return nil
+	}
+	seen[t] = true
+
+	if unmarshals(t) {
+		return nil
+	}
+
+	switch t.Kind() {
+	case reflect.Chan, reflect.Func, reflect.UnsafePointer, reflect.Complex64, reflect.Complex128:
+		return fmt.Errorf("unsupported type %s", t)
+	case reflect.Pointer, reflect.Slice, reflect.Array:
+		return checkDecodable(t.Elem(), seen)
+	case reflect.Map:
+		switch key := t.Key(); key.Kind() {
+		case reflect.String,
+			reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		default:
+			if !reflect.PointerTo(key).Implements(textUnmarshalerType) {
+				return fmt.Errorf("unsupported map key type %s", key)
+			}
+		}
+		return checkDecodable(t.Elem(), seen)
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if (!field.IsExported() && !field.Anonymous) || field.Tag.Get("json") == "-" {
+				continue
+			}
+			if err := checkDecodable(field.Type, seen); err != nil {
+				return fmt.Errorf("field %s: %w", field.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// unmarshals reports whether t or a pointer to t implements
+// json.Unmarshaler or encoding.TextUnmarshaler.
+func unmarshals(t reflect.Type) bool {
+	for _, u := range []reflect.Type{jsonUnmarshalerType, textUnmarshalerType} {
+		if t.Implements(u) || reflect.PointerTo(t).Implements(u) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,80 @@
+package structhttp
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"net/http"
+)
+
+// responseWriter wraps an http.ResponseWriter to record the status
+// code and size of the response.
+type responseWriter struct {
+	http.ResponseWriter
+	status  int
+	written int64
+
+	// discardBody drops the response body, as for HEAD requests.
+	discardBody bool
+
+	// recorded, if not nil, receives a copy of the response body. It
+	// is dropped if the body grows past recordLimit bytes.
+	recorded    *bytes.Buffer
+	recordLimit int
+}
+
+func (rw *responseWriter) WriteHeader(code int) {
+	if rw.status == 0 {
+		rw.status = code
+	}
+	rw.ResponseWriter.WriteHeader(code)
+}
+
+func (rw *responseWriter) Write(b []byte) (int, error) {
+	if rw.status == 0 {
+		rw.status = http.StatusOK
+	}
+	if rw.discardBody {
+		return len(b), nil
+	}
+	n, err := rw.ResponseWriter.Write(b)
+	rw.written += int64(n)
+	if rw.recorded != nil {
+		if rw.recorded.Len()+n > rw.recordLimit {
+			rw.recorded = nil
+		} else {
+			rw.recorded.Write(b[:n])
+		}
+	}
+	return n, err
+}
+
+// Unwrap returns the underlying http.ResponseWriter for use with
+// http.ResponseController.
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// Flush sends any buffered data to the client, for methods that assert
+// that their http.ResponseWriter is an http.Flusher. It does nothing
+// if the underlying http.ResponseWriter cannot flush.
+func (rw *responseWriter) Flush() {
+	if rw.status == 0 {
+		rw.status = http.StatusOK
+	}
+	_ = http.NewResponseController(rw.ResponseWriter).Flush()
+}
+
+// Hijack lets the caller take over the connection, for methods that
+// assert that their http.ResponseWriter is an http.Hijacker. It
+// returns an error wrapping http.ErrNotSupported if the underlying
+// http.ResponseWriter cannot be hijacked. Once hijacked, the response
+// is recorded as a switch of protocols, so that the method's results
+// are not written.
+func (rw *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := http.NewResponseController(rw.ResponseWriter).Hijack()
+	if err == nil && rw.status == 0 {
+		rw.status = http.StatusSwitchingProtocols
+	}
+	return conn, brw, err
+}
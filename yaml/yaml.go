@@ -0,0 +1,50 @@
+// Package yaml provides a structhttp.Codec for YAML, backed by
+// sigs.k8s.io/yaml:
+//
+//	structhttp.Handler(svc, structhttp.WithCodecs(yaml.Codec))
+package yaml
+
+import (
+	"io"
+
+	sigsyaml "sigs.k8s.io/yaml"
+
+	"github.com/jfhamlin/structhttp"
+)
+
+// ContentType is the Content-Type of YAML bodies, that of Codec.
+const ContentType = "application/yaml"
+
+// Codec is the structhttp.Codec for ContentType, encoding with
+// ResponseEncoder and decoding with RequestDecoder. Clients that send
+// the older application/x-yaml or text/yaml can be served by
+// registering RequestDecoder for those types as well.
+var Codec = structhttp.NewCodec(ContentType, ResponseEncoder, RequestDecoder)
+
+// ResponseEncoder is a structhttp.ResponseEncoder that encodes results
+// as a YAML document with the mapping of encoding/json: structs are
+// encoded as mappings keyed by their fields' JSON names, and values
+// implementing json.Marshaler or encoding.TextMarshaler, such as a
+// time.Time, as they encode themselves in JSON. Mapping keys are
+// sorted, including those of an OrderedMap.
+func ResponseEncoder(w io.Writer, v any) error {
+	b, err := sigsyaml.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+// RequestDecoder is a structhttp.RequestDecoder that decodes a YAML
+// document into v with the mapping of encoding/json, as described for
+// ResponseEncoder. Into an any, values are decoded as encoding/json
+// decodes them, with numbers as float64. As JSON is YAML, JSON bodies
+// are accepted too.
+func RequestDecoder(r io.Reader, v any) error {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	return sigsyaml.Unmarshal(b, v)
+}
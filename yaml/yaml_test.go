@@ -0,0 +1,127 @@
+package yaml
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"net/netip"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jfhamlin/structhttp"
+)
+
+type record struct {
+	ID      int            `json:"id"`
+	Skipped string         `json:"-"`
+	Empty   string         `json:",omitempty"`
+	Addr    netip.Addr     `json:"addr"`
+	Created time.Time      `json:"created"`
+	Data    []byte         `json:"data"`
+	Labels  map[string]int `json:"labels"`
+}
+
+func TestEncoding(t *testing.T) {
+	testCases := []struct {
+		name string
+		v    any
+		want string
+	}{
+		{"nil", nil, "null\n"},
+		{"numeric string", "123", "\"123\"\n"},
+		{"boolean string", "no", "\"no\"\n"},
+		{"bytes", []byte("hi"), "aGk=\n"},
+		{"time", time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), "\"2024-01-02T03:04:05Z\"\n"},
+		{"text marshaler", netip.MustParseAddr("10.0.0.1"), "10.0.0.1\n"},
+		{"sorted map", map[string]int{"b": 1, "a": 2}, "a: 2\nb: 1\n"},
+		{"struct", record{ID: 1, Skipped: "x"}, "addr: \"\"\ncreated: \"0001-01-01T00:00:00Z\"\ndata: null\nid: 1\nlabels: null\n"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := ResponseEncoder(&buf, tc.v); err != nil {
+				t.Fatal(err)
+			}
+			if got := buf.String(); got != tc.want {
+				t.Errorf("expected %q, got %q", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	want := record{
+		ID:      7,
+		Addr:    netip.MustParseAddr("10.0.0.1"),
+		Created: time.Unix(1700000000, 123456789).UTC(),
+		Data:    []byte{0, 1, 2},
+		Labels:  map[string]int{"a": 1},
+	}
+	var buf bytes.Buffer
+	if err := ResponseEncoder(&buf, want); err != nil {
+		t.Fatal(err)
+	}
+	var got record
+	if err := RequestDecoder(&buf, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %+v, got %+v", want, got)
+	}
+}
+
+func TestDecoding(t *testing.T) {
+	testCases := []struct {
+		name string
+		data string
+		want any
+	}{
+		{"comments", "# ops tooling\nid: 1 # inline\n", map[string]any{"id": float64(1)}},
+		{"flow", "{id: 1, tags: [a, b]}", map[string]any{"id": float64(1), "tags": []any{"a", "b"}}},
+		{"explicit key", "? id\n: 1\n", map[string]any{"id": float64(1)}},
+		{"anchors", "base: &b {x: 1}\nother: *b\n", map[string]any{"base": map[string]any{"x": float64(1)}, "other": map[string]any{"x": float64(1)}}},
+		{"JSON", `{"id": 1}`, map[string]any{"id": float64(1)}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var got any
+			if err := RequestDecoder(strings.NewReader(tc.data), &got); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("expected %#v, got %#v", tc.want, got)
+			}
+		})
+	}
+
+	var v record
+	if err := RequestDecoder(strings.NewReader("id: [1"), &v); err == nil {
+		t.Error("expected an error decoding malformed YAML")
+	}
+}
+
+type service struct{}
+
+func (service) Echo(args struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}) any {
+	return args
+}
+
+func TestHandler(t *testing.T) {
+	handler := structhttp.Handler(service{}, structhttp.WithCodecs(Codec))
+
+	r := httptest.NewRequest("POST", "/Echo", strings.NewReader("# ops tooling\nid: 1\nname: x\n"))
+	r.Header.Set("Content-Type", ContentType)
+	r.Header.Set("Accept", ContentType)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if want := "id: 1\nname: x\n"; w.Code != 200 || w.Body.String() != want {
+		t.Errorf("expected 200 with body %q, got %d with body %q", want, w.Code, w.Body.String())
+	}
+	if got := w.Header().Get("Content-Type"); got != ContentType {
+		t.Errorf("expected Content-Type %q, got %q", ContentType, got)
+	}
+}